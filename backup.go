@@ -0,0 +1,302 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash"
+	"hash/crc32"
+	"io"
+	"syscall"
+)
+
+// BACKUP_VERSION is the version of the backup archive format
+const BACKUP_VERSION uint32 = 1
+
+// kvsCreateParams are the Kvs parameters which can only be set when the Kvs is
+// created, and so are recorded in backups
+var kvsCreateParams = []string{"prefix.length"}
+
+// A backup archive is laid out as follows. All integers are big-endian.
+//
+//	header:     magic[8] version(u32) kvsCount(u32)
+//	kvs:        descriptor stream
+//	descriptor: nameLen(u16) name paramCount(u16) (paramLen(u16) param)* crc(u32)
+//
+// Each stream is in the format written by Kvs.Export(), and each param is of
+// the form "key=value" as passed to Kvdb.KvsCreate(). A descriptor's crc is the
+// CRC-32C of the descriptor bytes preceding it.
+var backupMagic = [8]byte{'H', 'S', 'E', 'G', 'O', 'B', 'A', 'K'}
+
+// paramArg converts a parameter value in its JSON representation to the form
+// expected in a "key=value" parameter
+func paramArg(param string, value string) string {
+	var s string
+
+	if json.Unmarshal([]byte(value), &s) == nil {
+		value = s
+	}
+
+	return param + "=" + value
+}
+
+type descriptorWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	err error
+}
+
+func (d *descriptorWriter) string(s string) {
+	var buf [2]byte
+
+	binary.BigEndian.PutUint16(buf[:], uint16(len(s)))
+	d.write(buf[:])
+	d.write([]byte(s))
+}
+
+func (d *descriptorWriter) write(p []byte) {
+	if d.err != nil {
+		return
+	}
+
+	d.crc.Write(p)
+	_, d.err = d.w.Write(p)
+}
+
+type descriptorReader struct {
+	r   io.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (d *descriptorReader) read(p []byte) {
+	if d.err != nil {
+		return
+	}
+
+	if _, d.err = io.ReadFull(d.r, p); d.err == io.EOF {
+		d.err = io.ErrUnexpectedEOF
+	}
+	d.crc.Write(p)
+}
+
+func (d *descriptorReader) uint16() uint16 {
+	var buf [2]byte
+
+	d.read(buf[:])
+
+	return binary.BigEndian.Uint16(buf[:])
+}
+
+func (d *descriptorReader) string() string {
+	buf := make([]byte, d.uint16())
+	d.read(buf)
+
+	return string(buf)
+}
+
+// Backup writes a point-in-time consistent copy of every Kvs in the Kvdb to w
+//
+// All of the Kvs are read from the snapshot of a single transaction, so the
+// backup reflects the Kvdb at one instant while the application keeps running.
+// The create-time parameters of each Kvs are recorded so that KvdbRestore()
+// can recreate it. A Kvs which is not open is opened for the duration of the
+// backup. The backup stops early if ctx is done. This function is thread safe.
+func (k *Kvdb) Backup(ctx context.Context, w io.Writer) error {
+	names, err := k.KvsNames()
+	if err != nil {
+		return err
+	}
+
+	txn := k.NewTransaction()
+	if txn == nil {
+		return syscall.ENOMEM
+	}
+	defer txn.Free()
+
+	if err = txn.Begin(); err != nil {
+		return err
+	}
+	defer txn.Abort()
+
+	return k.backupTxn(ctx, txn, names, w)
+}
+
+// backupTxn writes the named Kvs from the snapshot of txn to w
+func (k *Kvdb) backupTxn(ctx context.Context, txn *Transaction, names []string, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	var header [16]byte
+	copy(header[:], backupMagic[:])
+	binary.BigEndian.PutUint32(header[8:], BACKUP_VERSION)
+	binary.BigEndian.PutUint32(header[12:], uint32(len(names)))
+	if _, err := bw.Write(header[:]); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := k.backupKvs(ctx, txn, name, bw); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func (k *Kvdb) backupKvs(ctx context.Context, txn *Transaction, name string, w io.Writer) error {
+	kvs := k.KvsHandle(name)
+	if kvs == nil {
+		var err error
+
+		if kvs, err = k.KvsOpen(name); err != nil {
+			return err
+		}
+		defer kvs.Close()
+	}
+
+	params := make([]string, 0, len(kvsCreateParams))
+	for _, param := range kvsCreateParams {
+		value, err := kvs.ParamGet(param)
+		if err != nil {
+			return err
+		}
+
+		params = append(params, paramArg(param, value))
+	}
+
+	d := descriptorWriter{w: w, crc: crc32.New(castagnoli)}
+	d.string(name)
+
+	var buf [4]byte
+	binary.BigEndian.PutUint16(buf[:2], uint16(len(params)))
+	d.write(buf[:2])
+	for _, param := range params {
+		d.string(param)
+	}
+
+	binary.BigEndian.PutUint32(buf[:], d.crc.Sum32())
+	d.write(buf[:])
+	if d.err != nil {
+		return d.err
+	}
+
+	sw := newStreamWriter(w, nil)
+	if err := sw.writeHeader(); err != nil {
+		return err
+	}
+	if err := kvs.exportTxn(ctx, txn, sw); err != nil {
+		return err
+	}
+
+	return sw.writeTrailer()
+}
+
+// KvdbRestore creates a new Kvdb at home from a backup written by Kvdb.Backup()
+//
+// Every Kvs in the backup is created with its recorded parameters and loaded
+// with its data. params are passed to KvdbCreate(). It is an error if a Kvdb
+// already exists at home. The restore stops early if ctx is done, leaving a
+// partially restored Kvdb behind. This function is not thread safe.
+func KvdbRestore(ctx context.Context, home string, r io.Reader, params ...string) error {
+	br := bufio.NewReader(r)
+
+	count, err := readBackupHeader(br)
+	if err != nil {
+		return err
+	}
+
+	if err = KvdbCreate(home, params...); err != nil {
+		return err
+	}
+
+	kvdb, err := KvdbOpen(home, nil)
+	if err != nil {
+		return err
+	}
+	defer kvdb.Close()
+
+	return kvdb.restore(ctx, br, count)
+}
+
+// readBackupHeader checks the header of a backup and returns its Kvs count
+func readBackupHeader(r io.Reader) (uint32, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	var magic [8]byte
+	copy(magic[:], header[:8])
+	if magic != backupMagic || binary.BigEndian.Uint32(header[8:]) != BACKUP_VERSION {
+		return 0, ErrStreamFormat
+	}
+
+	return binary.BigEndian.Uint32(header[12:]), nil
+}
+
+// restore creates and loads the count Kvs following a backup's header
+func (k *Kvdb) restore(ctx context.Context, r io.Reader, count uint32) error {
+	for ; count > 0; count-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := k.restoreKvs(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (k *Kvdb) restoreKvs(r io.Reader) error {
+	d := descriptorReader{r: r, crc: crc32.New(castagnoli)}
+
+	name := d.string()
+	params := make([]string, d.uint16())
+	for i := range params {
+		params[i] = d.string()
+	}
+
+	sum := d.crc.Sum32()
+
+	var buf [4]byte
+	d.read(buf[:])
+	if d.err != nil {
+		return d.err
+	}
+	if binary.BigEndian.Uint32(buf[:]) != sum {
+		return ErrStreamChecksum
+	}
+
+	if err := k.KvsCreate(name, params...); err != nil {
+		return err
+	}
+
+	// The records are put in transactions, see Kvs.Import()
+	kvs, err := k.KvsOpen(name, "transactions.enabled=true")
+	if err != nil {
+		return err
+	}
+	defer kvs.Close()
+
+	sr := newStreamReader(r, nil)
+	if err = sr.readHeader(); err != nil {
+		return err
+	}
+
+	if _, err = kvs.importRecords(sr); err != io.EOF {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const (
+	backupTestKvsName = "backup-test"
+)
+
+func TestBackupRestore(t *testing.T) {
+	var kvsParams params
+
+	kvsParams.SetCparams("prefix.length=2")
+
+	kvs := makeAndOpenKvs(backupTestKvsName, kvsParams)
+	defer kvdb.KvsDrop(backupTestKvsName)
+	defer kvs.Close()
+
+	kvs.Put([]byte("aa1"), []byte("value1"), 0)
+	kvs.Put([]byte("bb2"), []byte("value2"), 0)
+
+	var buf bytes.Buffer
+	if err := kvdb.Backup(context.Background(), &buf); err != nil {
+		t.Fatalf("failed to back up kvdb: %s", err)
+	}
+
+	home, err := ioutil.TempDir("", "hse-go-restore")
+	if err != nil {
+		t.Fatalf("failed to create restore directory: %s", err)
+	}
+	defer os.RemoveAll(home)
+
+	if err = KvdbRestore(context.Background(), home, &buf); err != nil {
+		t.Fatalf("failed to restore kvdb: %s", err)
+	}
+
+	restored, err := KvdbOpen(home, nil)
+	if err != nil {
+		t.Fatalf("failed to open restored kvdb: %s", err)
+	}
+	defer restored.Close()
+
+	restoredKvs, err := restored.KvsOpen(backupTestKvsName)
+	if err != nil {
+		t.Fatalf("failed to open restored kvs: %s", err)
+	}
+	defer restoredKvs.Close()
+
+	pfxLen, err := restoredKvs.ParamGet("prefix.length")
+	if err != nil {
+		t.Fatalf("failed to get prefix.length: %s", err)
+	}
+	if pfxLen != "2" {
+		t.Fatalf("restored kvs has prefix.length %s, expected 2", pfxLen)
+	}
+
+	value, _, err := restoredKvs.Get([]byte("bb2"), 0)
+	if err != nil {
+		t.Fatalf("failed to get bb2: %s", err)
+	}
+	if string(value) != "value2" {
+		t.Fatalf("unexpected value for bb2: %s", value)
+	}
+}
@@ -0,0 +1,381 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+
+	"github.com/hse-project/hse-go/limits"
+)
+
+// BLOB_CHUNK_SIZE is the number of bytes of a blob stored under each chunk key
+const BLOB_CHUNK_SIZE = int(limits.KVS_VALUE_LEN_MAX)
+
+var (
+	// ErrBlobNotFound is returned when opening a blob which does not exist
+	ErrBlobNotFound = errors.New("hse: blob not found")
+	// ErrBlobFormat is returned when the header or a chunk of a blob is
+	// missing or malformed
+	ErrBlobFormat = errors.New("hse: invalid blob")
+	// ErrBlobClosed is returned when using a BlobWriter or BlobReader after it
+	// has been closed
+	ErrBlobClosed = errors.New("hse: blob is closed")
+)
+
+// A blob stored under key is laid out as follows. All integers are
+// big-endian.
+//
+//	header: key               -> version(u8) size(u64) chunkSize(u32)
+//	chunk:  key index(u32)    -> chunkSize bytes, fewer for the last chunk
+//
+// Every key of a blob starts with the blob's key, so one prefix delete removes
+// all of them.
+const (
+	blobVersion   = 1
+	blobHeaderLen = 13
+)
+
+func blobChunkKey(key []byte, index uint32) []byte {
+	chunk := make([]byte, len(key)+4)
+	copy(chunk, key)
+	binary.BigEndian.PutUint32(chunk[len(key):], index)
+
+	return chunk
+}
+
+// BlobWriter writes a blob larger than limits.KVS_VALUE_LEN_MAX
+//
+// Chunks are put as they fill within a transaction which is committed by
+// Close(), so readers see either the whole blob or none of it. A BlobWriter is
+// not thread safe.
+type BlobWriter struct {
+	kvs   *Kvs
+	key   []byte
+	txn   *Transaction
+	buf   []byte
+	index uint32
+	size  uint64
+	err   error
+}
+
+// CreateBlob returns a BlobWriter which replaces the blob stored under key
+//
+// The blob is stored under keys starting with key, so key must not be a prefix
+// of any other key in the Kvs, and it is subject to the same restrictions as
+// the filter of Kvs.PrefixDelete(). The writes are not retried if the
+// transaction conflicts with another transaction, in which case Close() returns
+// syscall.ECANCELED. The Kvs must be opened with "transactions.enabled=true".
+func (k *Kvs) CreateBlob(key []byte) (*BlobWriter, error) {
+	txn := k.kvdb.NewTransaction()
+	if txn == nil {
+		return nil, syscall.ENOMEM
+	}
+
+	if err := txn.Begin(); err != nil {
+		txn.Free()
+		return nil, err
+	}
+
+	// Prefix deletes within a transaction take effect before its puts
+	if err := txn.PrefixDelete(k, key, 0); err != nil {
+		txn.Abort()
+		txn.Free()
+		return nil, err
+	}
+
+	return &BlobWriter{
+		kvs: k,
+		key: append([]byte(nil), key...),
+		txn: txn,
+		buf: make([]byte, 0, BLOB_CHUNK_SIZE),
+	}, nil
+}
+
+// Write appends p to the blob
+//
+// Once a write fails, every later call to Write() or Close() fails with the
+// same error and the blob is left unchanged.
+func (b *BlobWriter) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n := 0
+	for len(p) > 0 {
+		m := copy(b.buf[len(b.buf):cap(b.buf)], p)
+		b.buf = b.buf[:len(b.buf)+m]
+		p = p[m:]
+		n += m
+
+		if len(b.buf) == cap(b.buf) {
+			if err := b.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (b *BlobWriter) flush() error {
+	if err := b.txn.Put(b.kvs, blobChunkKey(b.key, b.index), b.buf, 0); err != nil {
+		b.fail(err)
+		return err
+	}
+
+	b.index++
+	b.size += uint64(len(b.buf))
+	b.buf = b.buf[:0]
+
+	return nil
+}
+
+// fail aborts the transaction and records err as the result of all later calls
+func (b *BlobWriter) fail(err error) {
+	if b.txn != nil {
+		if b.txn.State() == TransactionActive {
+			b.txn.Abort()
+		}
+		b.txn.Free()
+		b.txn = nil
+	}
+
+	b.err = err
+	b.buf = nil
+}
+
+// Close writes the remainder of the blob and commits it
+func (b *BlobWriter) Close() error {
+	if b.err != nil {
+		return b.err
+	}
+
+	if len(b.buf) > 0 {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+
+	header := make([]byte, blobHeaderLen)
+	header[0] = blobVersion
+	binary.BigEndian.PutUint64(header[1:], b.size)
+	binary.BigEndian.PutUint32(header[9:], uint32(BLOB_CHUNK_SIZE))
+
+	err := b.txn.Put(b.kvs, b.key, header, 0)
+	if err == nil {
+		err = b.txn.Commit()
+	}
+	if err != nil {
+		b.fail(err)
+		return err
+	}
+
+	b.fail(ErrBlobClosed)
+
+	return nil
+}
+
+// Abort discards the blob, leaving any previous blob stored under the key
+// unchanged
+func (b *BlobWriter) Abort() {
+	if b.err == nil {
+		b.fail(ErrBlobClosed)
+	}
+}
+
+// BlobReader reads a blob from a transaction snapshot taken when it was
+// opened
+//
+// BlobReader implements io.ReadSeeker and io.ReaderAt. A chunk at a time is
+// read from the Kvs. Reads fail once the snapshot exceeds the KVDB's
+// transaction timeout. This type is thread safe.
+type BlobReader struct {
+	mu        sync.Mutex
+	kvs       *Kvs
+	key       []byte
+	txn       *Transaction
+	size      int64
+	chunkSize int64
+	off       int64
+	chunk     []byte
+	index     int64
+}
+
+// OpenBlob returns a BlobReader over the blob stored under key
+//
+// ErrBlobNotFound is returned if the blob does not exist.
+func (k *Kvs) OpenBlob(key []byte) (*BlobReader, error) {
+	txn := k.kvdb.NewTransaction()
+	if txn == nil {
+		return nil, syscall.ENOMEM
+	}
+
+	err := txn.Begin()
+	if err != nil {
+		txn.Free()
+		return nil, err
+	}
+
+	header, _, err := txn.Get(k, key, 0)
+	if err == nil && header == nil {
+		err = ErrBlobNotFound
+	}
+	if err == nil && (len(header) != blobHeaderLen || header[0] != blobVersion ||
+		binary.BigEndian.Uint32(header[9:]) == 0) {
+		err = ErrBlobFormat
+	}
+	if err != nil {
+		txn.Abort()
+		txn.Free()
+		return nil, err
+	}
+
+	return &BlobReader{
+		kvs:       k,
+		key:       append([]byte(nil), key...),
+		txn:       txn,
+		size:      int64(binary.BigEndian.Uint64(header[1:])),
+		chunkSize: int64(binary.BigEndian.Uint32(header[9:])),
+		index:     -1,
+	}, nil
+}
+
+// Size returns the length of the blob in bytes
+func (b *BlobReader) Size() int64 {
+	return b.size
+}
+
+// load reads the chunk at index unless it is the current one
+func (b *BlobReader) load(index int64) error {
+	if index == b.index {
+		return nil
+	}
+
+	chunk, _, err := b.txn.Get(b.kvs, blobChunkKey(b.key, uint32(index)), 0)
+	if err != nil {
+		return err
+	}
+
+	expected := b.chunkSize
+	if remaining := b.size - index*b.chunkSize; remaining < expected {
+		expected = remaining
+	}
+	if int64(len(chunk)) != expected {
+		return ErrBlobFormat
+	}
+
+	b.chunk = chunk
+	b.index = index
+
+	return nil
+}
+
+func (b *BlobReader) readAt(p []byte, off int64) (int, error) {
+	if b.txn == nil {
+		return 0, ErrBlobClosed
+	}
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+
+	n := 0
+	for n < len(p) && off < b.size {
+		if err := b.load(off / b.chunkSize); err != nil {
+			return n, err
+		}
+
+		m := copy(p[n:], b.chunk[off%b.chunkSize:])
+		n += m
+		off += int64(m)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// ReadAt implements io.ReaderAt
+func (b *BlobReader) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.readAt(p, off)
+}
+
+// Read implements io.Reader
+func (b *BlobReader) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n, err := b.readAt(p, b.off)
+	b.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (b *BlobReader) Seek(offset int64, whence int) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.off
+	case io.SeekEnd:
+		offset += b.size
+	default:
+		return 0, syscall.EINVAL
+	}
+
+	if offset < 0 {
+		return 0, syscall.EINVAL
+	}
+
+	b.off = offset
+
+	return offset, nil
+}
+
+// Close releases the snapshot of the BlobReader
+func (b *BlobReader) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.txn == nil {
+		return ErrBlobClosed
+	}
+
+	b.txn.Abort()
+	b.txn.Free()
+	b.txn = nil
+	b.chunk = nil
+
+	return nil
+}
+
+// DeleteBlob deletes the blob stored under key
+//
+// All of the blob's keys are removed with one prefix delete in a transaction.
+// It is not an error if the blob does not exist. This function is thread safe.
+func (k *Kvs) DeleteBlob(key []byte) error {
+	return k.kvdb.Transact(func(txn *Transaction) error {
+		return txn.PrefixDelete(k, key, 0)
+	})
+}
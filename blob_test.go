@@ -0,0 +1,154 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+const (
+	blobTestKvsName = "blob-test"
+)
+
+func writeBlob(t *testing.T, kvs *Kvs, key []byte, data []byte) {
+	w, err := kvs.CreateBlob(key)
+	if err != nil {
+		t.Fatalf("failed to create blob: %s", err)
+	}
+
+	// Uneven writes so that chunks are filled across calls
+	for p := data; len(p) > 0; {
+		n := 100003
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err = w.Write(p[:n]); err != nil {
+			t.Fatalf("failed to write blob: %s", err)
+		}
+		p = p[n:]
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatalf("failed to close blob: %s", err)
+	}
+}
+
+func TestBlob(t *testing.T) {
+	kvs := makeAndOpenKvs(blobTestKvsName, txnParams)
+	defer kvdb.KvsDrop(blobTestKvsName)
+	defer kvs.Close()
+
+	key := []byte("blob")
+	data := make([]byte, 2*BLOB_CHUNK_SIZE+BLOB_CHUNK_SIZE/2)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	writeBlob(t, kvs, key, data)
+
+	r, err := kvs.OpenBlob(key)
+	if err != nil {
+		t.Fatalf("failed to open blob: %s", err)
+	}
+	defer r.Close()
+
+	if r.Size() != int64(len(data)) {
+		t.Fatalf("expected size %d, got %d", len(data), r.Size())
+	}
+
+	read, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read blob: %s", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatal("blob contents differ")
+	}
+
+	// A read spanning a chunk boundary
+	buf := make([]byte, 64)
+	off := int64(BLOB_CHUNK_SIZE - 32)
+	if n, err := r.ReadAt(buf, off); err != nil || n != len(buf) {
+		t.Fatalf("failed to read at %d: %d, %v", off, n, err)
+	}
+	if !bytes.Equal(buf, data[off:off+64]) {
+		t.Fatalf("contents at %d differ", off)
+	}
+
+	if n, err := r.ReadAt(buf, int64(len(data))-10); err != io.EOF || n != 10 {
+		t.Fatalf("expected 10 bytes and EOF at the end, got %d, %v", n, err)
+	}
+
+	if pos, err := r.Seek(-5, io.SeekEnd); err != nil || pos != int64(len(data))-5 {
+		t.Fatalf("failed to seek: %d, %v", pos, err)
+	}
+	if read, err = ioutil.ReadAll(r); err != nil || !bytes.Equal(read, data[len(data)-5:]) {
+		t.Fatalf("unexpected read after seek: %v", err)
+	}
+
+	// Readers keep their snapshot when the blob is replaced
+	writeBlob(t, kvs, key, []byte("small"))
+
+	if n, err := r.ReadAt(buf[:4], 2*int64(BLOB_CHUNK_SIZE)); err != nil || n != 4 {
+		t.Fatalf("failed to read from snapshot: %d, %v", n, err)
+	}
+
+	small, err := kvs.OpenBlob(key)
+	if err != nil {
+		t.Fatalf("failed to open blob: %s", err)
+	}
+	read, err = ioutil.ReadAll(small)
+	small.Close()
+	if err != nil || string(read) != "small" {
+		t.Fatalf("unexpected contents %q after replacing blob: %v", read, err)
+	}
+
+	if value, _, err := kvs.Get(blobChunkKey(key, 1), 0); err != nil || value != nil {
+		t.Fatalf("chunk of replaced blob remains: %v", err)
+	}
+
+	w, err := kvs.CreateBlob(key)
+	if err != nil {
+		t.Fatalf("failed to create blob: %s", err)
+	}
+	w.Write([]byte("aborted"))
+	w.Abort()
+	if err = w.Close(); err != ErrBlobClosed {
+		t.Fatalf("closed an aborted blob: %v", err)
+	}
+
+	if err = kvs.DeleteBlob(key); err != nil {
+		t.Fatalf("failed to delete blob: %s", err)
+	}
+	if _, err = kvs.OpenBlob(key); err != ErrBlobNotFound {
+		t.Fatalf("opened a deleted blob: %v", err)
+	}
+}
+
+func TestBlobEmpty(t *testing.T) {
+	kvs := makeAndOpenKvs(blobTestKvsName, txnParams)
+	defer kvdb.KvsDrop(blobTestKvsName)
+	defer kvs.Close()
+
+	writeBlob(t, kvs, []byte("empty"), nil)
+
+	r, err := kvs.OpenBlob([]byte("empty"))
+	if err != nil {
+		t.Fatalf("failed to open blob: %s", err)
+	}
+
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("expected EOF from an empty blob, got %d, %v", n, err)
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatalf("failed to close blob: %s", err)
+	}
+	if _, err = r.Read(make([]byte, 1)); err != ErrBlobClosed {
+		t.Fatalf("read a closed blob: %v", err)
+	}
+}
@@ -0,0 +1,421 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+)
+
+// CDC_KVS_NAME is the name of the Kvs which holds the change data capture log
+const CDC_KVS_NAME = "hse-go-cdc"
+
+const (
+	// cdcRecordPfx prefixes the keys of log records, which are followed by the
+	// 8 byte big-endian sequence number. A record holds the op, Kvs name and key
+	// of a change.
+	cdcRecordPfx = 'r'
+	// cdcValuePfx prefixes the keys holding the values of put records, keyed
+	// like the records. Values are kept apart from the records so that a value
+	// as large as KVS_VALUE_LEN_MAX can be logged.
+	cdcValuePfx = 'v'
+	// cdcTruncatedKey holds the sequence number the log was last truncated
+	// through, so that sequence numbers are never reused
+	cdcTruncatedKey = "m/truncated"
+	// cdcLastFlag is set in the op byte of the last record of a transaction
+	cdcLastFlag = 0x80
+)
+
+var (
+	// ErrCdcDisabled is returned when reading or truncating the change data
+	// capture log of a Kvdb which does not have it enabled
+	ErrCdcDisabled = errors.New("hse: change data capture is not enabled")
+	// ErrCdcRecord is returned when a log record cannot be decoded
+	ErrCdcRecord = errors.New("hse: invalid change data capture record")
+)
+
+// CdcRecord is a mutation read from the change data capture log
+type CdcRecord struct {
+	// Seq is the sequence number of the record. The records of one
+	// transaction have consecutive sequence numbers, and sequence numbers
+	// increase in commit order.
+	Seq uint64
+	// Kvs is the name of the mutated Kvs
+	Kvs string
+	// Op is the kind of mutation
+	Op ChangeOp
+	// Key is the mutated key, or the filter of a prefix delete
+	Key []byte
+	// Value is the new value of a put
+	Value []byte
+	// Last is whether the record is the last of its transaction
+	Last bool
+}
+
+// cdcLog appends the changes of committing transactions to the log
+type cdcLog struct {
+	kvs *Kvs
+
+	// mu serializes commits so that sequence numbers follow commit order
+	mu   sync.Mutex
+	next uint64
+}
+
+func cdcSeqKey(pfx byte, seq uint64) []byte {
+	key := make([]byte, 9)
+	key[0] = pfx
+	binary.BigEndian.PutUint64(key[1:], seq)
+
+	return key
+}
+
+func cdcRecordKey(seq uint64) []byte {
+	return cdcSeqKey(cdcRecordPfx, seq)
+}
+
+func cdcValueKey(seq uint64) []byte {
+	return cdcSeqKey(cdcValuePfx, seq)
+}
+
+// encodeCdcRecord encodes the op, Kvs name and key of a change, but not its
+// value
+func encodeCdcRecord(c *Change, last bool) []byte {
+	buf := make([]byte, 0, 3+len(c.Kvs)+len(c.Key))
+
+	op := byte(c.Op)
+	if last {
+		op |= cdcLastFlag
+	}
+
+	buf = append(buf, op)
+	buf = append(buf, byte(len(c.Kvs)>>8), byte(len(c.Kvs)))
+	buf = append(buf, c.Kvs...)
+
+	return append(buf, c.Key...)
+}
+
+func decodeCdcRecord(key []byte, value []byte) (CdcRecord, error) {
+	var r CdcRecord
+
+	if len(key) != 9 || len(value) < 3 {
+		return r, ErrCdcRecord
+	}
+
+	r.Seq = binary.BigEndian.Uint64(key[1:])
+	r.Op = ChangeOp(value[0] &^ cdcLastFlag)
+	r.Last = value[0]&cdcLastFlag != 0
+
+	n := int(binary.BigEndian.Uint16(value[1:]))
+	value = value[3:]
+	if len(value) < n {
+		return r, ErrCdcRecord
+	}
+	r.Kvs = string(value[:n])
+	r.Key = append([]byte(nil), value[n:]...)
+
+	return r, nil
+}
+
+// EnableCdc enables the change data capture log of the Kvdb
+//
+// Once enabled, every Put(), Delete() and PrefixDelete() made through this
+// package with the Kvdb's handles also appends a record to the log in the
+// CDC_KVS_NAME Kvs, which is created if needed. The record is written in the
+// same transaction as the mutation, so the log holds exactly the committed
+// mutations. Mutations outside of a transaction are made in one, so every Kvs
+// mutated while the log is enabled must be opened with
+// "transactions.enabled=true", as the CDC_KVS_NAME Kvs is. Commits of
+// transactions with mutations are serialized while the log is enabled. This
+// function is not thread safe.
+func (k *Kvdb) EnableCdc() error {
+	if k.cdc != nil {
+		return nil
+	}
+
+	names, err := k.KvsNames()
+	if err != nil {
+		return err
+	}
+
+	exists := false
+	for _, name := range names {
+		exists = exists || name == CDC_KVS_NAME
+	}
+
+	if !exists {
+		if err = k.KvsCreate(CDC_KVS_NAME); err != nil {
+			return err
+		}
+	}
+
+	kvs, err := k.KvsOpen(CDC_KVS_NAME, "transactions.enabled=true")
+	if err != nil {
+		return err
+	}
+
+	last, err := cdcLastSeq(kvs)
+	if err != nil {
+		kvs.Close()
+		return err
+	}
+
+	k.cdc = &cdcLog{kvs: kvs, next: last + 1}
+
+	return nil
+}
+
+// DisableCdc stops appending to the change data capture log of the Kvdb
+//
+// The log is kept. This function is not thread safe.
+func (k *Kvdb) DisableCdc() error {
+	if k.cdc == nil {
+		return nil
+	}
+
+	err := k.cdc.kvs.Close()
+	k.cdc = nil
+
+	return err
+}
+
+// cdcLastSeq finds the last sequence number used by the log
+func cdcLastSeq(kvs *Kvs) (uint64, error) {
+	last, err := cdcTruncatedSeq(kvs)
+	if err != nil {
+		return 0, err
+	}
+
+	c, err := kvs.CreateCursor([]byte{cdcRecordPfx}, CURSOR_CREATE_REV)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Destroy()
+
+	key, _, err := c.Read(0)
+	if err != nil {
+		return 0, err
+	}
+	if !c.Eof() && len(key) == 9 {
+		if seq := binary.BigEndian.Uint64(key[1:]); seq > last {
+			last = seq
+		}
+	}
+
+	return last, nil
+}
+
+// cdcTruncatedSeq finds the sequence number the log was last truncated
+// through
+func cdcTruncatedSeq(kvs *Kvs) (uint64, error) {
+	value, _, err := kvs.Get([]byte(cdcTruncatedKey), 0)
+	if err != nil || len(value) != 8 {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// logs reports whether mutations of kvs are logged
+func (c *cdcLog) logs(kvs string) bool {
+	return c != nil && !systemKvs(kvs)
+}
+
+// last returns the sequence number of the last committed record
+func (c *cdcLog) last() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.next - 1
+}
+
+// begin begins txn and returns the sequence number of the last record its
+// snapshot includes
+func (c *cdcLog) begin(txn *Transaction) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := txn.Begin(); err != nil {
+		return 0, err
+	}
+
+	return c.next - 1, nil
+}
+
+// commit appends changes to the log within txn and commits it
+func (c *cdcLog) commit(txn *Transaction, changes []Change) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range changes {
+		seq := c.next + uint64(i)
+
+		err := c.kvs.put(txn, cdcRecordKey(seq), encodeCdcRecord(&changes[i], i == len(changes)-1), 0)
+		if err != nil {
+			return err
+		}
+
+		if changes[i].Op == CHANGE_PUT {
+			if err = c.kvs.put(txn, cdcValueKey(seq), changes[i].Value, 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := txn.commit(); err != nil {
+		return err
+	}
+
+	c.next += uint64(len(changes))
+
+	return nil
+}
+
+// CdcReader reads the change data capture log of a Kvdb from a checkpoint
+//
+// For at-least-once delivery, a consumer persists Checkpoint() after
+// processing the records returned by Read() and passes it to NewCdcReader()
+// when it restarts.
+type CdcReader struct {
+	kvdb       *Kvdb
+	checkpoint uint64
+}
+
+// NewCdcReader creates a reader of the records following checkpoint, which is
+// 0 to read from the start of the log
+func (k *Kvdb) NewCdcReader(checkpoint uint64) *CdcReader {
+	return &CdcReader{kvdb: k, checkpoint: checkpoint}
+}
+
+// Checkpoint returns the sequence number of the last record read
+func (r *CdcReader) Checkpoint() uint64 {
+	return r.checkpoint
+}
+
+// Read returns up to max records following the checkpoint and advances the
+// checkpoint past them
+//
+// An empty result means that the reader has caught up with the log.
+func (r *CdcReader) Read(max int) ([]CdcRecord, error) {
+	cdc := r.kvdb.cdc
+	if cdc == nil {
+		return nil, ErrCdcDisabled
+	}
+	if r.checkpoint == math.MaxUint64 {
+		return nil, nil
+	}
+
+	c, err := cdc.kvs.CreateCursor([]byte{cdcRecordPfx}, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Destroy()
+
+	if _, err = c.Seek(cdcRecordKey(r.checkpoint+1), 0); err != nil {
+		return nil, err
+	}
+
+	var records []CdcRecord
+	if max > 0 {
+		err = c.ForEach(func(key []byte, value []byte) error {
+			record, err := decodeCdcRecord(key, value)
+			if err != nil {
+				return err
+			}
+
+			if records = append(records, record); len(records) == max {
+				return ErrStopIteration
+			}
+
+			return nil
+		})
+	}
+
+	// The records read before an error are returned along with it
+	if verr := cdc.readValues(records); verr != nil {
+		return nil, verr
+	}
+	if len(records) > 0 {
+		r.checkpoint = records[len(records)-1].Seq
+	}
+
+	return records, err
+}
+
+// readValues fills in the values of the put records
+func (c *cdcLog) readValues(records []CdcRecord) error {
+	var keys [][]byte
+	var puts []int
+
+	for i := range records {
+		if records[i].Op == CHANGE_PUT {
+			keys = append(keys, cdcValueKey(records[i].Seq))
+			puts = append(puts, i)
+		}
+	}
+
+	values, errs := c.kvs.MultiGet(keys, 0)
+	for i, j := range puts {
+		if errs != nil && errs[i] != nil {
+			return errs[i]
+		}
+		if values[i] == nil {
+			return ErrCdcRecord
+		}
+
+		records[j].Value = values[i]
+	}
+
+	return nil
+}
+
+// CdcTruncate deletes the records of the change data capture log up to and
+// including through, returning the number of deleted records
+//
+// through is limited to the last sequence number in use. Sequence numbers of
+// deleted records are never reused. This function is thread safe.
+func (k *Kvdb) CdcTruncate(through uint64) (uint64, error) {
+	cdc := k.cdc
+	if cdc == nil {
+		return 0, ErrCdcDisabled
+	}
+
+	if last := cdc.last(); through > last {
+		through = last
+	}
+
+	err := k.Transact(func(txn *Transaction) error {
+		value, _, err := txn.Get(cdc.kvs, []byte(cdcTruncatedKey), 0)
+		if err != nil {
+			return err
+		}
+		if len(value) == 8 && binary.BigEndian.Uint64(value) >= through {
+			return nil
+		}
+
+		mark := make([]byte, 8)
+		binary.BigEndian.PutUint64(mark, through)
+
+		return txn.Put(cdc.kvs, []byte(cdcTruncatedKey), mark, 0)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := cdc.kvs.DeleteRange([]byte{cdcRecordPfx}, cdcRecordKey(through+1))
+	if err != nil {
+		return deleted, err
+	}
+
+	// A record whose value is left behind is still truncated, and the value is
+	// deleted by the next truncation
+	if _, err = cdc.kvs.DeleteRange([]byte{cdcValuePfx}, cdcValueKey(through+1)); err != nil {
+		return deleted, err
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,227 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/hse-project/hse-go/limits"
+)
+
+const (
+	cdcTestKvsName      = "cdc-test"
+	cdcTestPlainKvsName = "cdc-test-plain"
+)
+
+func readCdc(t *testing.T, checkpoint uint64) []CdcRecord {
+	records, err := kvdb.NewCdcReader(checkpoint).Read(100)
+	if err != nil {
+		t.Fatalf("failed to read the log: %s", err)
+	}
+
+	return records
+}
+
+func TestCdc(t *testing.T) {
+	kvs := makeAndOpenKvs(cdcTestKvsName, txnParams)
+	defer kvdb.KvsDrop(cdcTestKvsName)
+	defer kvs.Close()
+
+	if _, err := kvdb.NewCdcReader(0).Read(1); err != ErrCdcDisabled {
+		t.Fatalf("read the log while disabled: %v", err)
+	}
+
+	if err := kvdb.EnableCdc(); err != nil {
+		t.Fatalf("failed to enable cdc: %s", err)
+	}
+	defer kvdb.KvsDrop(CDC_KVS_NAME)
+	defer kvdb.DisableCdc()
+
+	if err := kvs.Put([]byte("a"), []byte("1"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	err := kvdb.Transact(func(txn *Transaction) error {
+		if err := txn.Put(kvs, []byte("b"), []byte("2"), 0); err != nil {
+			return err
+		}
+		return txn.Delete(kvs, []byte("a"), 0)
+	})
+	if err != nil {
+		t.Fatalf("failed to run transaction: %s", err)
+	}
+
+	txn := kvdb.NewTransaction()
+	defer txn.Free()
+
+	txn.Begin()
+	txn.Put(kvs, []byte("c"), []byte("3"), 0)
+	txn.Abort()
+
+	if err = kvs.PrefixDelete([]byte("b"), 0); err != nil {
+		t.Fatalf("failed to prefix delete: %s", err)
+	}
+
+	records := readCdc(t, 0)
+	expected := []CdcRecord{
+		{Seq: 1, Kvs: cdcTestKvsName, Op: CHANGE_PUT, Key: []byte("a"), Value: []byte("1")},
+		{Seq: 2, Kvs: cdcTestKvsName, Op: CHANGE_PUT, Key: []byte("b"), Value: []byte("2")},
+		{Seq: 3, Kvs: cdcTestKvsName, Op: CHANGE_DELETE, Key: []byte("a")},
+		{Seq: 4, Kvs: cdcTestKvsName, Op: CHANGE_PREFIX_DELETE, Key: []byte("b")},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("read %d records, expected %d: %+v", len(records), len(expected), records)
+	}
+	for i := range expected {
+		r, e := records[i], expected[i]
+		if r.Seq != e.Seq || r.Kvs != e.Kvs || r.Op != e.Op || string(r.Key) != string(e.Key) || string(r.Value) != string(e.Value) {
+			t.Fatalf("record %d is %+v, expected %+v", i, r, e)
+		}
+	}
+
+	// A reader resumes after its checkpoint
+	reader := kvdb.NewCdcReader(2)
+	if records, err = reader.Read(1); err != nil || len(records) != 1 || records[0].Seq != 3 {
+		t.Fatalf("unexpected records after checkpoint 2: %+v: %v", records, err)
+	}
+	if reader.Checkpoint() != 3 {
+		t.Fatalf("checkpoint is %d after reading record 3", reader.Checkpoint())
+	}
+
+	deleted, err := kvdb.CdcTruncate(2)
+	if err != nil || deleted != 2 {
+		t.Fatalf("truncated %d records: %v", deleted, err)
+	}
+	if records = readCdc(t, 0); len(records) != 2 || records[0].Seq != 3 {
+		t.Fatalf("unexpected records after truncation: %+v", records)
+	}
+
+	// Sequence numbers survive disabling and truncating the whole log
+	if _, err = kvdb.CdcTruncate(math.MaxUint64); err != nil {
+		t.Fatalf("failed to truncate: %s", err)
+	}
+	if err = kvdb.DisableCdc(); err != nil {
+		t.Fatalf("failed to disable cdc: %s", err)
+	}
+	err = kvdb.Transact(func(txn *Transaction) error {
+		return txn.Put(kvs, []byte("d"), []byte("4"), 0)
+	})
+	if err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if err = kvdb.EnableCdc(); err != nil {
+		t.Fatalf("failed to enable cdc: %s", err)
+	}
+	if err = kvs.Put([]byte("e"), []byte("5"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	records = readCdc(t, 4)
+	if len(records) != 1 || records[0].Seq != 5 || string(records[0].Key) != "e" {
+		t.Fatalf("unexpected records after re-enabling: %+v", records)
+	}
+}
+
+// Values are logged apart from records, so puts of the largest values and keys
+// can be logged
+func TestCdcLargeValue(t *testing.T) {
+	kvs := makeAndOpenKvs(cdcTestKvsName, txnParams)
+	defer kvdb.KvsDrop(cdcTestKvsName)
+	defer kvs.Close()
+
+	if err := kvdb.EnableCdc(); err != nil {
+		t.Fatalf("failed to enable cdc: %s", err)
+	}
+	defer kvdb.KvsDrop(CDC_KVS_NAME)
+	defer kvdb.DisableCdc()
+
+	key := bytes.Repeat([]byte("k"), int(limits.KVS_KEY_LEN_MAX))
+	value := bytes.Repeat([]byte("v"), int(limits.KVS_VALUE_LEN_MAX))
+
+	if err := kvs.Put(key, value, 0); err != nil {
+		t.Fatalf("failed to put the largest value with cdc enabled: %s", err)
+	}
+
+	reader := kvdb.NewCdcReader(0)
+	records, err := reader.Read(10)
+	if err != nil {
+		t.Fatalf("failed to read the log: %s", err)
+	}
+	if len(records) != 1 || !bytes.Equal(records[0].Key, key) || !bytes.Equal(records[0].Value, value) {
+		t.Fatalf("unexpected records %d", len(records))
+	}
+
+	deleted, err := kvdb.CdcTruncate(reader.Checkpoint())
+	if err != nil || deleted != 1 {
+		t.Fatalf("truncated %d records: %v", deleted, err)
+	}
+
+	c, err := kvdb.cdc.kvs.CreateCursor([]byte{cdcValuePfx}, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c.Destroy()
+
+	if _, _, err = c.Read(0); err != nil || !c.Eof() {
+		t.Fatalf("truncation left values behind: %v", err)
+	}
+}
+
+// A commit whose log records cannot be written keeps its changes, so retrying
+// it logs and publishes them
+func TestCdcCommitFailure(t *testing.T) {
+	kvs := makeAndOpenKvs(cdcTestKvsName, txnParams)
+	defer kvdb.KvsDrop(cdcTestKvsName)
+	defer kvs.Close()
+
+	if err := kvdb.EnableCdc(); err != nil {
+		t.Fatalf("failed to enable cdc: %s", err)
+	}
+	defer kvdb.KvsDrop(CDC_KVS_NAME)
+	defer kvdb.DisableCdc()
+
+	s := kvdb.Subscribe(ChangeFilter{}, 4)
+	defer s.Close()
+
+	txn := kvdb.NewTransaction()
+	defer txn.Free()
+
+	if err := txn.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	if err := txn.Put(kvs, []byte("a"), []byte("1"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	// A Kvs opened without transactions rejects the log puts
+	plain := makeAndOpenKvs(cdcTestPlainKvsName, params{})
+	defer kvdb.KvsDrop(cdcTestPlainKvsName)
+	defer plain.Close()
+
+	log := kvdb.cdc.kvs
+	kvdb.cdc.kvs = plain
+	err := txn.Commit()
+	kvdb.cdc.kvs = log
+	if err == nil {
+		t.Fatalf("committed without writing the log")
+	}
+	if changes := drain(s); len(changes) != 0 {
+		t.Fatalf("published %d changes of a failed commit", len(changes))
+	}
+
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("failed to retry the commit: %s", err)
+	}
+
+	if records := readCdc(t, 0); len(records) != 1 || string(records[0].Key) != "a" {
+		t.Fatalf("unexpected records after retrying the commit: %+v", records)
+	}
+	if changes := drain(s); len(changes) != 1 || string(changes[0].Key) != "a" {
+		t.Fatalf("unexpected changes after retrying the commit: %+v", changes)
+	}
+}
@@ -0,0 +1,274 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ChangeOp is the kind of mutation a Change describes
+type ChangeOp int
+
+const (
+	// CHANGE_PUT is a Kvs.Put()
+	CHANGE_PUT ChangeOp = iota
+	// CHANGE_DELETE is a Kvs.Delete()
+	CHANGE_DELETE
+	// CHANGE_PREFIX_DELETE is a Kvs.PrefixDelete()
+	CHANGE_PREFIX_DELETE
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case CHANGE_PUT:
+		return "put"
+	case CHANGE_DELETE:
+		return "delete"
+	case CHANGE_PREFIX_DELETE:
+		return "prefix_delete"
+	}
+
+	return "unknown"
+}
+
+// ErrChangeFeedOverflow is returned by Subscription.Err() when the
+// subscription was closed because its consumer fell behind
+var ErrChangeFeedOverflow = errors.New("hse: change feed subscription overflowed")
+
+// Change is a committed mutation of a Kvs
+//
+// Key and Value are shared between subscriptions and must not be modified.
+type Change struct {
+	// Seq orders the changes of a Kvdb in the order they were applied.
+	// Changes committed by one transaction have consecutive sequence numbers.
+	Seq uint64
+	// Kvs is the name of the mutated Kvs
+	Kvs string
+	// Op is the kind of mutation
+	Op ChangeOp
+	// Key is the mutated key, or the filter of a prefix delete
+	Key []byte
+	// Value is the new value of a put, if the subscription asked for values
+	Value []byte
+}
+
+// ChangeFilter selects the changes delivered to a Subscription
+type ChangeFilter struct {
+	// Kvs selects changes to the named Kvs, or to all Kvs if empty
+	Kvs string
+	// Prefix selects changes to keys with the prefix. Prefix deletes are
+	// selected when their filter and Prefix overlap.
+	Prefix []byte
+	// Values is whether to deliver the values of puts
+	Values bool
+}
+
+func (f *ChangeFilter) match(c *Change) bool {
+	if f.Kvs != "" && f.Kvs != c.Kvs {
+		return false
+	}
+
+	if c.Op == CHANGE_PREFIX_DELETE && len(c.Key) < len(f.Prefix) {
+		return bytes.HasPrefix(f.Prefix, c.Key)
+	}
+
+	return bytes.HasPrefix(c.Key, f.Prefix)
+}
+
+// Subscription delivers the changes committed through a Kvdb's bindings
+type Subscription struct {
+	feed   *changeFeed
+	filter ChangeFilter
+	ch     chan Change
+	err    error
+}
+
+// changeFeed publishes changes to the subscriptions of a Kvdb
+type changeFeed struct {
+	// active is the number of subscriptions, read without holding mu
+	active int32
+
+	// order is held from the time a mutation is applied until it is
+	// published, so that sequence numbers follow the order mutations are
+	// applied in
+	order sync.Mutex
+
+	mu   sync.Mutex
+	seq  uint64
+	subs map[*Subscription]struct{}
+}
+
+// pendingChanges holds the changes of a transaction until it commits
+type pendingChanges struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+// Subscribe creates a Subscription to the changes committed through the Kvdb
+//
+// Changes are delivered in commit order, those of a transaction only after it
+// commits. To keep that order across goroutines, mutations made outside of a
+// transaction while the Kvdb has subscriptions, and commits of transactions
+// which made mutations, are serialized with their publication. Only mutations
+// made through this package with the Kvdb's handles are seen. If the consumer
+// falls more than buffer changes behind, the subscription is closed and Err()
+// returns ErrChangeFeedOverflow rather than stalling writers. This function is
+// thread safe.
+func (k *Kvdb) Subscribe(filter ChangeFilter, buffer int) *Subscription {
+	s := &Subscription{
+		feed:   &k.feed,
+		filter: filter,
+		ch:     make(chan Change, buffer),
+	}
+	s.filter.Prefix = append([]byte(nil), filter.Prefix...)
+
+	k.feed.mu.Lock()
+	defer k.feed.mu.Unlock()
+
+	if k.feed.subs == nil {
+		k.feed.subs = make(map[*Subscription]struct{})
+	}
+	k.feed.subs[s] = struct{}{}
+	atomic.AddInt32(&k.feed.active, 1)
+
+	return s
+}
+
+// Changes returns the channel the changes are delivered on
+//
+// The channel is closed when the subscription is closed.
+func (s *Subscription) Changes() <-chan Change {
+	return s.ch
+}
+
+// Err returns why the subscription was closed by the Kvdb, if it was
+func (s *Subscription) Err() error {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	return s.err
+}
+
+// Close ends the subscription and closes its channel
+//
+// This function is thread safe.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	s.feed.unsubscribe(s, nil)
+}
+
+// unsubscribe must be called with mu held
+func (f *changeFeed) unsubscribe(s *Subscription, err error) {
+	if _, ok := f.subs[s]; !ok {
+		return
+	}
+
+	delete(f.subs, s)
+	atomic.AddInt32(&f.active, -1)
+
+	s.err = err
+	close(s.ch)
+}
+
+// closeAll closes every subscription when the Kvdb closes
+func (f *changeFeed) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for s := range f.subs {
+		f.unsubscribe(s, nil)
+	}
+}
+
+// lockChanges acquires the feed's order lock before a mutation of kvs outside
+// of a transaction which will be published, returning whether it did
+func (k *Kvdb) lockChanges(txn *Transaction, kvs string) bool {
+	if txn != nil || systemKvs(kvs) || atomic.LoadInt32(&k.feed.active) == 0 {
+		return false
+	}
+
+	k.feed.order.Lock()
+
+	return true
+}
+
+// recordChange notes a successful mutation for the change feed and the change
+// data capture log, publishing it immediately or when txn commits
+func (k *Kvdb) recordChange(txn *Transaction, kvs string, op ChangeOp, key []byte, value []byte) {
+	if systemKvs(kvs) || (atomic.LoadInt32(&k.feed.active) == 0 && k.cdc == nil) {
+		return
+	}
+
+	c := Change{
+		Kvs: kvs,
+		Op:  op,
+		Key: append([]byte(nil), key...),
+	}
+	if op == CHANGE_PUT {
+		c.Value = append([]byte{}, value...)
+	}
+
+	if txn == nil {
+		k.feed.publish([]Change{c})
+		return
+	}
+
+	txn.pending.mu.Lock()
+	txn.pending.changes = append(txn.pending.changes, c)
+	txn.pending.mu.Unlock()
+}
+
+// publish sequences changes and delivers them to the matching subscriptions
+func (f *changeFeed) publish(changes []Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range changes {
+		f.seq++
+		changes[i].Seq = f.seq
+
+		for s := range f.subs {
+			if !s.filter.match(&changes[i]) {
+				continue
+			}
+
+			c := changes[i]
+			if !s.filter.Values {
+				c.Value = nil
+			}
+
+			select {
+			case s.ch <- c:
+			default:
+				f.unsubscribe(s, ErrChangeFeedOverflow)
+			}
+		}
+	}
+}
+
+// peek returns a copy of the pending changes, leaving them pending
+func (p *pendingChanges) peek() []Change {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Change(nil), p.changes...)
+}
+
+// take removes and returns the pending changes
+func (p *pendingChanges) take() []Change {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changes := p.changes
+	p.changes = nil
+
+	return changes
+}
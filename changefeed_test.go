@@ -0,0 +1,222 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const (
+	changeFeedTestKvsName = "changefeed-test"
+)
+
+// drain returns the changes delivered so far without blocking
+func drain(s *Subscription) []Change {
+	var changes []Change
+
+	for {
+		select {
+		case c, ok := <-s.Changes():
+			if !ok {
+				return changes
+			}
+			changes = append(changes, c)
+		default:
+			return changes
+		}
+	}
+}
+
+func TestChangeFeed(t *testing.T) {
+	kvs := makeAndOpenKvs(changeFeedTestKvsName, txnParams)
+	defer kvdb.KvsDrop(changeFeedTestKvsName)
+	defer kvs.Close()
+
+	all := kvdb.Subscribe(ChangeFilter{Kvs: changeFeedTestKvsName, Values: true}, 16)
+	defer all.Close()
+
+	users := kvdb.Subscribe(ChangeFilter{Kvs: changeFeedTestKvsName, Prefix: []byte("user/")}, 16)
+	defer users.Close()
+
+	for _, kv := range [][2]string{{"user/1", "alice"}, {"order/1", "book"}} {
+		err := kvdb.Transact(func(txn *Transaction) error {
+			return txn.Put(kvs, []byte(kv[0]), []byte(kv[1]), 0)
+		})
+		if err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+
+	txn := kvdb.NewTransaction()
+	defer txn.Free()
+
+	if err := txn.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	if err := txn.Delete(kvs, []byte("user/1"), 0); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	if changes := drain(all); len(changes) != 2 {
+		t.Fatalf("got %d changes before the commit, expected 2", len(changes))
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+
+	// Aborted changes are never delivered
+	if err := txn.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	txn.Put(kvs, []byte("user/2"), []byte("bob"), 0)
+	if err := txn.Abort(); err != nil {
+		t.Fatalf("failed to abort: %s", err)
+	}
+
+	changes := drain(all)
+	if len(changes) != 1 || changes[0].Op != CHANGE_DELETE || string(changes[0].Key) != "user/1" {
+		t.Fatalf("unexpected changes after the commit: %+v", changes)
+	}
+
+	changes = drain(users)
+	if len(changes) != 2 {
+		t.Fatalf("got %d user changes, expected 2", len(changes))
+	}
+	if changes[0].Op != CHANGE_PUT || changes[0].Value != nil {
+		t.Fatalf("unexpected first user change %+v", changes[0])
+	}
+	if changes[1].Seq <= changes[0].Seq {
+		t.Fatalf("changes are out of order: %+v", changes)
+	}
+}
+
+func TestChangeFeedValues(t *testing.T) {
+	kvs := makeAndOpenKvs(changeFeedTestKvsName, params{})
+	defer kvdb.KvsDrop(changeFeedTestKvsName)
+	defer kvs.Close()
+
+	s := kvdb.Subscribe(ChangeFilter{Values: true}, 4)
+	defer s.Close()
+
+	value := []byte("value")
+	if err := kvs.Put([]byte("key"), value, 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	value[0] = 'V'
+
+	if err := kvs.PrefixDelete([]byte("k"), 0); err != nil {
+		t.Fatalf("failed to prefix delete: %s", err)
+	}
+
+	changes := drain(s)
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, expected 2", len(changes))
+	}
+	if string(changes[0].Value) != "value" {
+		t.Fatalf("change holds value %q, expected the value at the time of the put", changes[0].Value)
+	}
+	if changes[1].Op != CHANGE_PREFIX_DELETE || string(changes[1].Key) != "k" {
+		t.Fatalf("unexpected prefix delete change %+v", changes[1])
+	}
+}
+
+func TestChangeFeedOverflow(t *testing.T) {
+	kvs := makeAndOpenKvs(changeFeedTestKvsName, params{})
+	defer kvdb.KvsDrop(changeFeedTestKvsName)
+	defer kvs.Close()
+
+	s := kvdb.Subscribe(ChangeFilter{}, 1)
+	defer s.Close()
+
+	for _, key := range []string{"a", "b"} {
+		if err := kvs.Put([]byte(key), nil, 0); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+
+	if changes := drain(s); len(changes) != 1 {
+		t.Fatalf("got %d changes, expected 1", len(changes))
+	}
+	if _, ok := <-s.Changes(); ok {
+		t.Fatalf("subscription is still open")
+	}
+	if s.Err() != ErrChangeFeedOverflow {
+		t.Fatalf("expected an overflow, got %v", s.Err())
+	}
+}
+
+// The last change published for a key written concurrently is the one which
+// was applied last, whether the writes are transactional or not
+func TestChangeFeedConcurrent(t *testing.T) {
+	t.Run("Kvs", func(t *testing.T) { testChangeFeedConcurrent(t, false) })
+	t.Run("Transaction", func(t *testing.T) { testChangeFeedConcurrent(t, true) })
+}
+
+func testChangeFeedConcurrent(t *testing.T, transactional bool) {
+	p := params{}
+	if transactional {
+		p = txnParams
+	}
+
+	kvs := makeAndOpenKvs(changeFeedTestKvsName, p)
+	defer kvdb.KvsDrop(changeFeedTestKvsName)
+	defer kvs.Close()
+
+	const workers = 4
+	const writes = 100
+
+	s := kvdb.Subscribe(ChangeFilter{Values: true}, 2*workers*writes)
+	defer s.Close()
+
+	key := []byte("key")
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < writes; i++ {
+				value := []byte(strconv.Itoa(w*writes + i))
+
+				var err error
+				if transactional {
+					err = kvdb.Transact(func(txn *Transaction) error {
+						return txn.Put(kvs, key, value, 0)
+					})
+				} else {
+					err = kvs.Put(key, value, 0)
+				}
+				if err != nil {
+					t.Errorf("failed to put: %s", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	changes := drain(s)
+	if len(changes) != workers*writes {
+		t.Fatalf("got %d changes, expected %d", len(changes), workers*writes)
+	}
+	for i := 1; i < len(changes); i++ {
+		if changes[i].Seq <= changes[i-1].Seq {
+			t.Fatalf("change %d has sequence number %d after %d", i, changes[i].Seq, changes[i-1].Seq)
+		}
+	}
+
+	value, _, err := kvs.Get(key, 0)
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+	if last := changes[len(changes)-1]; string(last.Value) != string(value) {
+		t.Fatalf("last change put %q but the key holds %q", last.Value, value)
+	}
+}
@@ -0,0 +1,324 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Command hse-go-admin manages the lifecycle of KVDBs and KVSs
+//
+// Every command writes its result to stdout as JSON so that it can be consumed
+// by scripts.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	hse "github.com/hse-project/hse-go"
+)
+
+const usage = `Usage: hse-go-admin [flags] <command> [args]
+
+Commands:
+  create [PARAM...]            create the KVDB
+  drop                         drop the KVDB
+  info                         show the KVDB's KVSs, storage and params
+  kvs-create NAME [PARAM...]   create a KVS
+  kvs-drop NAME                drop a KVS
+  kvs-list                     list the KVSs
+  kvs-param NAME PARAM...      show KVS params
+  param PARAM...               show KVDB params
+  compact [-full] [-cancel] [-watch INTERVAL]
+                               request a compaction, optionally waiting for it
+  compact-status               show the compaction status
+  sync                         flush the KVDB to stable media
+
+Flags:
+`
+
+var home = flag.String("C", ".", "KVDB home `directory`")
+
+var errUsage = errors.New("invalid arguments, see -h")
+
+var commands = map[string]bool{
+	"create":         true,
+	"drop":           true,
+	"info":           true,
+	"kvs-create":     true,
+	"kvs-drop":       true,
+	"kvs-list":       true,
+	"kvs-param":      true,
+	"param":          true,
+	"compact":        true,
+	"compact-status": true,
+	"sync":           true,
+}
+
+// infoParams are the KVDB params shown by the info command
+var infoParams = []string{"durability.enabled", "durability.interval_ms", "read_only"}
+
+type compactStatus struct {
+	SampLwm  uint `json:"samp_lwm"`
+	SampHwm  uint `json:"samp_hwm"`
+	SampCurr uint `json:"samp_curr"`
+	Active   bool `json:"active"`
+	Canceled bool `json:"canceled"`
+}
+
+type mclassInfo struct {
+	Mclass         string `json:"mclass"`
+	AllocatedBytes uint64 `json:"allocated_bytes"`
+	UsedBytes      uint64 `json:"used_bytes"`
+	Path           string `json:"path"`
+}
+
+type info struct {
+	Home    string                     `json:"home"`
+	Kvs     []string                   `json:"kvs"`
+	Storage []mclassInfo               `json:"storage"`
+	Params  map[string]json.RawMessage `json:"params"`
+}
+
+type result struct {
+	Ok bool `json:"ok"`
+}
+
+func newCompactStatus(s hse.KvdbCompactStatus) compactStatus {
+	return compactStatus{
+		SampLwm:  s.SampLwm,
+		SampHwm:  s.SampHwm,
+		SampCurr: s.SampCurr,
+		Active:   s.Active,
+		Canceled: s.Canceled,
+	}
+}
+
+type paramGetter func(param string) (string, error)
+
+// params collects param values into a JSON object, skipping params which the
+// KVDB or KVS does not recognize when skipUnknown is set
+func params(get paramGetter, names []string, skipUnknown bool) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+
+	for _, name := range names {
+		value, err := get(name)
+		if err != nil {
+			if skipUnknown {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get param %s: %s", name, err)
+		}
+
+		values[name] = json.RawMessage(value)
+	}
+
+	return values, nil
+}
+
+func compact(kvdb *hse.Kvdb, args []string, enc *json.Encoder) error {
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	full := fs.Bool("full", false, "compact fully rather than to the space amp low watermark")
+	cancel := fs.Bool("cancel", false, "cancel an ongoing compaction")
+	watch := fs.Duration("watch", 0, "print the status every `INTERVAL` until the compaction finishes")
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	flags := hse.KVDB_COMPACT_SAMP_LWM
+	switch {
+	case *cancel:
+		flags = hse.KVDB_COMPACT_CANCEL
+	case *full:
+		flags = hse.KVDB_COMPACT_FULL
+	}
+
+	if err := kvdb.Compact(flags); err != nil {
+		return err
+	}
+
+	for {
+		status, err := kvdb.CompactStatus()
+		if err != nil {
+			return err
+		}
+
+		if err = enc.Encode(newCompactStatus(status)); err != nil {
+			return err
+		}
+
+		if *watch <= 0 || !status.Active {
+			return nil
+		}
+
+		time.Sleep(*watch)
+	}
+}
+
+func kvdbInfo(kvdb *hse.Kvdb) (info, error) {
+	names, err := kvdb.KvsNames()
+	if err != nil {
+		return info{}, err
+	}
+
+	i := info{
+		Home:    kvdb.Home(),
+		Kvs:     names,
+		Storage: []mclassInfo{},
+	}
+
+	for m := hse.Mclass(0); m < hse.MCLASS_COUNT; m++ {
+		if !kvdb.MclassIsConfigured(m) {
+			continue
+		}
+
+		mi, err := kvdb.MclassInfo(m)
+		if err != nil {
+			return info{}, err
+		}
+
+		i.Storage = append(i.Storage, mclassInfo{
+			Mclass:         m.String(),
+			AllocatedBytes: mi.AllocatedBytes,
+			UsedBytes:      mi.UsedBytes,
+			Path:           mi.Path,
+		})
+	}
+
+	if i.Params, err = params(kvdb.ParamGet, infoParams, true); err != nil {
+		return info{}, err
+	}
+
+	return i, nil
+}
+
+func run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	cmd, args := args[0], args[1:]
+	enc := json.NewEncoder(w)
+
+	if !commands[cmd] {
+		return errUsage
+	}
+
+	if err := hse.Init(); err != nil {
+		return err
+	}
+	defer hse.Fini()
+
+	switch cmd {
+	case "create":
+		if err := hse.KvdbCreate(*home, args...); err != nil {
+			return err
+		}
+		return enc.Encode(result{Ok: true})
+	case "drop":
+		if len(args) != 0 {
+			return errUsage
+		}
+		if err := hse.KvdbDrop(*home); err != nil {
+			return err
+		}
+		return enc.Encode(result{Ok: true})
+	}
+
+	kvdb, err := hse.KvdbOpen(*home, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open kvdb %s: %s", *home, err)
+	}
+	defer kvdb.Close()
+
+	switch cmd {
+	case "info":
+		i, err := kvdbInfo(kvdb)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(i)
+	case "kvs-create":
+		if len(args) < 1 {
+			return errUsage
+		}
+		if err := kvdb.KvsCreate(args[0], args[1:]...); err != nil {
+			return err
+		}
+		return enc.Encode(result{Ok: true})
+	case "kvs-drop":
+		if len(args) != 1 {
+			return errUsage
+		}
+		if err := kvdb.KvsDrop(args[0]); err != nil {
+			return err
+		}
+		return enc.Encode(result{Ok: true})
+	case "kvs-list":
+		names, err := kvdb.KvsNames()
+		if err != nil {
+			return err
+		}
+		return enc.Encode(names)
+	case "kvs-param":
+		if len(args) < 2 {
+			return errUsage
+		}
+
+		kvs, err := kvdb.KvsOpen(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to open kvs %s: %s", args[0], err)
+		}
+		defer kvs.Close()
+
+		values, err := params(kvs.ParamGet, args[1:], false)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(values)
+	case "param":
+		if len(args) < 1 {
+			return errUsage
+		}
+
+		values, err := params(kvdb.ParamGet, args, false)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(values)
+	case "compact":
+		return compact(kvdb, args, enc)
+	case "compact-status":
+		status, err := kvdb.CompactStatus()
+		if err != nil {
+			return err
+		}
+		return enc.Encode(newCompactStatus(status))
+	case "sync":
+		if err := kvdb.Sync(); err != nil {
+			return err
+		}
+		return enc.Encode(result{Ok: true})
+	}
+
+	return errUsage
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args(), os.Stdout); err != nil {
+		json.NewEncoder(os.Stderr).Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		os.Exit(1)
+	}
+}
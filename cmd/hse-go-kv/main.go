@@ -0,0 +1,332 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Command hse-go-kv inspects and edits the contents of the KVSs in a KVDB
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	hse "github.com/hse-project/hse-go"
+	"github.com/hse-project/hse-go/tuple"
+)
+
+const usage = `Usage: hse-go-kv [flags] <command> [args]
+
+Commands:
+  list                  list the KVSs in the KVDB
+  get KVS KEY           print the value of KEY
+  put KVS KEY VALUE     set the value of KEY
+  delete KVS KEY        delete KEY
+  scan KVS [PREFIX]     print the key-value pairs, optionally only under PREFIX
+  count KVS [PREFIX]    count the keys, optionally only under PREFIX
+
+Flags:
+`
+
+var (
+	home        = flag.String("C", ".", "KVDB home `directory`")
+	keyFormat   = flag.String("key-format", "text", "format of keys on the command line and in output: hex, text or tuple")
+	valueFormat = flag.String("value-format", "text", "format of VALUE: hex or text")
+	output      = flag.String("output", "text", "output format: hex, text or json")
+	start       = flag.String("start", "", "only scan or count keys from `KEY` on")
+	end         = flag.String("end", "", "only scan or count keys up to `KEY`")
+	reverse     = flag.Bool("reverse", false, "scan in reverse order")
+	limit       = flag.Uint64("limit", 0, "stop after `N` keys, 0 for no limit")
+	keysOnly    = flag.Bool("keys-only", false, "only print keys when scanning")
+)
+
+var errUsage = errors.New("invalid arguments, see -h")
+
+// unescape interprets the Go escape sequences in s
+//
+// Unlike strconv.Unquote(), quotes need not be escaped.
+func unescape(s string) ([]byte, error) {
+	buf := make([]byte, 0, len(s))
+	for rest := s; len(rest) > 0; {
+		if rest[0] != '\\' {
+			buf = append(buf, rest[0])
+			rest = rest[1:]
+			continue
+		}
+
+		r, multibyte, tail, err := strconv.UnquoteChar(rest, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid escape sequence in %q", s)
+		}
+		rest = tail
+
+		if !multibyte {
+			buf = append(buf, byte(r))
+		} else {
+			buf = append(buf, string(r)...)
+		}
+	}
+
+	return buf, nil
+}
+
+// decode converts a command line argument in the given format to bytes
+//
+// Text arguments may contain Go escape sequences such as \x00.
+func decode(arg string, format string) ([]byte, error) {
+	switch format {
+	case "hex":
+		return hex.DecodeString(arg)
+	case "text":
+		return unescape(arg)
+	case "tuple":
+		t, err := tuple.Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		return t.Pack()
+	}
+
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func escape(b []byte) string {
+	s := strconv.Quote(string(b))
+
+	return s[1 : len(s)-1]
+}
+
+// encode formats bytes for output
+func encode(b []byte, format string) string {
+	switch format {
+	case "hex":
+		return hex.EncodeToString(b)
+	case "tuple":
+		if t, err := tuple.Unpack(b); err == nil {
+			return t.String()
+		}
+		return hex.EncodeToString(b)
+	}
+
+	return escape(b)
+}
+
+type printer struct {
+	w *bufio.Writer
+}
+
+func (p printer) print(key, value []byte, withValue bool) error {
+	if *output == "json" {
+		obj := make(map[string]string)
+
+		field := func(name string, b []byte) {
+			switch {
+			case name == "key" && *keyFormat == "tuple":
+				obj[name] = encode(b, "tuple")
+			case utf8.Valid(b):
+				obj[name] = string(b)
+			default:
+				obj[name+"_hex"] = hex.EncodeToString(b)
+			}
+		}
+
+		if key != nil {
+			field("key", key)
+		}
+		if withValue {
+			field("value", value)
+		}
+
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(p.w, "%s\n", data)
+		return err
+	}
+
+	keyOut := *output
+	if *keyFormat == "tuple" && keyOut == "text" {
+		keyOut = "tuple"
+	}
+
+	var err error
+	switch {
+	case key == nil:
+		_, err = fmt.Fprintln(p.w, encode(value, *output))
+	case withValue:
+		_, err = fmt.Fprintf(p.w, "%s\t%s\n", encode(key, keyOut), encode(value, *output))
+	default:
+		_, err = fmt.Fprintln(p.w, encode(key, keyOut))
+	}
+
+	return err
+}
+
+// walk calls fn for every key-value pair in the scan range of the KVS
+func walk(kvs *hse.Kvs, prefix []byte, fn func(key, value []byte) error) error {
+	var flags hse.CursorCreateFlag
+	if *reverse {
+		flags |= hse.CURSOR_CREATE_REV
+	}
+
+	c, err := kvs.CreateCursor(prefix, flags)
+	if err != nil {
+		return err
+	}
+	defer c.Destroy()
+
+	if *start != "" || *end != "" {
+		var min, max []byte
+
+		if *start != "" {
+			if min, err = decode(*start, *keyFormat); err != nil {
+				return err
+			}
+		}
+		if *end != "" {
+			if max, err = decode(*end, *keyFormat); err != nil {
+				return err
+			}
+		}
+
+		if _, err = c.SeekRange(min, max, 0); err != nil {
+			return err
+		}
+	}
+
+	var n uint64
+	return c.ForEach(func(key []byte, value []byte) error {
+		if *limit != 0 && n == *limit {
+			return hse.ErrStopIteration
+		}
+		n++
+
+		return fn(key, value)
+	})
+}
+
+func run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	cmd, args := args[0], args[1:]
+
+	expected := map[string][2]int{
+		"list":   {0, 0},
+		"get":    {2, 2},
+		"put":    {3, 3},
+		"delete": {2, 2},
+		"scan":   {1, 2},
+		"count":  {1, 2},
+	}
+
+	n, ok := expected[cmd]
+	if !ok || len(args) < n[0] || len(args) > n[1] {
+		return errUsage
+	}
+
+	if err := hse.Init(); err != nil {
+		return err
+	}
+	defer hse.Fini()
+
+	kvdb, err := hse.KvdbOpen(*home, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open kvdb %s: %s", *home, err)
+	}
+	defer kvdb.Close()
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	p := printer{w: bw}
+
+	if cmd == "list" {
+		names, err := kvdb.KvsNames()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			fmt.Fprintln(bw, name)
+		}
+
+		return nil
+	}
+
+	kvs, err := kvdb.KvsOpen(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open kvs %s: %s", args[0], err)
+	}
+	defer kvs.Close()
+
+	var key []byte
+	if len(args) > 1 {
+		if key, err = decode(args[1], *keyFormat); err != nil {
+			return err
+		}
+	}
+
+	switch cmd {
+	case "get":
+		value, _, err := kvs.Get(key, 0)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return fmt.Errorf("key %s not found", args[1])
+		}
+
+		return p.print(nil, value, true)
+	case "put":
+		value, err := decode(args[2], *valueFormat)
+		if err != nil {
+			return err
+		}
+
+		return kvs.Put(key, value, 0)
+	case "delete":
+		return kvs.Delete(key, 0)
+	case "scan":
+		return walk(kvs, key, func(k, v []byte) error {
+			return p.print(k, v, !*keysOnly)
+		})
+	case "count":
+		var count uint64
+
+		err := walk(kvs, key, func(k, v []byte) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(bw, count)
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args(), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "hse-go-kv: %s\n", err)
+		os.Exit(1)
+	}
+}
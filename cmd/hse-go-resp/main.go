@@ -0,0 +1,96 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Command hse-go-resp serves a KVDB over the Redis protocol
+//
+// See package github.com/hse-project/hse-go/resp for the supported commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	hse "github.com/hse-project/hse-go"
+	"github.com/hse-project/hse-go/resp"
+)
+
+const usage = `Usage: hse-go-resp [flags] [PARAM...]
+
+Serves the KVDB over the Redis protocol until interrupted. Redis database
+number N is the Nth KVS named by -kvs. PARAMs are KVDB runtime params.
+
+Flags:
+`
+
+var (
+	home   = flag.String("C", ".", "KVDB home `directory`")
+	listen = flag.String("listen", "localhost:6379", "`address` to listen on")
+	kvs    = flag.String("kvs", "", "comma-separated `names` of the KVSs to serve as databases 0, 1, ...")
+)
+
+var errUsage = errors.New("invalid arguments, see -h")
+
+func run(params []string) error {
+	if *kvs == "" {
+		return errUsage
+	}
+
+	if err := hse.Init(); err != nil {
+		return err
+	}
+	defer hse.Fini()
+
+	kvdb, err := hse.KvdbOpen(*home, params)
+	if err != nil {
+		return fmt.Errorf("failed to open kvdb %s: %s", *home, err)
+	}
+	defer kvdb.Close()
+
+	s, err := resp.New(kvdb, strings.Split(*kvs, ","))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve(l)
+	}()
+
+	select {
+	case err = <-errs:
+		return err
+	case <-signals:
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "hse-go-resp: %s\n", err)
+		os.Exit(1)
+	}
+}
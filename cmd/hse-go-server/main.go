@@ -0,0 +1,88 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Command hse-go-server serves a KVDB over HTTP/JSON
+//
+// See package github.com/hse-project/hse-go/server for the API.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	hse "github.com/hse-project/hse-go"
+	"github.com/hse-project/hse-go/server"
+)
+
+const usage = `Usage: hse-go-server [flags] [PARAM...]
+
+Serves the KVDB until interrupted. PARAMs are KVDB runtime params.
+
+Flags:
+`
+
+var (
+	home   = flag.String("C", ".", "KVDB home `directory`")
+	listen = flag.String("listen", "localhost:8080", "`address` to listen on")
+)
+
+// shutdownTimeout is how long in-flight requests are given to finish
+const shutdownTimeout = 10 * time.Second
+
+func run(params []string) error {
+	if err := hse.Init(); err != nil {
+		return err
+	}
+	defer hse.Fini()
+
+	kvdb, err := hse.KvdbOpen(*home, params)
+	if err != nil {
+		return fmt.Errorf("failed to open kvdb %s: %s", *home, err)
+	}
+	defer kvdb.Close()
+
+	s := server.New(kvdb)
+	defer s.Close()
+
+	srv := &http.Server{Addr: *listen, Handler: s}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errs:
+		return err
+	case <-signals:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return srv.Shutdown(ctx)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "hse-go-server: %s\n", err)
+		os.Exit(1)
+	}
+}
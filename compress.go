@@ -0,0 +1,384 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/lzw"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+)
+
+// CompressionAlgorithm identifies the Codec a value was compressed with
+type CompressionAlgorithm uint8
+
+const (
+	// COMPRESSION_NONE stores values uncompressed
+	COMPRESSION_NONE CompressionAlgorithm = 0
+	// COMPRESSION_DEFLATE compresses values with DEFLATE (RFC 1951). Levels
+	// range from flate.HuffmanOnly to flate.BestCompression.
+	COMPRESSION_DEFLATE CompressionAlgorithm = 1
+	// COMPRESSION_LZW compresses values with LZW. The level is ignored.
+	COMPRESSION_LZW CompressionAlgorithm = 2
+)
+
+const (
+	// COMPRESSION_HEADER_LEN is the number of bytes prepended to values which
+	// a CompressedKvs has compressed
+	COMPRESSION_HEADER_LEN uint = 3
+	// COMPRESSION_MIN_LEN is the length below which values are not compressed
+	COMPRESSION_MIN_LEN uint = 64
+)
+
+// ErrCompressionAlgorithm is returned when a value or CompressedKvs uses an
+// algorithm for which no Codec is registered
+var ErrCompressionAlgorithm = errors.New("hse: unknown compression algorithm")
+
+// Codec compresses and decompresses values for a CompressedKvs
+//
+// Both functions append their output to dst and must be thread safe.
+type Codec interface {
+	Compress(dst []byte, src []byte, level int) ([]byte, error)
+	Decompress(dst []byte, src []byte) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[CompressionAlgorithm]Codec{
+		COMPRESSION_DEFLATE: &deflateCodec{},
+		COMPRESSION_LZW:     lzwCodec{},
+	}
+)
+
+// RegisterCodec makes a Codec available under the given algorithm
+//
+// It panics if the algorithm is COMPRESSION_NONE or already registered.
+// Algorithms from 128 up are reserved for applications. This function is
+// thread safe.
+func RegisterCodec(algorithm CompressionAlgorithm, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if _, ok := codecs[algorithm]; ok || algorithm == COMPRESSION_NONE {
+		panic("hse: codec already registered")
+	}
+
+	codecs[algorithm] = codec
+}
+
+func lookupCodec(algorithm CompressionAlgorithm) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[algorithm]
+	if !ok {
+		return nil, ErrCompressionAlgorithm
+	}
+
+	return codec, nil
+}
+
+// deflateCodec keeps a pool of writers per level since they are expensive to
+// allocate
+type deflateCodec struct {
+	writers [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+}
+
+func (d *deflateCodec) Compress(dst []byte, src []byte, level int) ([]byte, error) {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, syscall.EINVAL
+	}
+
+	buf := bytes.NewBuffer(dst)
+	pool := &d.writers[level-flate.HuffmanOnly]
+
+	w, _ := pool.Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriter(buf, level)
+	} else {
+		w.Reset(buf)
+	}
+	defer pool.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (d *deflateCodec) Decompress(dst []byte, src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+
+	return readAllTo(dst, r)
+}
+
+type lzwCodec struct{}
+
+func (lzwCodec) Compress(dst []byte, src []byte, level int) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+
+	w := lzw.NewWriter(buf, lzw.LSB, 8)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (lzwCodec) Decompress(dst []byte, src []byte) ([]byte, error) {
+	r := lzw.NewReader(bytes.NewReader(src), lzw.LSB, 8)
+	defer r.Close()
+
+	return readAllTo(dst, r)
+}
+
+func readAllTo(dst []byte, r io.Reader) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// compressionMagic starts the header of values written by a CompressedKvs,
+// which is followed by the CompressionAlgorithm
+var compressionMagic = [2]byte{0xfe, 0x5a}
+
+// compressedFormats are the leading bytes of common compressed file formats
+var compressedFormats = [][]byte{
+	compressionMagic[:],
+	{0x1f, 0x8b},                     // gzip
+	{0x78, 0x9c},                     // zlib
+	{0x28, 0xb5, 0x2f, 0xfd},         // zstd
+	{0x04, 0x22, 0x4d, 0x18},         // lz4
+	{0xfd, '7', 'z', 'X', 'Z', 0x00}, // xz
+	{'B', 'Z', 'h'},                  // bzip2
+	{'s', 'N', 'a', 'P', 'p', 'Y'},   // snappy framed
+	{'P', 'K', 0x03, 0x04},           // zip
+	{0x89, 'P', 'N', 'G'},            // png
+	{0xff, 0xd8, 0xff},               // jpeg
+	{'G', 'I', 'F', '8'},             // gif
+	{'R', 'I', 'F', 'F'},             // webp
+}
+
+// alreadyCompressed reports whether value starts like a compressed format
+func alreadyCompressed(value []byte) bool {
+	for _, format := range compressedFormats {
+		if bytes.HasPrefix(value, format) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CompressedKvs compresses values in Go before they are stored in a Kvs
+//
+// Values are compressed with the selected algorithm and level, and stored
+// with a COMPRESSION_HEADER_LEN byte header naming the algorithm so that the
+// algorithm can be changed without rewriting existing values. Values without a
+// header are returned as they are, so a Kvs may hold a mix of values written
+// through a CompressedKvs and values written directly, provided the latter do
+// not start with the header's magic bytes.
+//
+// Values shorter than COMPRESSION_MIN_LEN, values which do not shrink, and
+// values which already look compressed, such as gzip or JPEG data, are stored
+// uncompressed. KVS_PUT_VCOMP_OFF is set for every value which was compressed
+// or looks compressed, since HSE's own compression cannot shrink them further.
+type CompressedKvs struct {
+	kvs       *Kvs
+	algorithm CompressionAlgorithm
+	codec     Codec
+	level     int
+}
+
+// CompressedCursor is a Cursor which decompresses values
+type CompressedCursor struct {
+	*Cursor
+}
+
+// NewCompressedKvs creates a CompressedKvs which compresses values stored in
+// kvs with algorithm at the given level
+//
+// ErrCompressionAlgorithm is returned if no Codec is registered for the
+// algorithm. COMPRESSION_NONE is allowed, in which case values written are
+// left uncompressed but compressed values are still read.
+func NewCompressedKvs(kvs *Kvs, algorithm CompressionAlgorithm, level int) (*CompressedKvs, error) {
+	c := &CompressedKvs{kvs: kvs, algorithm: algorithm, level: level}
+
+	if algorithm != COMPRESSION_NONE {
+		codec, err := lookupCodec(algorithm)
+		if err != nil {
+			return nil, err
+		}
+
+		// Reject a bad level now rather than on every Put()
+		if _, err = codec.Compress(nil, nil, level); err != nil {
+			return nil, err
+		}
+
+		c.codec = codec
+	}
+
+	return c, nil
+}
+
+// encode returns the value to store for value and the flags to store it with
+func (c *CompressedKvs) encode(value []byte, flags PutFlags) ([]byte, PutFlags, error) {
+	header := []byte{compressionMagic[0], compressionMagic[1], byte(COMPRESSION_NONE)}
+
+	if alreadyCompressed(value) {
+		flags |= KVS_PUT_VCOMP_OFF
+	} else if c.codec != nil && uint(len(value)) >= COMPRESSION_MIN_LEN {
+		header[2] = byte(c.algorithm)
+
+		stored, err := c.codec.Compress(header, value, c.level)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		flags |= KVS_PUT_VCOMP_OFF
+		if len(stored) < len(value) {
+			return stored, flags, nil
+		}
+	}
+
+	// Uncompressed values only need a header when it would be ambiguous
+	if !bytes.HasPrefix(value, compressionMagic[:]) {
+		return value, flags, nil
+	}
+
+	stored := make([]byte, 0, len(header)+len(value))
+	stored = append(stored, compressionMagic[0], compressionMagic[1], byte(COMPRESSION_NONE))
+
+	return append(stored, value...), flags, nil
+}
+
+// decodeCompressed returns the original value of a stored value
+func decodeCompressed(stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(stored, compressionMagic[:]) || uint(len(stored)) < COMPRESSION_HEADER_LEN {
+		return stored, nil
+	}
+
+	algorithm := CompressionAlgorithm(stored[2])
+	if algorithm == COMPRESSION_NONE {
+		return stored[COMPRESSION_HEADER_LEN:], nil
+	}
+
+	codec, err := lookupCodec(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Decompress(nil, stored[COMPRESSION_HEADER_LEN:])
+}
+
+// Put compresses value and places the KV pair into the Kvs
+//
+// This function is thread safe.
+func (c *CompressedKvs) Put(key, value []byte, flags PutFlags) error {
+	stored, flags, err := c.encode(value, flags)
+	if err != nil {
+		return err
+	}
+
+	return c.kvs.Put(key, stored, flags)
+}
+
+// Get retrieves and decompresses the value for a given key
+//
+// The returned length is that of the decompressed value. This function is
+// thread safe.
+func (c *CompressedKvs) Get(key []byte, flags GetFlags) ([]byte, uint, error) {
+	stored, _, err := c.kvs.Get(key, flags)
+	if err != nil || stored == nil {
+		return nil, 0, err
+	}
+
+	value, err := decodeCompressed(stored)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return value, uint(len(value)), nil
+}
+
+// Delete deletes the key and its associated value from the Kvs
+//
+// This function is thread safe.
+func (c *CompressedKvs) Delete(key []byte, flags DeleteFlags) error {
+	return c.kvs.Delete(key, flags)
+}
+
+// CreateCursor creates a cursor which decompresses the values it reads
+//
+// See Kvs.CreateCursor().
+func (c *CompressedKvs) CreateCursor(filt []byte, flags CursorCreateFlag) (*CompressedCursor, error) {
+	cursor, err := c.kvs.CreateCursor(filt, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompressedCursor{Cursor: cursor}, nil
+}
+
+// Read reads the next KV pair from the cursor and decompresses its value
+//
+// Decompressed values are allocated by Go and remain valid after the next
+// read, unlike uncompressed values.
+func (c *CompressedCursor) Read(flags CursorReadFlags) ([]byte, []byte, error) {
+	key, stored, err := c.Cursor.Read(flags)
+	if err != nil || c.Eof() {
+		return key, nil, err
+	}
+
+	value, err := decodeCompressed(stored)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, value, nil
+}
+
+// ReadBatch reads up to n KV pairs from the cursor and decompresses their
+// values
+//
+// Decompressed values are allocated by Go, while uncompressed values are only
+// valid until buf is reused. See Cursor.ReadBatch().
+func (c *CompressedCursor) ReadBatch(n int, buf []byte, flags CursorReadFlags) ([]KeyValue, error) {
+	pairs, err := c.Cursor.ReadBatch(n, buf, flags)
+
+	for i := range pairs {
+		value, decodeErr := decodeCompressed(pairs[i].Value)
+		if decodeErr != nil {
+			return pairs[:i], decodeErr
+		}
+
+		pairs[i].Value = value
+	}
+
+	return pairs, err
+}
+
+// ForEach calls fn with every remaining KV pair of the cursor in order, with
+// its value decompressed
+//
+// See Cursor.ForEach().
+func (c *CompressedCursor) ForEach(fn func(key []byte, value []byte) error) error {
+	return forEach(c, fn)
+}
@@ -0,0 +1,174 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"compress/flate"
+	"math/rand"
+	"testing"
+)
+
+const (
+	compressTestKvsName = "compress-test"
+)
+
+func TestCompressedKvs(t *testing.T) {
+	kvs := makeAndOpenKvs(compressTestKvsName, params{})
+	defer kvdb.KvsDrop(compressTestKvsName)
+	defer kvs.Close()
+
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	values := map[string][]byte{
+		"text":       bytes.Repeat([]byte("heterogeneous-memory storage engine "), 100),
+		"short":      []byte("short"),
+		"random":     random,
+		"gzip":       append([]byte{0x1f, 0x8b}, bytes.Repeat([]byte{0}, 1024)...),
+		"magic":      append(compressionMagic[:], bytes.Repeat([]byte{1}, 1024)...),
+		"magic-only": compressionMagic[:],
+		"empty":      {},
+	}
+
+	deflate, err := NewCompressedKvs(kvs, COMPRESSION_DEFLATE, flate.BestCompression)
+	if err != nil {
+		t.Fatalf("failed to create compressed kvs: %s", err)
+	}
+	lzw, err := NewCompressedKvs(kvs, COMPRESSION_LZW, 0)
+	if err != nil {
+		t.Fatalf("failed to create compressed kvs: %s", err)
+	}
+
+	for _, c := range []*CompressedKvs{deflate, lzw} {
+		for key, value := range values {
+			if err = c.Put([]byte(key), value, 0); err != nil {
+				t.Fatalf("failed to put %s: %s", key, err)
+			}
+		}
+
+		// Values written with either algorithm or directly can be read
+		if err = kvs.Put([]byte("raw"), []byte("written directly"), 0); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+
+		for _, r := range []*CompressedKvs{deflate, lzw} {
+			for key, value := range values {
+				got, n, err := r.Get([]byte(key), 0)
+				if err != nil {
+					t.Fatalf("failed to get %s: %s", key, err)
+				}
+				if !bytes.Equal(got, value) || n != uint(len(value)) {
+					t.Fatalf("value of %s differs", key)
+				}
+			}
+
+			if got, _, err := r.Get([]byte("raw"), 0); err != nil || string(got) != "written directly" {
+				t.Fatalf("unexpected value %q of an uncompressed key: %v", got, err)
+			}
+			if got, _, err := r.Get([]byte("missing"), 0); err != nil || got != nil {
+				t.Fatalf("found a missing key: %v", err)
+			}
+		}
+	}
+
+	stored, _, err := kvs.Get([]byte("text"), 0)
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+	if len(stored) >= len(values["text"]) || stored[2] != byte(COMPRESSION_LZW) {
+		t.Fatalf("text was not compressed")
+	}
+
+	for _, key := range []string{"short", "random", "gzip"} {
+		if stored, _, err = kvs.Get([]byte(key), 0); err != nil || !bytes.Equal(stored, values[key]) {
+			t.Fatalf("%s was not stored as it is: %v", key, err)
+		}
+	}
+
+	c, err := deflate.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c.Destroy()
+
+	count := 0
+	for {
+		key, value, err := c.Read(0)
+		if err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+		if c.Eof() {
+			break
+		}
+
+		if expected, ok := values[string(key)]; ok && !bytes.Equal(value, expected) {
+			t.Fatalf("value of %s read from cursor differs", key)
+		}
+		count++
+	}
+	if count != len(values)+1 {
+		t.Fatalf("expected %d keys, read %d", len(values)+1, count)
+	}
+
+	if _, err = NewCompressedKvs(kvs, 200, 0); err != ErrCompressionAlgorithm {
+		t.Fatalf("created compressed kvs with an unknown algorithm: %v", err)
+	}
+	if _, err = NewCompressedKvs(kvs, COMPRESSION_DEFLATE, 42); err == nil {
+		t.Fatal("created compressed kvs with an invalid level")
+	}
+
+	if err = kvs.Put([]byte("unknown"), []byte{compressionMagic[0], compressionMagic[1], 200}, 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if _, _, err = deflate.Get([]byte("unknown"), 0); err != ErrCompressionAlgorithm {
+		t.Fatalf("decoded a value of an unknown algorithm: %v", err)
+	}
+}
+
+func TestCompressedCursorForEach(t *testing.T) {
+	kvs := makeAndOpenKvs(compressTestKvsName, params{})
+	defer kvdb.KvsDrop(compressTestKvsName)
+	defer kvs.Close()
+
+	c, err := NewCompressedKvs(kvs, COMPRESSION_DEFLATE, flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("failed to create compressed kvs: %s", err)
+	}
+
+	values := [][]byte{
+		bytes.Repeat([]byte("heterogeneous-memory storage engine "), 100),
+		[]byte("short"),
+		{},
+	}
+	for i, value := range values {
+		if err = c.Put([]byte{byte(i)}, value, 0); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+
+	cursor, err := c.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer cursor.Destroy()
+
+	i := 0
+	err = cursor.ForEach(func(key []byte, value []byte) error {
+		if !bytes.Equal(key, []byte{byte(i)}) || !bytes.Equal(value, values[i]) {
+			t.Errorf("unexpected pair %d: key %x, value of %d bytes", i, key, len(value))
+		}
+		i++
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to iterate: %s", err)
+	}
+	if i != len(values) {
+		t.Fatalf("expected %d pairs, got %d", len(values), i)
+	}
+}
@@ -0,0 +1,91 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MismatchError is returned by conditional writes when the current value of
+// the key is not the expected one
+type MismatchError struct {
+	// Key is the key which was conditionally written
+	Key []byte
+	// Actual is the current value of the key, or nil if the key does not
+	// exist
+	Actual []byte
+}
+
+func (e *MismatchError) Error() string {
+	if e.Actual == nil {
+		return fmt.Sprintf("key %q does not exist", e.Key)
+	}
+
+	return fmt.Sprintf("key %q does not have the expected value", e.Key)
+}
+
+// expect checks within txn that the value of key is expected, where a nil
+// expected value means that the key must not exist
+func (k *Kvs) expect(txn *Transaction, key []byte, expected []byte) error {
+	actual, _, err := txn.Get(k, key, 0)
+	if err != nil {
+		return err
+	}
+
+	if (actual == nil) != (expected == nil) || !bytes.Equal(actual, expected) {
+		return &MismatchError{Key: key, Actual: actual}
+	}
+
+	return nil
+}
+
+// CompareAndSwap sets the value of key to new if its current value is old
+//
+// A nil old value means that the key does not exist, while an empty non-nil old
+// value means that it exists with an empty value. If the current value
+// differs, a *MismatchError is returned and nothing is written. The check and
+// the put happen in one transaction which is retried on conflicts, so a
+// concurrent write to the key between them is never lost. The Kvs must be
+// opened with "transactions.enabled=true". This function is thread safe.
+func (k *Kvs) CompareAndSwap(key []byte, old []byte, new []byte) error {
+	return k.kvdb.Transact(func(txn *Transaction) error {
+		if err := k.expect(txn, key, old); err != nil {
+			return err
+		}
+
+		return txn.Put(k, key, new, 0)
+	})
+}
+
+// PutIfAbsent sets the value of key if the key does not exist
+//
+// If the key exists, a *MismatchError holding its current value is returned.
+// See Kvs.CompareAndSwap().
+func (k *Kvs) PutIfAbsent(key []byte, value []byte) error {
+	return k.CompareAndSwap(key, nil, value)
+}
+
+// DeleteIfEquals deletes key if its current value is expected
+//
+// As with Kvs.CompareAndSwap(), a nil expected value means that the key does
+// not exist, in which case there is nothing to delete, while an empty non-nil
+// expected value means that it exists with an empty value. If the current value
+// differs, a *MismatchError is returned. See Kvs.CompareAndSwap().
+func (k *Kvs) DeleteIfEquals(key []byte, expected []byte) error {
+	return k.kvdb.Transact(func(txn *Transaction) error {
+		if err := k.expect(txn, key, expected); err != nil {
+			return err
+		}
+
+		// The key is missing, so do not write a tombstone for it
+		if expected == nil {
+			return nil
+		}
+
+		return txn.Delete(k, key, 0)
+	})
+}
@@ -0,0 +1,144 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const (
+	conditionalTestKvsName = "conditional-test"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	kvs := makeAndOpenKvs(conditionalTestKvsName, txnParams)
+	defer kvdb.KvsDrop(conditionalTestKvsName)
+	defer kvs.Close()
+
+	key := []byte("key")
+
+	if err := kvs.PutIfAbsent(key, []byte("a")); err != nil {
+		t.Fatalf("failed to put absent key: %s", err)
+	}
+
+	err := kvs.PutIfAbsent(key, []byte("b"))
+	if merr, ok := err.(*MismatchError); !ok || string(merr.Actual) != "a" {
+		t.Fatalf("expected a mismatch on an existing key, got %v", err)
+	}
+
+	if err = kvs.CompareAndSwap(key, []byte("b"), []byte("c")); err == nil {
+		t.Fatalf("swapped with the wrong old value")
+	}
+	if err = kvs.CompareAndSwap(key, []byte("a"), []byte("c")); err != nil {
+		t.Fatalf("failed to swap: %s", err)
+	}
+
+	if err = kvs.DeleteIfEquals(key, []byte("a")); err == nil {
+		t.Fatalf("deleted with the wrong value")
+	}
+	if err = kvs.DeleteIfEquals(key, []byte("c")); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	err = kvs.DeleteIfEquals(key, []byte("c"))
+	if merr, ok := err.(*MismatchError); !ok || merr.Actual != nil {
+		t.Fatalf("expected a mismatch on a missing key, got %v", err)
+	}
+
+	// An empty old value only matches an existing empty value
+	if err = kvs.CompareAndSwap(key, []byte{}, []byte("d")); err == nil {
+		t.Fatalf("swapped a missing key with an empty old value")
+	}
+
+	// A nil expected value means the key does not exist, as for
+	// CompareAndSwap, and there is nothing to delete
+	hook := &recordingHook{}
+	kvdb.SetHook(hook)
+	err = kvs.DeleteIfEquals(key, nil)
+	kvdb.SetHook(nil)
+	if err != nil {
+		t.Fatalf("failed to delete a missing key with a nil expected value: %s", err)
+	}
+	if _, ok := hook.find("kvs_delete", true); ok {
+		t.Fatalf("deleted a missing key")
+	}
+	if err = kvs.DeleteIfEquals(key, []byte{}); err == nil {
+		t.Fatalf("deleted a missing key with an empty expected value")
+	}
+
+	if err = kvs.PutIfAbsent(key, []byte{}); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if err = kvs.DeleteIfEquals(key, nil); err == nil {
+		t.Fatalf("deleted an empty value with a nil expected value")
+	}
+	if err = kvs.DeleteIfEquals(key, []byte{}); err != nil {
+		t.Fatalf("failed to delete an empty value: %s", err)
+	}
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	kvs := makeAndOpenKvs(conditionalTestKvsName, txnParams)
+	defer kvdb.KvsDrop(conditionalTestKvsName)
+	defer kvs.Close()
+
+	key := []byte("counter")
+
+	if err := kvs.PutIfAbsent(key, []byte("0")); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	const workers = 4
+	const increments = 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < increments; {
+				old, _, err := kvs.Get(key, 0)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				n, _ := strconv.Atoi(string(old))
+
+				err = kvs.CompareAndSwap(key, old, []byte(strconv.Itoa(n+1)))
+				if _, ok := err.(*MismatchError); ok {
+					continue
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				i++
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("failed to increment: %s", err)
+	}
+
+	value, _, err := kvs.Get(key, 0)
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+	if string(value) != strconv.Itoa(workers*increments) {
+		t.Fatalf("counter is %s, expected %d", value, workers*increments)
+	}
+}
@@ -0,0 +1,230 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+)
+
+const (
+	// LOG_DESTINATION_STDOUT logs to standard output
+	LOG_DESTINATION_STDOUT = "stdout"
+	// LOG_DESTINATION_STDERR logs to standard error
+	LOG_DESTINATION_STDERR = "stderr"
+	// LOG_DESTINATION_FILE logs to LoggingConfig.Path
+	LOG_DESTINATION_FILE = "file"
+	// LOG_DESTINATION_SYSLOG logs to syslog
+	LOG_DESTINATION_SYSLOG = "syslog"
+)
+
+const (
+	// LOG_LEVEL_MAX is the most verbose log level (debug)
+	LOG_LEVEL_MAX = 7
+	// PERFC_LEVEL_MAX is the most detailed performance counter level
+	PERFC_LEVEL_MAX = 9
+	// REST_SOCKET_PATH_MAX is the maximum length of the REST socket path
+	REST_SOCKET_PATH_MAX = 107
+)
+
+// LoggingConfig configures HSE's logging
+type LoggingConfig struct {
+	// Enabled is whether logging is enabled
+	Enabled *bool `json:"enabled,omitempty"`
+	// Structured is whether log messages are structured
+	Structured *bool `json:"structured,omitempty"`
+	// Destination is where logs are written, one of the LOG_DESTINATION_*
+	// constants
+	Destination string `json:"destination,omitempty"`
+	// Path is the log file when Destination is LOG_DESTINATION_FILE
+	Path string `json:"path,omitempty"`
+	// Level is the maximum log level which is logged, in the range
+	// [0, LOG_LEVEL_MAX]
+	Level *int `json:"level,omitempty"`
+	// SquelchNs is the interval in nanoseconds during which repeated log
+	// messages are dropped
+	SquelchNs *uint64 `json:"squelch_ns,omitempty"`
+}
+
+// RestConfig configures HSE's REST server
+type RestConfig struct {
+	// Enabled is whether the REST server is enabled
+	Enabled *bool `json:"enabled,omitempty"`
+	// SocketPath is the UNIX domain socket the REST server listens on
+	SocketPath string `json:"socket_path,omitempty"`
+}
+
+// PerfcConfig configures HSE's performance counters
+type PerfcConfig struct {
+	// Level is the performance counter level, in the range
+	// [0, PERFC_LEVEL_MAX]
+	Level *int `json:"level,omitempty"`
+}
+
+// GlobalConfig is the configuration of the HSE KVDB subsystem
+//
+// It marshals to and from the JSON format of the HSE global configuration
+// file. Unset fields, nil pointers and empty strings, leave the HSE default in
+// place.
+type GlobalConfig struct {
+	Logging LoggingConfig `json:"logging"`
+	Rest    RestConfig    `json:"rest"`
+	Perfc   PerfcConfig   `json:"perfc"`
+	// VlbCacheSize is the size in bytes of the value buffer cache
+	VlbCacheSize *uint64 `json:"vlb_cache_sz,omitempty"`
+}
+
+// GlobalConfigError is returned when a GlobalConfig is invalid
+type GlobalConfigError struct {
+	// Param is the name of the invalid param, such as "logging.level"
+	Param string
+	// Reason describes why the value is invalid
+	Reason string
+}
+
+func (e *GlobalConfigError) Error() string {
+	return fmt.Sprintf("invalid global config: %s: %s", e.Param, e.Reason)
+}
+
+type configField struct {
+	param string
+	// ptr points at the GlobalConfig field, which is either a pointer or a
+	// string
+	ptr interface{}
+}
+
+func (c *GlobalConfig) fields() []configField {
+	return []configField{
+		{"logging.enabled", &c.Logging.Enabled},
+		{"logging.structured", &c.Logging.Structured},
+		{"logging.destination", &c.Logging.Destination},
+		{"logging.path", &c.Logging.Path},
+		{"logging.level", &c.Logging.Level},
+		{"logging.squelch_ns", &c.Logging.SquelchNs},
+		{"rest.enabled", &c.Rest.Enabled},
+		{"rest.socket_path", &c.Rest.SocketPath},
+		{"perfc.level", &c.Perfc.Level},
+		{"vlb_cache_sz", &c.VlbCacheSize},
+	}
+}
+
+// value returns the field's value and whether it is set
+func (f configField) value() (interface{}, bool) {
+	v := reflect.ValueOf(f.ptr).Elem()
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, false
+		}
+		return v.Elem().Interface(), true
+	case reflect.String:
+		return v.String(), v.Len() > 0
+	}
+
+	return nil, false
+}
+
+// Validate checks that the values of the set fields are within HSE's limits
+func (c *GlobalConfig) Validate() error {
+	switch c.Logging.Destination {
+	case "", LOG_DESTINATION_STDOUT, LOG_DESTINATION_STDERR, LOG_DESTINATION_FILE, LOG_DESTINATION_SYSLOG:
+	default:
+		return &GlobalConfigError{"logging.destination", fmt.Sprintf("unknown destination %q", c.Logging.Destination)}
+	}
+
+	if c.Logging.Destination == LOG_DESTINATION_FILE && c.Logging.Path == "" {
+		return &GlobalConfigError{"logging.path", "required when logging to a file"}
+	}
+
+	if l := c.Logging.Level; l != nil && (*l < 0 || *l > LOG_LEVEL_MAX) {
+		return &GlobalConfigError{"logging.level", fmt.Sprintf("%d is not in the range [0, %d]", *l, LOG_LEVEL_MAX)}
+	}
+
+	if len(c.Rest.SocketPath) > REST_SOCKET_PATH_MAX {
+		return &GlobalConfigError{"rest.socket_path", fmt.Sprintf("longer than %d bytes", REST_SOCKET_PATH_MAX)}
+	}
+
+	if l := c.Perfc.Level; l != nil && (*l < 0 || *l > PERFC_LEVEL_MAX) {
+		return &GlobalConfigError{"perfc.level", fmt.Sprintf("%d is not in the range [0, %d]", *l, PERFC_LEVEL_MAX)}
+	}
+
+	return nil
+}
+
+// Params returns the set fields as "param=value" strings suitable for Init()
+func (c *GlobalConfig) Params() []string {
+	var params []string
+
+	for _, f := range c.fields() {
+		if v, ok := f.value(); ok {
+			params = append(params, fmt.Sprintf("%s=%v", f.param, v))
+		}
+	}
+
+	return params
+}
+
+// LoadGlobalConfig reads and validates a GlobalConfig from a JSON file
+//
+// Unknown params are rejected.
+func LoadGlobalConfig(path string) (*GlobalConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config GlobalConfig
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse global config %s: %s", path, err)
+	}
+
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// InitWithGlobalConfig initializes the HSE KVDB subsystem with a GlobalConfig
+//
+// The config is validated before HSE is called. params are applied after the
+// config, so they take precedence. See Init().
+func InitWithGlobalConfig(config *GlobalConfig, params ...string) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	return Init(append(config.Params(), params...)...)
+}
+
+// GlobalConfigGet returns the effective configuration of the HSE KVDB
+// subsystem
+//
+// Every field is set, including those left at their defaults. Init() must
+// have been called. This function is thread safe.
+func GlobalConfigGet() (*GlobalConfig, error) {
+	var config GlobalConfig
+
+	for _, f := range config.fields() {
+		value, err := ParamGet(f.param)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get param %s: %s", f.param, err)
+		}
+
+		if err = json.Unmarshal([]byte(value), f.ptr); err != nil {
+			return nil, fmt.Errorf("failed to parse param %s: %s", f.param, err)
+		}
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,131 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlobalConfigParams(t *testing.T) {
+	enabled := false
+	level := 3
+
+	config := GlobalConfig{
+		Logging: LoggingConfig{
+			Enabled:     &enabled,
+			Destination: LOG_DESTINATION_STDERR,
+			Level:       &level,
+		},
+		Rest: RestConfig{SocketPath: "/tmp/hse-test.sock"},
+	}
+
+	expected := []string{
+		"logging.enabled=false",
+		"logging.destination=stderr",
+		"logging.level=3",
+		"rest.socket_path=/tmp/hse-test.sock",
+	}
+	if params := config.Params(); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("params are %q, expected %q", params, expected)
+	}
+
+	data, err := json.Marshal(&config)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %s", err)
+	}
+
+	expectedJSON := `{"logging":{"enabled":false,"destination":"stderr","level":3},"rest":{"socket_path":"/tmp/hse-test.sock"},"perfc":{}}`
+	if string(data) != expectedJSON {
+		t.Fatalf("config marshaled to %s, expected %s", data, expectedJSON)
+	}
+}
+
+func TestGlobalConfigValidate(t *testing.T) {
+	level := LOG_LEVEL_MAX + 1
+	perfc := -1
+
+	tests := []struct {
+		config GlobalConfig
+		param  string
+	}{
+		{GlobalConfig{}, ""},
+		{GlobalConfig{Logging: LoggingConfig{Destination: "tape"}}, "logging.destination"},
+		{GlobalConfig{Logging: LoggingConfig{Destination: LOG_DESTINATION_FILE}}, "logging.path"},
+		{GlobalConfig{Logging: LoggingConfig{Level: &level}}, "logging.level"},
+		{GlobalConfig{Rest: RestConfig{SocketPath: string(make([]byte, REST_SOCKET_PATH_MAX+1))}}, "rest.socket_path"},
+		{GlobalConfig{Perfc: PerfcConfig{Level: &perfc}}, "perfc.level"},
+	}
+
+	for _, test := range tests {
+		err := test.config.Validate()
+		if test.param == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			continue
+		}
+
+		cerr, ok := err.(*GlobalConfigError)
+		if !ok || cerr.Param != test.param {
+			t.Errorf("expected an error for %s, got %v", test.param, err)
+		}
+	}
+}
+
+func TestLoadGlobalConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hse-go-config")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hse.conf")
+
+	err = ioutil.WriteFile(path, []byte(`{"logging": {"destination": "file", "path": "/tmp/hse.log"}, "vlb_cache_sz": 1024}`), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+
+	config, err := LoadGlobalConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err)
+	}
+	if config.Logging.Path != "/tmp/hse.log" || config.VlbCacheSize == nil || *config.VlbCacheSize != 1024 {
+		t.Fatalf("unexpected config %+v", config)
+	}
+
+	if err = ioutil.WriteFile(path, []byte(`{"logging": {"colour": true}}`), 0644); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+	if _, err = LoadGlobalConfig(path); err == nil {
+		t.Fatalf("loaded config with an unknown param")
+	}
+
+	if err = ioutil.WriteFile(path, []byte(`{"logging": {"destination": "file"}}`), 0644); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+	if _, err = LoadGlobalConfig(path); err == nil {
+		t.Fatalf("loaded an invalid config")
+	}
+}
+
+func TestGlobalConfigGet(t *testing.T) {
+	config, err := GlobalConfigGet()
+	if err != nil {
+		t.Fatalf("failed to get global config: %s", err)
+	}
+
+	for _, f := range config.fields() {
+		if _, ok := f.value(); !ok {
+			t.Errorf("%s is not set in the effective config", f.param)
+		}
+	}
+}
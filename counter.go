@@ -0,0 +1,117 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"syscall"
+)
+
+// Counter is an atomic int64 counter persisted in a Kvs
+//
+// A Counter may be split into shards, each stored under its own key, to reduce
+// transaction conflicts between concurrent writers. Add() updates a single
+// shard while Get() sums all of them from one transaction snapshot. The Kvs must
+// be opened with "transactions.enabled=true".
+type Counter struct {
+	next   uint32
+	kvs    *Kvs
+	keys   [][]byte
+	shards uint32
+}
+
+// NewCounter creates a Counter stored under key in kvs
+//
+// When shards is greater than 1, shard i is stored under key followed by i as a
+// 2-byte big-endian integer. The same number of shards must be used every time
+// the counter is opened.
+func NewCounter(kvs *Kvs, key []byte, shards uint16) *Counter {
+	if shards == 0 {
+		shards = 1
+	}
+
+	keys := make([][]byte, shards)
+	if shards == 1 {
+		keys[0] = append([]byte(nil), key...)
+	} else {
+		for i := range keys {
+			keys[i] = make([]byte, len(key)+2)
+			copy(keys[i], key)
+			binary.BigEndian.PutUint16(keys[i][len(key):], uint16(i))
+		}
+	}
+
+	return &Counter{
+		kvs:    kvs,
+		keys:   keys,
+		shards: uint32(shards),
+	}
+}
+
+func decodeCounter(value []byte) (int64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	if len(value) != 8 {
+		return 0, syscall.EINVAL
+	}
+
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
+
+// Add atomically adds delta to the counter
+//
+// This function is thread safe.
+func (c *Counter) Add(delta int64) error {
+	key := c.keys[(atomic.AddUint32(&c.next, 1)-1)%c.shards]
+
+	return c.kvs.kvdb.Transact(func(txn *Transaction) error {
+		value, _, err := txn.Get(c.kvs, key, 0)
+		if err != nil {
+			return err
+		}
+
+		n, err := decodeCounter(value)
+		if err != nil {
+			return err
+		}
+
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, uint64(n+delta))
+
+		return txn.Put(c.kvs, key, buf, 0)
+	})
+}
+
+// Get returns the current value of the counter
+//
+// This function is thread safe.
+func (c *Counter) Get() (int64, error) {
+	var sum int64
+
+	err := c.kvs.kvdb.Transact(func(txn *Transaction) error {
+		sum = 0
+
+		for _, key := range c.keys {
+			value, _, err := txn.Get(c.kvs, key, 0)
+			if err != nil {
+				return err
+			}
+
+			n, err := decodeCounter(value)
+			if err != nil {
+				return err
+			}
+
+			sum += n
+		}
+
+		return nil
+	})
+
+	return sum, err
+}
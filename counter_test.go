@@ -0,0 +1,51 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"sync"
+	"testing"
+)
+
+const (
+	counterTestKvsName = "counter-test"
+)
+
+func TestCounter(t *testing.T) {
+	kvs := makeAndOpenKvs(counterTestKvsName, txnParams)
+	defer kvdb.KvsDrop(counterTestKvsName)
+	defer kvs.Close()
+
+	for _, shards := range []uint16{1, 4} {
+		counter := NewCounter(kvs, []byte("counter"), shards)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					if err := counter.Add(1); err != nil {
+						t.Errorf("failed to add to counter: %s", err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if err := counter.Add(-30); err != nil {
+			t.Fatalf("failed to add to counter: %s", err)
+		}
+
+		value, err := counter.Get()
+		if err != nil {
+			t.Fatalf("failed to get counter: %s", err)
+		}
+		if value != 50 {
+			t.Fatalf("expected counter with %d shards to be 50, got %d", shards, value)
+		}
+	}
+}
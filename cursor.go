@@ -8,6 +8,7 @@ package hse
 // #include <hse/hse.h>
 import "C"
 import (
+	"context"
 	"unsafe"
 
 	"github.com/hse-project/hse-go/limits"
@@ -16,6 +17,10 @@ import (
 type Cursor struct {
 	impl *C.struct_hse_kvs_cursor
 	eof  bool
+	kvs  *Kvs
+	ctx  context.Context
+	// txn is whether the cursor was created within a transaction
+	txn bool
 }
 
 type CursorCreateFlag uint
@@ -28,13 +33,26 @@ const (
 	CURSOR_CREATE_REV CursorCreateFlag = C.HSE_CURSOR_CREATE_REV
 )
 
+// startOp reports the start of an operation on the cursor to the Kvdb's Hook
+func (c *Cursor) startOp(name string, keyLen int) *opTrace {
+	if c.kvs == nil {
+		return nil
+	}
+
+	return c.kvs.kvdb.startOp(c.ctx, name, c.kvs.name, c.txn, keyLen, 0)
+}
+
 func (c *Cursor) UpdateView(flags CursorUpdateViewFlags) error {
-	err := C.hse_kvs_cursor_update_view(c.impl, C.uint(flags))
-	if err != 0 {
-		return hseErrToErrno(err)
+	t := c.startOp("cursor_update_view", 0)
+
+	var err error
+	if rc := C.hse_kvs_cursor_update_view(c.impl, C.uint(flags)); rc != 0 {
+		err = hseErrToErrno(rc)
 	}
 
-	return nil
+	t.done(0, 0, err)
+
+	return err
 }
 
 func (c *Cursor) Seek(key []byte, flags CursorSeekFlags) ([]byte, error) {
@@ -42,15 +60,21 @@ func (c *Cursor) Seek(key []byte, flags CursorSeekFlags) ([]byte, error) {
 	var found unsafe.Pointer
 	var foundLen C.size_t
 
-	if key != nil {
+	if len(key) > 0 {
 		keyPtr = unsafe.Pointer(&key[0])
 	}
 
-	err := C.hse_kvs_cursor_seek(c.impl, C.uint(flags), keyPtr, C.size_t(len(key)), &found, &foundLen)
-	if err != 0 {
-		return nil, hseErrToErrno(err)
+	t := c.startOp("cursor_seek", len(key))
+
+	rc := C.hse_kvs_cursor_seek(c.impl, C.uint(flags), keyPtr, C.size_t(len(key)), &found, &foundLen)
+	if rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(len(key), 0, err)
+		return nil, err
 	}
 
+	t.done(int(foundLen), 0, nil)
+
 	if found == nil {
 		return nil, nil
 	}
@@ -64,18 +88,24 @@ func (c *Cursor) SeekRange(filtMin []byte, filtMax []byte, flags CursorSeekRange
 	var found unsafe.Pointer
 	var foundLen C.size_t
 
-	if filtMin != nil {
+	if len(filtMin) > 0 {
 		filtMinPtr = unsafe.Pointer(&filtMin[0])
 	}
-	if filtMax != nil {
+	if len(filtMax) > 0 {
 		filtMaxPtr = unsafe.Pointer(&filtMax[0])
 	}
 
-	err := C.hse_kvs_cursor_seek_range(c.impl, C.uint(flags), filtMinPtr, C.size_t(len(filtMin)), filtMaxPtr, C.size_t(len(filtMax)), &found, &foundLen)
-	if err != 0 {
-		return nil, hseErrToErrno(err)
+	t := c.startOp("cursor_seek_range", len(filtMin))
+
+	rc := C.hse_kvs_cursor_seek_range(c.impl, C.uint(flags), filtMinPtr, C.size_t(len(filtMin)), filtMaxPtr, C.size_t(len(filtMax)), &found, &foundLen)
+	if rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(len(filtMin), 0, err)
+		return nil, err
 	}
 
+	t.done(int(foundLen), 0, nil)
+
 	if found == nil {
 		return nil, nil
 	}
@@ -90,11 +120,17 @@ func (c *Cursor) Read(flags CursorReadFlags) ([]byte, []byte, error) {
 	var valueLen C.size_t
 	var eof C.bool
 
-	err := C.hse_kvs_cursor_read(c.impl, C.uint(flags), &keyPtr, &keyLen, &valuePtr, &valueLen, &eof)
-	if err != 0 {
-		return nil, nil, hseErrToErrno(err)
+	t := c.startOp("cursor_read", 0)
+
+	rc := C.hse_kvs_cursor_read(c.impl, C.uint(flags), &keyPtr, &keyLen, &valuePtr, &valueLen, &eof)
+	if rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(0, 0, err)
+		return nil, nil, err
 	}
 
+	t.done(int(keyLen), int(valueLen), nil)
+
 	var key []byte
 	var value []byte
 
@@ -119,13 +155,18 @@ func (c *Cursor) Destroy() error {
 		return nil
 	}
 
-	err := C.hse_kvs_cursor_destroy(c.impl)
-	if err != 0 {
-		return hseErrToErrno(err)
+	t := c.startOp("cursor_destroy", 0)
+
+	if rc := C.hse_kvs_cursor_destroy(c.impl); rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(0, 0, err)
+		return err
 	}
 
 	c.impl = nil
 
+	t.done(0, 0, nil)
+
 	return nil
 }
 
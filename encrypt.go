@@ -0,0 +1,491 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	// ENCRYPTION_HEADER_LEN is the number of bytes prepended to every value
+	// written through an EncryptedKvs: the key ID followed by the nonce
+	ENCRYPTION_HEADER_LEN uint = 4 + encryptionNonceLen
+	// ENCRYPTION_OVERHEAD is the number of bytes by which an EncryptedKvs
+	// lengthens every value
+	ENCRYPTION_OVERHEAD uint = ENCRYPTION_HEADER_LEN + 16
+)
+
+const encryptionNonceLen = 12
+
+var (
+	// ErrEncryptionKey is returned when a value is encrypted with a key which
+	// has not been added to the EncryptedKvs
+	ErrEncryptionKey = errors.New("hse: unknown encryption key")
+	// ErrDecrypt is returned when a value cannot be authenticated, because it
+	// was modified, was moved from another key, or was not written through an
+	// EncryptedKvs
+	ErrDecrypt = errors.New("hse: value authentication failed")
+)
+
+// EncryptionStats are counters describing the work done by re-encryption
+type EncryptionStats struct {
+	// Scanned is the number of values which have been examined
+	Scanned uint64
+	// Reencrypted is the number of values which have been sealed again with
+	// the current key
+	Reencrypted uint64
+	// Errors is the number of re-encryption passes which failed
+	Errors uint64
+}
+
+// EncryptedKvs seals values with AES-GCM before they are stored in a Kvs
+//
+// Each value is stored behind a header holding the ID of the key it was sealed
+// with and a random nonce. The Kvs key is authenticated along with the value,
+// so a value copied to another key fails to decrypt. Values are always written
+// with the current key while older keys remain available for reading, which
+// allows keys to be rotated with Rotate() followed by re-encryption of the
+// existing values. HSE's own compression is turned off for every value since
+// ciphertext does not compress. Every write is made in a transaction, so the
+// Kvs must be opened with "transactions.enabled=true".
+type EncryptedKvs struct {
+	stats EncryptionStats
+
+	kvs *Kvs
+
+	keysMu  sync.RWMutex
+	keys    map[uint32]cipher.AEAD
+	current uint32
+
+	mu  sync.Mutex
+	job *reencryption
+}
+
+// reencryption is a background re-encryption pass
+type reencryption struct {
+	stop chan struct{}
+	done chan struct{}
+	err  error
+}
+
+// EncryptedCursor is a Cursor which decrypts values
+type EncryptedCursor struct {
+	*Cursor
+	enc *EncryptedKvs
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, syscall.EINVAL
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// NewEncryptedKvs creates an EncryptedKvs storing values in kvs sealed with
+// key, which is identified by id
+//
+// The key must be 16, 24 or 32 bytes long to select AES-128, AES-192 or
+// AES-256.
+func NewEncryptedKvs(kvs *Kvs, id uint32, key []byte) (*EncryptedKvs, error) {
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptedKvs{
+		kvs:     kvs,
+		keys:    map[uint32]cipher.AEAD{id: aead},
+		current: id,
+	}, nil
+}
+
+// AddKey makes a key available for decrypting values sealed with it
+//
+// Adding a key under an ID which is already in use replaces that key. This
+// function is thread safe.
+func (e *EncryptedKvs) AddKey(id uint32, key []byte) error {
+	aead, err := newAead(key)
+	if err != nil {
+		return err
+	}
+
+	e.keysMu.Lock()
+	e.keys[id] = aead
+	e.keysMu.Unlock()
+
+	return nil
+}
+
+// Rotate adds a key and makes it the one new values are sealed with
+//
+// The previous keys are kept for reading until they are removed with
+// RemoveKey(). This function is thread safe.
+func (e *EncryptedKvs) Rotate(id uint32, key []byte) error {
+	aead, err := newAead(key)
+	if err != nil {
+		return err
+	}
+
+	e.keysMu.Lock()
+	e.keys[id] = aead
+	e.current = id
+	e.keysMu.Unlock()
+
+	return nil
+}
+
+// RemoveKey forgets a key which is no longer used by any value
+//
+// The current key cannot be removed. This function is thread safe.
+func (e *EncryptedKvs) RemoveKey(id uint32) error {
+	e.keysMu.Lock()
+	defer e.keysMu.Unlock()
+
+	if id == e.current {
+		return syscall.EBUSY
+	}
+
+	delete(e.keys, id)
+
+	return nil
+}
+
+// seal encrypts value for key with the current key
+func (e *EncryptedKvs) seal(key, value []byte) ([]byte, error) {
+	e.keysMu.RLock()
+	id, aead := e.current, e.keys[e.current]
+	e.keysMu.RUnlock()
+
+	stored := make([]byte, ENCRYPTION_HEADER_LEN, ENCRYPTION_OVERHEAD+uint(len(value)))
+	binary.BigEndian.PutUint32(stored, id)
+
+	nonce := stored[4:ENCRYPTION_HEADER_LEN]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(stored, nonce, value, key), nil
+}
+
+// open decrypts a value stored under key
+func (e *EncryptedKvs) open(key, stored []byte) ([]byte, error) {
+	if uint(len(stored)) < ENCRYPTION_OVERHEAD {
+		return nil, ErrDecrypt
+	}
+
+	e.keysMu.RLock()
+	aead, ok := e.keys[binary.BigEndian.Uint32(stored)]
+	e.keysMu.RUnlock()
+
+	if !ok {
+		return nil, ErrEncryptionKey
+	}
+
+	value, err := aead.Open(nil, stored[4:ENCRYPTION_HEADER_LEN], stored[ENCRYPTION_HEADER_LEN:], key)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return value, nil
+}
+
+// Put encrypts value and places the KV pair into the Kvs
+//
+// This function is thread safe.
+func (e *EncryptedKvs) Put(key, value []byte, flags PutFlags) error {
+	stored, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return e.kvs.kvdb.Transact(func(txn *Transaction) error {
+		return txn.Put(e.kvs, key, stored, flags|KVS_PUT_VCOMP_OFF)
+	})
+}
+
+// Get retrieves and decrypts the value for a given key
+//
+// The returned length is that of the decrypted value. This function is thread
+// safe.
+func (e *EncryptedKvs) Get(key []byte, flags GetFlags) ([]byte, uint, error) {
+	stored, _, err := e.kvs.Get(key, flags)
+	if err != nil || stored == nil {
+		return nil, 0, err
+	}
+
+	value, err := e.open(key, stored)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return value, uint(len(value)), nil
+}
+
+// Delete deletes the key and its associated value from the Kvs
+//
+// This function is thread safe.
+func (e *EncryptedKvs) Delete(key []byte, flags DeleteFlags) error {
+	return e.kvs.kvdb.Transact(func(txn *Transaction) error {
+		return txn.Delete(e.kvs, key, flags)
+	})
+}
+
+// CreateCursor creates a cursor which decrypts the values it reads
+//
+// See Kvs.CreateCursor().
+func (e *EncryptedKvs) CreateCursor(filt []byte, flags CursorCreateFlag) (*EncryptedCursor, error) {
+	c, err := e.kvs.CreateCursor(filt, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptedCursor{Cursor: c, enc: e}, nil
+}
+
+// Read reads the next KV pair from the cursor and decrypts its value
+//
+// Decrypted values are allocated by Go and remain valid after the next read.
+func (c *EncryptedCursor) Read(flags CursorReadFlags) ([]byte, []byte, error) {
+	key, stored, err := c.Cursor.Read(flags)
+	if err != nil || c.Eof() {
+		return key, nil, err
+	}
+
+	value, err := c.enc.open(key, stored)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, value, nil
+}
+
+// ReadBatch reads up to n KV pairs from the cursor and decrypts their values
+//
+// Decrypted values are allocated by Go and remain valid after buf is reused.
+// See Cursor.ReadBatch().
+func (c *EncryptedCursor) ReadBatch(n int, buf []byte, flags CursorReadFlags) ([]KeyValue, error) {
+	pairs, err := c.Cursor.ReadBatch(n, buf, flags)
+
+	for i := range pairs {
+		value, openErr := c.enc.open(pairs[i].Key, pairs[i].Value)
+		if openErr != nil {
+			return pairs[:i], openErr
+		}
+
+		pairs[i].Value = value
+	}
+
+	return pairs, err
+}
+
+// ForEach calls fn with every remaining KV pair of the cursor in order, with
+// its value decrypted
+//
+// See Cursor.ForEach().
+func (c *EncryptedCursor) ForEach(fn func(key []byte, value []byte) error) error {
+	return forEach(c, fn)
+}
+
+// Reencrypt seals every value which is not sealed with the current key again
+// with the current key
+//
+// The Kvs is walked with a cursor, and the values found are rewritten in
+// transactions of at most batch values. A value which changes after the cursor
+// has read it is left alone. The number of values which were rewritten is
+// returned. This function is thread safe.
+func (e *EncryptedKvs) Reencrypt(batch int) (uint64, error) {
+	return e.reencrypt(batch, nil)
+}
+
+func (e *EncryptedKvs) reencrypt(batch int, stop <-chan struct{}) (uint64, error) {
+	n, err := e.reencryptPass(batch, stop)
+	if err != nil {
+		atomic.AddUint64(&e.stats.Errors, 1)
+	}
+
+	return n, err
+}
+
+func (e *EncryptedKvs) reencryptPass(batch int, stop <-chan struct{}) (uint64, error) {
+	var reencrypted uint64
+
+	if batch <= 0 {
+		batch = 1
+	}
+
+	c, err := e.kvs.CreateCursor(nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Destroy()
+
+	keys := make([][]byte, 0, batch)
+	values := make([][]byte, 0, batch)
+
+	flush := func() error {
+		var n uint64
+
+		err := e.kvs.kvdb.Transact(func(txn *Transaction) error {
+			n = 0
+
+			for i, key := range keys {
+				stored, _, err := txn.Get(e.kvs, key, 0)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(stored, values[i]) {
+					continue
+				}
+
+				value, err := e.open(key, stored)
+				if err != nil {
+					return err
+				}
+				if stored, err = e.seal(key, value); err != nil {
+					return err
+				}
+				if err = txn.Put(e.kvs, key, stored, KVS_PUT_VCOMP_OFF); err != nil {
+					return err
+				}
+
+				n++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		reencrypted += n
+		atomic.AddUint64(&e.stats.Reencrypted, n)
+
+		keys = keys[:0]
+		values = values[:0]
+
+		return nil
+	}
+
+	stopped := false
+
+	err = c.ForEach(func(key []byte, stored []byte) error {
+		select {
+		case <-stop:
+			stopped = true
+			return ErrStopIteration
+		default:
+		}
+
+		atomic.AddUint64(&e.stats.Scanned, 1)
+
+		e.keysMu.RLock()
+		current := e.current
+		e.keysMu.RUnlock()
+
+		if uint(len(stored)) >= ENCRYPTION_HEADER_LEN && binary.BigEndian.Uint32(stored) == current {
+			return nil
+		}
+
+		keys = append(keys, append([]byte(nil), key...))
+		values = append(values, append([]byte(nil), stored...))
+		if len(keys) == batch {
+			return flush()
+		}
+
+		return nil
+	})
+	if err != nil || stopped {
+		return reencrypted, err
+	}
+
+	if err = flush(); err != nil {
+		return reencrypted, err
+	}
+
+	return reencrypted, nil
+}
+
+// StartReencryption starts a background goroutine which makes one
+// Reencrypt() pass over the Kvs
+//
+// Calling StartReencryption() while a pass is running is a no-op.
+func (e *EncryptedKvs) StartReencryption(batch int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.job != nil {
+		select {
+		case <-e.job.done:
+		default:
+			return
+		}
+	}
+
+	job := &reencryption{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	e.job = job
+
+	go func() {
+		defer close(job.done)
+
+		_, job.err = e.reencrypt(batch, job.stop)
+	}()
+}
+
+// ReencryptionDone returns a channel which is closed when the background
+// re-encryption pass started by StartReencryption() has finished
+//
+// The channel is nil if no pass has been started.
+func (e *EncryptedKvs) ReencryptionDone() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.job == nil {
+		return nil
+	}
+
+	return e.job.done
+}
+
+// StopReencryption stops the background re-encryption pass, waits for it to
+// exit and returns the error it failed with, if any
+func (e *EncryptedKvs) StopReencryption() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.job == nil {
+		return nil
+	}
+
+	close(e.job.stop)
+	<-e.job.done
+
+	err := e.job.err
+	e.job = nil
+
+	return err
+}
+
+// Stats returns a snapshot of the re-encryption counters
+func (e *EncryptedKvs) Stats() EncryptionStats {
+	return EncryptionStats{
+		Scanned:     atomic.LoadUint64(&e.stats.Scanned),
+		Reencrypted: atomic.LoadUint64(&e.stats.Reencrypted),
+		Errors:      atomic.LoadUint64(&e.stats.Errors),
+	}
+}
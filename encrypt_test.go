@@ -0,0 +1,240 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+const (
+	encryptTestKvsName = "encrypt-test"
+)
+
+func TestEncryptedKvs(t *testing.T) {
+	kvs := makeAndOpenKvs(encryptTestKvsName, txnParams)
+	defer kvdb.KvsDrop(encryptTestKvsName)
+	defer kvs.Close()
+
+	if _, err := NewEncryptedKvs(kvs, 1, []byte("short")); err != syscall.EINVAL {
+		t.Fatalf("created encrypted kvs with an invalid key: %v", err)
+	}
+
+	enc, err := NewEncryptedKvs(kvs, 1, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("failed to create encrypted kvs: %s", err)
+	}
+
+	for _, kv := range []struct{ key, value string }{{"key0", "value0"}, {"key1", "value1"}, {"empty", ""}} {
+		if err = enc.Put([]byte(kv.key), []byte(kv.value), 0); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+
+		value, n, err := enc.Get([]byte(kv.key), 0)
+		if err != nil || string(value) != kv.value || n != uint(len(kv.value)) {
+			t.Fatalf("unexpected value %q for %s: %v", value, kv.key, err)
+		}
+	}
+
+	stored, _, err := kvs.Get([]byte("key0"), 0)
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+	if uint(len(stored)) != ENCRYPTION_OVERHEAD+6 || bytes.Contains(stored, []byte("value0")) {
+		t.Fatal("value was not encrypted")
+	}
+	if binary.BigEndian.Uint32(stored) != 1 {
+		t.Fatalf("expected key id 1, got %d", binary.BigEndian.Uint32(stored))
+	}
+
+	// A value moved to another key does not authenticate
+	err = kvdb.Transact(func(txn *Transaction) error {
+		return txn.Put(kvs, []byte("key1"), stored, 0)
+	})
+	if err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if _, _, err = enc.Get([]byte("key1"), 0); err != ErrDecrypt {
+		t.Fatalf("decrypted a value swapped from another key: %v", err)
+	}
+
+	tampered := append([]byte(nil), stored...)
+	tampered[len(tampered)-1] ^= 1
+	err = kvdb.Transact(func(txn *Transaction) error {
+		return txn.Put(kvs, []byte("key0"), tampered, 0)
+	})
+	if err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if _, _, err = enc.Get([]byte("key0"), 0); err != ErrDecrypt {
+		t.Fatalf("decrypted a modified value: %v", err)
+	}
+
+	if value, _, err := enc.Get([]byte("missing"), 0); err != nil || value != nil {
+		t.Fatalf("found a missing key: %v", err)
+	}
+
+	other, err := NewEncryptedKvs(kvs, 7, bytes.Repeat([]byte{7}, 16))
+	if err != nil {
+		t.Fatalf("failed to create encrypted kvs: %s", err)
+	}
+	if _, _, err = other.Get([]byte("empty"), 0); err != ErrEncryptionKey {
+		t.Fatalf("decrypted a value with an unknown key: %v", err)
+	}
+}
+
+func TestEncryptedKvsRotate(t *testing.T) {
+	kvs := makeAndOpenKvs(encryptTestKvsName, txnParams)
+	defer kvdb.KvsDrop(encryptTestKvsName)
+	defer kvs.Close()
+
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 32)
+
+	enc, err := NewEncryptedKvs(kvs, 1, key1)
+	if err != nil {
+		t.Fatalf("failed to create encrypted kvs: %s", err)
+	}
+
+	const count = 100
+	for i := 0; i < count; i++ {
+		if err = enc.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d", i)), 0); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+
+	if err = enc.Rotate(2, key2); err != nil {
+		t.Fatalf("failed to rotate: %s", err)
+	}
+	if err = enc.RemoveKey(2); err != syscall.EBUSY {
+		t.Fatalf("removed the current key: %v", err)
+	}
+
+	if err = enc.Put([]byte("key000"), []byte("rewritten"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	n, err := enc.Reencrypt(7)
+	if err != nil {
+		t.Fatalf("failed to re-encrypt: %s", err)
+	}
+	if n != count-1 {
+		t.Fatalf("expected %d values to be re-encrypted, got %d", count-1, n)
+	}
+
+	if err = enc.RemoveKey(1); err != nil {
+		t.Fatalf("failed to remove key: %s", err)
+	}
+
+	c, err := enc.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c.Destroy()
+
+	for i := 0; ; i++ {
+		key, value, err := c.Read(0)
+		if err != nil {
+			t.Fatalf("failed to read after removing the old key: %s", err)
+		}
+		if c.Eof() {
+			if i != count {
+				t.Fatalf("expected %d keys, read %d", count, i)
+			}
+			break
+		}
+
+		expected := fmt.Sprintf("value%d", i)
+		if i == 0 {
+			expected = "rewritten"
+		}
+		if string(value) != expected {
+			t.Fatalf("unexpected value %q for %s", value, key)
+		}
+	}
+
+	// Rotate back with a background pass
+	if err = enc.Rotate(3, key1); err != nil {
+		t.Fatalf("failed to rotate: %s", err)
+	}
+
+	enc.StartReencryption(16)
+	<-enc.ReencryptionDone()
+	if err = enc.StopReencryption(); err != nil {
+		t.Fatalf("background re-encryption failed: %s", err)
+	}
+
+	if stats := enc.Stats(); stats.Reencrypted != 2*count-1 || stats.Scanned != 2*count || stats.Errors != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	stored, _, err := kvs.Get([]byte("key050"), 0)
+	if err != nil || binary.BigEndian.Uint32(stored) != 3 {
+		t.Fatalf("value was not re-encrypted with the current key: %v", err)
+	}
+}
+
+func TestEncryptedCursorForEach(t *testing.T) {
+	kvs := makeAndOpenKvs(encryptTestKvsName, txnParams)
+	defer kvdb.KvsDrop(encryptTestKvsName)
+	defer kvs.Close()
+
+	enc, err := NewEncryptedKvs(kvs, 1, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("failed to create encrypted kvs: %s", err)
+	}
+
+	const count = 10
+	for i := 0; i < count; i++ {
+		if err = enc.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+
+	c, err := enc.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c.Destroy()
+
+	i := 0
+	err = c.ForEach(func(key []byte, value []byte) error {
+		if string(key) != fmt.Sprintf("key%d", i) || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("unexpected pair (%s, %s)", key, value)
+		}
+		i++
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to iterate: %s", err)
+	}
+	if i != count {
+		t.Fatalf("expected %d pairs, got %d", count, i)
+	}
+
+	// A value sealed with an unknown key fails the iteration
+	other, err := NewEncryptedKvs(kvs, 2, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatalf("failed to create encrypted kvs: %s", err)
+	}
+	if err = other.Put([]byte("key5"), []byte("other"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	c2, err := enc.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c2.Destroy()
+
+	if err = c2.ForEach(func(key []byte, value []byte) error { return nil }); err != ErrEncryptionKey {
+		t.Fatalf("iterated over a value sealed with an unknown key: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"syscall"
+)
+
+// importBatchLen is the number of key-value pairs Kvs.Import() puts per
+// transaction
+const importBatchLen = 1024
+
+// Export writes every key-value pair of the Kvs to w
+//
+// The pairs are read through a cursor which takes on the snapshot of a
+// transaction, so the stream is a consistent point-in-time view of the Kvs
+// even while other threads keep writing to it. The number of exported pairs is
+// returned. See Kvs.Import() for loading the stream back into a Kvs. This
+// function is thread safe.
+func (k *Kvs) Export(w io.Writer, progress ProgressFunc) (uint64, error) {
+	txn := k.kvdb.NewTransaction()
+	if txn == nil {
+		return 0, syscall.ENOMEM
+	}
+	defer txn.Free()
+
+	if err := txn.Begin(); err != nil {
+		return 0, err
+	}
+	defer txn.Abort()
+
+	sw := newStreamWriter(w, progress)
+	if err := sw.writeHeader(); err != nil {
+		return 0, err
+	}
+
+	if err := k.exportTxn(context.Background(), txn, sw); err != nil {
+		return sw.count, err
+	}
+
+	return sw.count, sw.writeTrailer()
+}
+
+// exportTxn writes the records of the Kvs as seen by txn until ctx is done
+func (k *Kvs) exportTxn(ctx context.Context, txn *Transaction, sw *streamWriter) error {
+	c, err := txn.CreateCursor(k, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer c.Destroy()
+
+	return c.ForEach(func(key []byte, value []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return sw.writeRecord(key, value)
+	})
+}
+
+// Import loads a stream written by Kvs.Export() into the Kvs
+//
+// Existing keys which are also in the stream are overwritten. Every record is
+// verified against its checksum before it is put, and pairs are put in batches,
+// each within its own transaction. The trailer of the stream is verified last,
+// so an ErrStreamChecksum error may be returned after all of the pairs have
+// been loaded if the stream was truncated or altered. Use VerifyStream() to
+// check a stream before importing it. The Kvs must be opened with
+// "transactions.enabled=true". The number of imported pairs is returned.
+func (k *Kvs) Import(r io.Reader, progress ProgressFunc) (uint64, error) {
+	sr := newStreamReader(bufio.NewReader(r), progress)
+	if err := sr.readHeader(); err != nil {
+		return 0, err
+	}
+
+	count, err := k.importRecords(sr)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return count, err
+}
+
+// KvsImport loads a stream written by Kvs.Export() into the named Kvs, which is
+// created with params if it does not exist
+//
+// The Kvs is opened with "transactions.enabled=true" for the import and closed
+// once it is done, so it must not already be open. See Kvs.Import().
+func (k *Kvdb) KvsImport(kvsName string, r io.Reader, progress ProgressFunc, params ...string) (uint64, error) {
+	if err := k.KvsCreate(kvsName, params...); err != nil && err != syscall.EEXIST {
+		return 0, err
+	}
+
+	kvs, err := k.KvsOpen(kvsName, "transactions.enabled=true")
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := kvs.Import(r, progress)
+	if cerr := kvs.Close(); err == nil {
+		err = cerr
+	}
+
+	return count, err
+}
+
+// importRecords puts the records of sr until its trailer, returning io.EOF
+// once the trailer has been verified
+func (k *Kvs) importRecords(sr *streamReader) (uint64, error) {
+	var count uint64
+
+	keys := make([][]byte, 0, importBatchLen)
+	values := make([][]byte, 0, importBatchLen)
+
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+
+		err := k.kvdb.Transact(func(txn *Transaction) error {
+			for i := range keys {
+				if err := txn.Put(k, keys[i], values[i], 0); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		count += uint64(len(keys))
+		keys = keys[:0]
+		values = values[:0]
+
+		return nil
+	}
+
+	for {
+		key, value, err := sr.readRecord()
+		if err == io.EOF {
+			if err = flush(); err != nil {
+				return count, err
+			}
+			return count, io.EOF
+		}
+		if err != nil {
+			return count, err
+		}
+
+		keys = append(keys, key)
+		values = append(values, value)
+
+		if len(keys) == importBatchLen {
+			if err = flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+}
+
+// VerifyStream reads a stream written by Kvs.Export() and checks every record
+// and the trailer against their checksums without loading anything
+//
+// The number of key-value pairs in the stream is returned.
+func VerifyStream(r io.Reader, progress ProgressFunc) (uint64, error) {
+	sr := newStreamReader(bufio.NewReader(r), progress)
+	if err := sr.readHeader(); err != nil {
+		return 0, err
+	}
+
+	for {
+		_, _, err := sr.readRecord()
+		if err == io.EOF {
+			return sr.count, nil
+		}
+		if err != nil {
+			return sr.count, err
+		}
+	}
+}
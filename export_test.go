@@ -0,0 +1,116 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+const (
+	exportTestKvsName = "export-test"
+	importTestKvsName = "import-test"
+)
+
+func TestExportImport(t *testing.T) {
+	src := makeAndOpenKvs(exportTestKvsName, params{})
+	defer kvdb.KvsDrop(exportTestKvsName)
+	defer src.Close()
+
+	dst := makeAndOpenKvs(importTestKvsName, txnParams)
+	defer kvdb.KvsDrop(importTestKvsName)
+	defer dst.Close()
+
+	for i := 0; i < 2000; i++ {
+		src.Put([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+
+	var buf bytes.Buffer
+	var progressed uint64
+
+	count, err := src.Export(&buf, func(keys, bytes uint64) { progressed = keys })
+	if err != nil {
+		t.Fatalf("failed to export kvs: %s", err)
+	}
+	if count != 2000 || progressed != 2000 {
+		t.Fatalf("expected 2000 exported keys, got %d (progress %d)", count, progressed)
+	}
+
+	count, err = VerifyStream(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("failed to verify stream: %s", err)
+	}
+	if count != 2000 {
+		t.Fatalf("expected 2000 keys in stream, got %d", count)
+	}
+
+	count, err = dst.Import(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("failed to import stream: %s", err)
+	}
+	if count != 2000 {
+		t.Fatalf("expected 2000 imported keys, got %d", count)
+	}
+
+	value, _, err := dst.Get([]byte("key1234"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key1234: %s", err)
+	}
+	if string(value) != "value1234" {
+		t.Fatalf("unexpected value for key1234: %s", value)
+	}
+
+	corrupt := append([]byte(nil), buf.Bytes()...)
+	corrupt[len(corrupt)/2] ^= 0xff
+
+	if _, err = VerifyStream(bytes.NewReader(corrupt), nil); err == nil {
+		t.Fatal("corrupt stream passed verification")
+	}
+}
+
+func TestKvsImport(t *testing.T) {
+	src := makeAndOpenKvs(exportTestKvsName, params{})
+	defer kvdb.KvsDrop(exportTestKvsName)
+	defer src.Close()
+
+	for i := 0; i < 10; i++ {
+		src.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+
+	var buf bytes.Buffer
+	if _, err := src.Export(&buf, nil); err != nil {
+		t.Fatalf("failed to export kvs: %s", err)
+	}
+
+	kvdb.KvsDrop(importTestKvsName)
+	defer kvdb.KvsDrop(importTestKvsName)
+
+	// The first import creates the Kvs and the second one loads into it
+	for i := 0; i < 2; i++ {
+		count, err := kvdb.KvsImport(importTestKvsName, bytes.NewReader(buf.Bytes()), nil)
+		if err != nil {
+			t.Fatalf("failed to import stream: %s", err)
+		}
+		if count != 10 {
+			t.Fatalf("expected 10 imported keys, got %d", count)
+		}
+	}
+
+	dst, err := kvdb.KvsOpen(importTestKvsName)
+	if err != nil {
+		t.Fatalf("failed to open kvs: %s", err)
+	}
+	defer dst.Close()
+
+	value, _, err := dst.Get([]byte("key7"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key7: %s", err)
+	}
+	if string(value) != "value7" {
+		t.Fatalf("unexpected value for key7: %s", value)
+	}
+}
@@ -4,4 +4,4 @@
 
 module github.com/hse-project/hse-go
 
-go 1.12
+go 1.16
@@ -0,0 +1,187 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import "context"
+
+// Op describes a binding call observed by a Hook
+type Op struct {
+	// Name is the name of the operation, such as "kvs_put" or "cursor_read"
+	Name string
+	// Kvs is the name of the Kvs the operation applies to, if any
+	Kvs string
+	// Txn is whether the operation runs within a transaction
+	Txn bool
+	// KeyLen is the length of the key, filter or prefix passed to or returned
+	// by the operation
+	KeyLen int
+	// ValueLen is the length of the value passed to or returned by the
+	// operation
+	ValueLen int
+	// Err is the error returned by the operation. It is only set when
+	// Hook.End() is called.
+	Err error
+}
+
+// Hook observes every binding call made through a Kvdb and the Kvs, Cursor and
+// Transaction objects which belong to it
+//
+// Start() is called before the call into HSE and End() after it. The context
+// returned by Start() is passed to the matching End(), which allows a Hook to
+// start a tracing span in Start(), carry it in the context, and end it in
+// End(). The context passed to Start() is the one attached with WithContext(),
+// or context.Background(). A Hook must be safe for concurrent use.
+type Hook interface {
+	Start(ctx context.Context, op *Op) context.Context
+	End(ctx context.Context, op *Op)
+}
+
+// opTrace is an operation in progress which is reported to a Hook
+type opTrace struct {
+	hook Hook
+	ctx  context.Context
+	op   Op
+}
+
+// startOp reports the start of an operation to the Kvdb's hook, returning nil
+// if there is no hook
+func (k *Kvdb) startOp(ctx context.Context, name string, kvs string, txn bool, keyLen int, valueLen int) *opTrace {
+	if k == nil || k.hook == nil {
+		return nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	t := &opTrace{
+		hook: k.hook,
+		op: Op{
+			Name:     name,
+			Kvs:      kvs,
+			Txn:      txn,
+			KeyLen:   keyLen,
+			ValueLen: valueLen,
+		},
+	}
+	t.ctx = t.hook.Start(ctx, &t.op)
+
+	return t
+}
+
+// done reports the end of an operation with the final key and value lengths
+func (t *opTrace) done(keyLen int, valueLen int, err error) {
+	if t == nil {
+		return
+	}
+
+	t.op.KeyLen = keyLen
+	t.op.ValueLen = valueLen
+	t.op.Err = err
+	t.hook.End(t.ctx, &t.op)
+}
+
+// hookList is a Hook which reports operations to several Hooks
+//
+// Start() is called on each Hook in order, each one receiving the context
+// returned by the previous one, and End() in reverse order with the final
+// context.
+type hookList []Hook
+
+func (l hookList) Start(ctx context.Context, op *Op) context.Context {
+	for _, hook := range l {
+		ctx = hook.Start(ctx, op)
+	}
+
+	return ctx
+}
+
+func (l hookList) End(ctx context.Context, op *Op) {
+	for i := len(l) - 1; i >= 0; i-- {
+		l[i].End(ctx, op)
+	}
+}
+
+// SetHook sets the Hook which observes operations on the Kvdb
+//
+// Any hook already set or added with AddHook() is replaced. Passing nil removes
+// all hooks. This function is not thread safe and should be called before the
+// Kvdb is used.
+func (k *Kvdb) SetHook(hook Hook) {
+	k.hook = hook
+}
+
+// AddHook adds a Hook which observes operations on the Kvdb alongside any hook
+// already set
+//
+// Hooks are started in the order they were added and ended in reverse order.
+// Like SetHook(), this function is not thread safe and should be called before
+// the Kvdb is used.
+func (k *Kvdb) AddHook(hook Hook) {
+	switch current := k.hook.(type) {
+	case nil:
+		k.hook = hook
+	case hookList:
+		k.hook = append(append(hookList(nil), current...), hook)
+	default:
+		k.hook = hookList{current, hook}
+	}
+}
+
+// RemoveHook removes a Hook set with SetHook() or AddHook(), leaving any other
+// hook in place
+//
+// The hook must be comparable, such as a pointer. Like SetHook(), this function
+// is not thread safe.
+func (k *Kvdb) RemoveHook(hook Hook) {
+	current, ok := k.hook.(hookList)
+	if !ok {
+		if k.hook == hook {
+			k.hook = nil
+		}
+		return
+	}
+
+	var remaining hookList
+	for _, h := range current {
+		if h != hook {
+			remaining = append(remaining, h)
+		}
+	}
+
+	switch len(remaining) {
+	case 0:
+		k.hook = nil
+	case 1:
+		k.hook = remaining[0]
+	default:
+		k.hook = remaining
+	}
+}
+
+// WithContext returns a shallow copy of the Kvs whose operations pass ctx to
+// the Kvdb's Hook
+//
+// Cursors created through the copy inherit ctx. The copy shares the underlying
+// handle with the original, so only the original should be closed.
+func (k *Kvs) WithContext(ctx context.Context) *Kvs {
+	k2 := *k
+	k2.ctx = ctx
+
+	return &k2
+}
+
+// WithContext returns a shallow copy of the Transaction whose operations pass
+// ctx to the Kvdb's Hook
+//
+// Cursors created through the copy inherit ctx. The copy shares the underlying
+// handle with the original, so only the original should be freed.
+func (t *Transaction) WithContext(ctx context.Context) *Transaction {
+	t2 := *t
+	t2.ctx = ctx
+
+	return &t2
+}
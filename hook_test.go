@@ -0,0 +1,228 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+const (
+	hookTestKvsName = "hook-test"
+)
+
+type hookTestKey struct{}
+
+type recordingHook struct {
+	mu   sync.Mutex
+	ops  []Op
+	ctxs []interface{}
+}
+
+func (h *recordingHook) Start(ctx context.Context, op *Op) context.Context {
+	return context.WithValue(ctx, hookTestKey{}, op.Name)
+}
+
+func (h *recordingHook) End(ctx context.Context, op *Op) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.ops = append(h.ops, *op)
+	h.ctxs = append(h.ctxs, ctx.Value(hookTestKey{}))
+}
+
+func (h *recordingHook) find(name string, txn bool) (Op, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, op := range h.ops {
+		if op.Name == name && op.Txn == txn {
+			return op, true
+		}
+	}
+
+	return Op{}, false
+}
+
+func TestHook(t *testing.T) {
+	kvs := makeAndOpenKvs(hookTestKvsName, txnParams)
+	defer kvdb.KvsDrop(hookTestKvsName)
+	defer kvs.Close()
+
+	hook := &recordingHook{}
+	kvdb.SetHook(hook)
+	defer kvdb.SetHook(nil)
+
+	err := kvdb.Transact(func(txn *Transaction) error {
+		return txn.Put(kvs, []byte("key"), []byte("value"), 0)
+	})
+	if err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if _, _, err = kvs.Get([]byte("key"), 0); err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	err = kvdb.Transact(func(txn *Transaction) error {
+		cursor, err := txn.CreateCursor(kvs, nil, 0)
+		if err != nil {
+			return err
+		}
+		defer cursor.Destroy()
+
+		if _, _, err = cursor.Read(0); err != nil {
+			return err
+		}
+
+		return txn.Delete(kvs, []byte("key"), 0)
+	})
+	if err != nil {
+		t.Fatalf("failed to run transaction: %s", err)
+	}
+
+	cursor, err := kvs.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	if _, _, err = cursor.Read(0); err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	cursor.Destroy()
+
+	tests := []struct {
+		name     string
+		txn      bool
+		keyLen   int
+		valueLen int
+	}{
+		{"txn_begin", true, 0, 0},
+		{"kvs_put", true, 3, 5},
+		{"kvs_get", false, 3, 5},
+		{"kvs_delete", true, 3, 0},
+		{"txn_commit", true, 0, 0},
+		{"cursor_create", false, 0, 0},
+		{"cursor_read", false, 0, 0},
+		{"cursor_destroy", false, 0, 0},
+		{"cursor_create", true, 0, 0},
+		{"cursor_read", true, 3, 5},
+		{"cursor_destroy", true, 0, 0},
+	}
+
+	for _, test := range tests {
+		op, ok := hook.find(test.name, test.txn)
+		if !ok {
+			t.Errorf("%s was not reported", test.name)
+			continue
+		}
+		if test.name != "txn_begin" && test.name != "txn_commit" && op.Kvs != hookTestKvsName {
+			t.Errorf("%s: kvs %q, expected %q", test.name, op.Kvs, hookTestKvsName)
+		}
+		if op.KeyLen != test.keyLen || op.ValueLen != test.valueLen {
+			t.Errorf("%s: lengths %d/%d, expected %d/%d", test.name, op.KeyLen, op.ValueLen, test.keyLen, test.valueLen)
+		}
+		if op.Err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, op.Err)
+		}
+	}
+
+	for i, op := range hook.ops {
+		if hook.ctxs[i] != op.Name {
+			t.Errorf("%s ended with the context of %v", op.Name, hook.ctxs[i])
+		}
+	}
+}
+
+type contextHook struct {
+	seen []interface{}
+}
+
+func (h *contextHook) Start(ctx context.Context, op *Op) context.Context {
+	h.seen = append(h.seen, ctx.Value(hookTestKey{}))
+	return ctx
+}
+
+func (h *contextHook) End(ctx context.Context, op *Op) {}
+
+func TestHookContext(t *testing.T) {
+	kvs := makeAndOpenKvs(hookTestKvsName, params{})
+	defer kvdb.KvsDrop(hookTestKvsName)
+	defer kvs.Close()
+
+	hook := &contextHook{}
+	kvdb.SetHook(hook)
+	defer kvdb.SetHook(nil)
+
+	ctx := context.WithValue(context.Background(), hookTestKey{}, "span")
+
+	if err := kvs.WithContext(ctx).Put([]byte("key"), []byte("value"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	cursor, err := kvs.WithContext(ctx).CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	cursor.Read(0)
+	cursor.Destroy()
+
+	if err = kvs.Put([]byte("key"), []byte("value"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	expected := []interface{}{"span", "span", "span", "span", nil}
+	if len(hook.seen) != len(expected) {
+		t.Fatalf("saw %d operations, expected %d", len(hook.seen), len(expected))
+	}
+	for i := range expected {
+		if hook.seen[i] != expected[i] {
+			t.Errorf("operation %d saw context value %v, expected %v", i, hook.seen[i], expected[i])
+		}
+	}
+}
+
+func TestAddHook(t *testing.T) {
+	kvs := makeAndOpenKvs(hookTestKvsName, params{})
+	defer kvdb.KvsDrop(hookTestKvsName)
+	defer kvs.Close()
+
+	first := &recordingHook{}
+	second := &recordingHook{}
+	kvdb.AddHook(first)
+	kvdb.AddHook(second)
+	defer kvdb.SetHook(nil)
+
+	if err := kvs.Put([]byte("key"), []byte("value"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if _, ok := first.find("kvs_put", false); !ok {
+		t.Fatalf("first hook did not see the put")
+	}
+	if _, ok := second.find("kvs_put", false); !ok {
+		t.Fatalf("second hook did not see the put")
+	}
+
+	kvdb.RemoveHook(first)
+
+	if _, _, err := kvs.Get([]byte("key"), 0); err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+	if _, ok := first.find("kvs_get", false); ok {
+		t.Fatalf("removed hook saw the get")
+	}
+	if _, ok := second.find("kvs_get", false); !ok {
+		t.Fatalf("remaining hook did not see the get")
+	}
+
+	kvdb.RemoveHook(second)
+
+	if err := kvs.Delete([]byte("key"), 0); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+	if _, ok := second.find("kvs_delete", false); ok {
+		t.Fatalf("removed hook saw the delete")
+	}
+}
@@ -83,6 +83,32 @@ func hseErrToErrno(err C.ulong) error {
 	return syscall.Errno(C.hse_err_to_errno(err))
 }
 
+// paramGet gets the value of a parameter with get, which is one of the HSE
+// param_get functions
+//
+// The first call to get sizes the buffer the second call fills.
+func paramGet(param string, get func(param *C.char, buf *C.char, bufSz C.size_t, neededSz *C.size_t) C.hse_err_t) (string, error) {
+	var neededSz C.size_t
+
+	paramC := C.CString(param)
+	defer C.free(unsafe.Pointer(paramC))
+
+	err := get(paramC, nil, 0, &neededSz)
+	if err != 0 {
+		return "", hseErrToErrno(C.ulong(err))
+	}
+
+	buf := (*C.char)(C.malloc(neededSz + 1))
+	defer C.free(unsafe.Pointer(buf))
+
+	err = get(paramC, buf, neededSz+1, nil)
+	if err != 0 {
+		return "", hseErrToErrno(C.ulong(err))
+	}
+
+	return C.GoString(buf), nil
+}
+
 // Init initializes the HSE KVDB subsystem
 //
 // This function initializes a range of different internal HSE structures. It
@@ -93,15 +119,18 @@ func Init(params ...string) error {
 	defer cparams.free()
 
 	err := C.hse_init(nil, cparams.Len(), cparams.Ptr())
+	if err != 0 {
+		return hseErrToErrno(err)
+	}
 
-	return hseErrToErrno(err)
+	return nil
 }
 
-// Init initializes the HSE KVDB subsystem
+// InitWithConfig initializes the HSE KVDB subsystem with a global
+// configuration file
 //
-// This function initializes a range of different internal HSE structures. It
-// must be called before any other HSE functions are used. It is not thread safe
-// and is idempotent.
+// config is the path of a JSON file in the format of GlobalConfig. See Init()
+// and InitWithGlobalConfig().
 func InitWithConfig(config string, params []string) error {
 	configC := C.CString(config)
 	defer C.free(unsafe.Pointer(configC))
@@ -110,8 +139,21 @@ func InitWithConfig(config string, params []string) error {
 	defer cparams.free()
 
 	err := C.hse_init(configC, cparams.Len(), cparams.Ptr())
+	if err != 0 {
+		return hseErrToErrno(err)
+	}
+
+	return nil
+}
 
-	return hseErrToErrno(err)
+// ParamGet gets the value of a global parameter
+//
+// The value is returned in its JSON representation, so string parameters are
+// quoted. This function is thread safe.
+func ParamGet(param string) (string, error) {
+	return paramGet(param, func(param *C.char, buf *C.char, bufSz C.size_t, neededSz *C.size_t) C.hse_err_t {
+		return C.hse_param_get(param, buf, bufSz, neededSz)
+	})
 }
 
 // Fini shuts down the HSE KVDB subsystem
@@ -0,0 +1,28 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Init is idempotent, so it can be called again while the tests run
+func TestInit(t *testing.T) {
+	if err := Init(); err != nil {
+		t.Fatalf("successful init returned an error: %v", err)
+	}
+
+	config := filepath.Join(t.TempDir(), "hse.conf")
+	if err := os.WriteFile(config, []byte("{}"), 0644); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+
+	if err := InitWithConfig(config, nil); err != nil {
+		t.Fatalf("successful init with config returned an error: %v", err)
+	}
+}
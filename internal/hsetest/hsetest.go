@@ -0,0 +1,53 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Package hsetest provides the KVDB fixture shared by the tests of the packages
+// built on the bindings
+package hsetest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	hse "github.com/hse-project/hse-go"
+)
+
+// NewKvdb initializes HSE, then creates and opens a KVDB holding the named KVSs
+// in a temporary directory
+//
+// The returned function closes the KVDB, finalizes HSE and removes the
+// directory. KVSs opened by the test must be closed before it is called.
+func NewKvdb(t testing.TB, kvsNames ...string) (*hse.Kvdb, func()) {
+	t.Helper()
+
+	home, err := ioutil.TempDir("", "hse-go-test")
+	if err != nil {
+		t.Fatalf("failed to create kvdb directory: %s", err)
+	}
+
+	if err = hse.Init(); err != nil {
+		t.Fatalf("failed to initialize hse: %s", err)
+	}
+	if err = hse.KvdbCreate(home); err != nil {
+		t.Fatalf("failed to create kvdb: %s", err)
+	}
+
+	kvdb, err := hse.KvdbOpen(home, nil)
+	if err != nil {
+		t.Fatalf("failed to open kvdb: %s", err)
+	}
+	for _, name := range kvsNames {
+		if err = kvdb.KvsCreate(name); err != nil {
+			t.Fatalf("failed to create kvs: %s", err)
+		}
+	}
+
+	return kvdb, func() {
+		kvdb.Close()
+		hse.Fini()
+		os.RemoveAll(home)
+	}
+}
@@ -0,0 +1,205 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+/*
+#include <string.h>
+
+#include <hse/hse.h>
+
+// Pairs are copied back to back into buf, each key followed by its value. A
+// pair which does not fit in what is left of buf is returned in spill, still
+// pointing into the cursor, and ends the batch.
+static hse_err_t
+hse_go_kvs_cursor_read_batch(
+	struct hse_kvs_cursor *cursor,
+	unsigned int flags,
+	size_t n,
+	char *buf,
+	size_t buf_len,
+	size_t *key_lens,
+	size_t *value_lens,
+	size_t *count,
+	bool *eof,
+	const void **spill_key,
+	size_t *spill_key_len,
+	const void **spill_value,
+	size_t *spill_value_len)
+{
+	size_t off = 0;
+
+	*count = 0;
+	*eof = false;
+	*spill_key = NULL;
+	*spill_value = NULL;
+
+	while (*count < n) {
+		const void *key, *value;
+		size_t key_len, value_len;
+		hse_err_t err;
+
+		err = hse_kvs_cursor_read(cursor, flags, &key, &key_len, &value, &value_len, eof);
+		if (err)
+			return err;
+		if (*eof)
+			break;
+
+		if (key_len + value_len > buf_len - off) {
+			*spill_key = key;
+			*spill_key_len = key_len;
+			*spill_value = value;
+			*spill_value_len = value_len;
+			break;
+		}
+
+		memcpy(buf + off, key, key_len);
+		off += key_len;
+		if (value_len > 0)
+			memcpy(buf + off, value, value_len);
+		off += value_len;
+
+		key_lens[*count] = key_len;
+		value_lens[*count] = value_len;
+		(*count)++;
+	}
+
+	return 0;
+}
+*/
+import "C"
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// CURSOR_BATCH_LEN is the number of KV pairs Cursor.ForEach() reads per
+	// call into HSE
+	CURSOR_BATCH_LEN = 256
+	// CURSOR_BATCH_BUF_LEN is the size of the buffer Cursor.ForEach() reads
+	// KV pairs into
+	CURSOR_BATCH_BUF_LEN = 256 * 1024
+)
+
+// ErrStopIteration can be returned by the function passed to Cursor.ForEach()
+// to stop the iteration without an error
+var ErrStopIteration = errors.New("hse: stop iteration")
+
+var cursorBatchBufs = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, CURSOR_BATCH_BUF_LEN)
+		return &buf
+	},
+}
+
+// ReadBatch reads up to n KV pairs from the cursor with a single call into HSE
+//
+// The flags are passed to every read, as with Read(). The pairs are returned
+// in cursor order, with their keys and values copied into buf, so they remain
+// valid until buf is reused. The batch ends early at the first pair which does
+// not fit in what is left of buf; that pair is copied into memory of its own
+// and is the last one returned, so a batch always makes progress even if buf
+// is nil. Fewer than n pairs are returned once the cursor reaches EOF, after
+// which Eof() returns true. As with Read(), a full batch can be followed by an
+// empty one at EOF. If an error occurs, the pairs read before it are returned
+// along with it.
+func (c *Cursor) ReadBatch(n int, buf []byte, flags CursorReadFlags) ([]KeyValue, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	keyLens := make([]C.size_t, n)
+	valueLens := make([]C.size_t, n)
+
+	var count C.size_t
+	var eof C.bool
+	var spillKey unsafe.Pointer
+	var spillKeyLen C.size_t
+	var spillValue unsafe.Pointer
+	var spillValueLen C.size_t
+
+	t := c.startOp("cursor_read_batch", 0)
+
+	rc := C.hse_go_kvs_cursor_read_batch(c.impl, C.uint(flags), C.size_t(n), bytesPtr(buf), C.size_t(len(buf)),
+		&keyLens[0], &valueLens[0], &count, &eof, &spillKey, &spillKeyLen, &spillValue, &spillValueLen)
+
+	pairs := make([]KeyValue, 0, int(count)+1)
+	off, keysLen := 0, 0
+	for i := 0; i < int(count); i++ {
+		keyLen, valueLen := int(keyLens[i]), int(valueLens[i])
+
+		key := buf[off : off+keyLen : off+keyLen]
+		off += keyLen
+		value := buf[off : off+valueLen : off+valueLen]
+		off += valueLen
+
+		pairs = append(pairs, KeyValue{Key: key, Value: value})
+		keysLen += keyLen
+	}
+	valuesLen := off - keysLen
+
+	if spillKey != nil {
+		key := C.GoBytes(spillKey, C.int(spillKeyLen))
+		value := []byte{}
+		if spillValue != nil {
+			value = C.GoBytes(spillValue, C.int(spillValueLen))
+		}
+
+		pairs = append(pairs, KeyValue{Key: key, Value: value})
+		keysLen += len(key)
+		valuesLen += len(value)
+	}
+
+	if rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(keysLen, valuesLen, err)
+		return pairs, err
+	}
+
+	c.eof = bool(eof)
+
+	t.done(keysLen, valuesLen, nil)
+
+	return pairs, nil
+}
+
+// ForEach calls fn with every remaining KV pair of the cursor in order
+//
+// Pairs are read CURSOR_BATCH_LEN at a time with ReadBatch(), and key and value
+// are only valid until fn returns. Iteration stops at EOF or at the first error
+// returned by fn, which ForEach() returns unless it is ErrStopIteration. Since
+// pairs are read ahead, the cursor is left past the pair fn stopped at.
+func (c *Cursor) ForEach(fn func(key []byte, value []byte) error) error {
+	return forEach(c, fn)
+}
+
+// batchReader is a cursor which reads KV pairs in batches
+type batchReader interface {
+	ReadBatch(n int, buf []byte, flags CursorReadFlags) ([]KeyValue, error)
+	Eof() bool
+}
+
+// forEach implements ForEach() for Cursor and the cursors wrapping it
+func forEach(c batchReader, fn func(key []byte, value []byte) error) error {
+	bufp := cursorBatchBufs.Get().(*[]byte)
+	defer cursorBatchBufs.Put(bufp)
+
+	for {
+		pairs, err := c.ReadBatch(CURSOR_BATCH_LEN, *bufp, 0)
+		for _, pair := range pairs {
+			if err := fn(pair.Key, pair.Value); err != nil {
+				if err == ErrStopIteration {
+					return nil
+				}
+				return err
+			}
+		}
+		if err != nil || c.Eof() {
+			return err
+		}
+	}
+}
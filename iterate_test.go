@@ -0,0 +1,207 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	iterateTestKvsName = "iterate-test"
+	iterateCount       = 100
+)
+
+func makeIterateKvs(t testing.TB) *Kvs {
+	kvs := makeAndOpenKvs(iterateTestKvsName, params{})
+
+	pairs := make([]KeyValue, iterateCount)
+	for i := range pairs {
+		pairs[i] = KeyValue{
+			Key:   []byte(fmt.Sprintf("key%03d", i)),
+			Value: []byte(fmt.Sprintf("value%d", i)),
+		}
+	}
+	if errs := kvs.MultiPut(pairs, 0); errs != nil {
+		t.Fatalf("failed to put: %v", errs)
+	}
+
+	return kvs
+}
+
+func TestReadBatch(t *testing.T) {
+	kvs := makeIterateKvs(t)
+	defer kvdb.KvsDrop(iterateTestKvsName)
+	defer kvs.Close()
+
+	for _, size := range []int{0, 10, 4096} {
+		c, err := kvs.CreateCursor(nil, 0)
+		if err != nil {
+			t.Fatalf("failed to create cursor: %s", err)
+		}
+
+		var read []KeyValue
+		buf := make([]byte, size)
+		for !c.Eof() {
+			pairs, err := c.ReadBatch(32, buf, 0)
+			if err != nil {
+				t.Fatalf("failed to read batch: %s", err)
+			}
+			if len(pairs) > 32 {
+				t.Fatalf("read %d pairs in a batch of 32", len(pairs))
+			}
+			if len(pairs) < 32 && !c.Eof() && size >= 4096 {
+				t.Fatalf("short batch of %d pairs before EOF", len(pairs))
+			}
+
+			// Pairs in buf are overwritten by the next batch
+			for _, pair := range pairs {
+				read = append(read, KeyValue{
+					Key:   append([]byte(nil), pair.Key...),
+					Value: append([]byte(nil), pair.Value...),
+				})
+			}
+		}
+		c.Destroy()
+
+		if len(read) != iterateCount {
+			t.Fatalf("expected %d pairs with a %d byte buffer, read %d", iterateCount, size, len(read))
+		}
+		for i, pair := range read {
+			if string(pair.Key) != fmt.Sprintf("key%03d", i) || string(pair.Value) != fmt.Sprintf("value%d", i) {
+				t.Fatalf("unexpected pair (%s, %s) at %d", pair.Key, pair.Value, i)
+			}
+		}
+	}
+
+	c, err := kvs.CreateCursor(nil, CURSOR_CREATE_REV)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c.Destroy()
+
+	pairs, err := c.ReadBatch(3, make([]byte, 4096), 0)
+	if err != nil || len(pairs) != 3 {
+		t.Fatalf("failed to read batch from reverse cursor: %d, %v", len(pairs), err)
+	}
+	if !bytes.Equal(pairs[0].Key, []byte("key099")) || !bytes.Equal(pairs[2].Key, []byte("key097")) {
+		t.Fatalf("unexpected keys %s, %s from reverse cursor", pairs[0].Key, pairs[2].Key)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	kvs := makeIterateKvs(t)
+	defer kvdb.KvsDrop(iterateTestKvsName)
+	defer kvs.Close()
+
+	c, err := kvs.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c.Destroy()
+
+	if _, err = c.Seek([]byte("key050"), 0); err != nil {
+		t.Fatalf("failed to seek: %s", err)
+	}
+
+	i := 50
+	err = c.ForEach(func(key []byte, value []byte) error {
+		if string(key) != fmt.Sprintf("key%03d", i) {
+			return fmt.Errorf("unexpected key %s at %d", key, i)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != iterateCount || !c.Eof() {
+		t.Fatalf("expected to stop at EOF after %d pairs, stopped at %d", iterateCount, i)
+	}
+
+	errStop := errors.New("stop")
+
+	c2, err := kvs.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c2.Destroy()
+
+	n := 0
+	err = c2.ForEach(func(key []byte, value []byte) error {
+		if n++; n == 10 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || n != 10 {
+		t.Fatalf("expected iteration to stop after 10 pairs, got %d, %v", n, err)
+	}
+
+	if _, err = c2.Seek(nil, 0); err != nil {
+		t.Fatalf("failed to seek: %s", err)
+	}
+
+	n = 0
+	err = c2.ForEach(func(key []byte, value []byte) error {
+		if n++; n == 10 {
+			return ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil || n != 10 {
+		t.Fatalf("expected iteration to stop after 10 pairs, got %d, %v", n, err)
+	}
+}
+
+func BenchmarkCursorRead(b *testing.B) {
+	kvs := makeIterateKvs(b)
+	defer kvdb.KvsDrop(iterateTestKvsName)
+	defer kvs.Close()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c, err := kvs.CreateCursor(nil, 0)
+		if err != nil {
+			b.Fatalf("failed to create cursor: %s", err)
+		}
+
+		for {
+			if _, _, err = c.Read(0); err != nil {
+				b.Fatalf("failed to read: %s", err)
+			}
+			if c.Eof() {
+				break
+			}
+		}
+
+		c.Destroy()
+	}
+}
+
+func BenchmarkCursorForEach(b *testing.B) {
+	kvs := makeIterateKvs(b)
+	defer kvdb.KvsDrop(iterateTestKvsName)
+	defer kvs.Close()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c, err := kvs.CreateCursor(nil, 0)
+		if err != nil {
+			b.Fatalf("failed to create cursor: %s", err)
+		}
+
+		if err = c.ForEach(func(key []byte, value []byte) error { return nil }); err != nil {
+			b.Fatalf("failed to iterate: %s", err)
+		}
+
+		c.Destroy()
+	}
+}
@@ -9,6 +9,8 @@ package hse
 // #include <hse/experimental.h>
 import "C"
 import (
+	"context"
+	"sync"
 	"unsafe"
 
 	"github.com/hse-project/hse-go/limits"
@@ -25,9 +27,32 @@ const (
 	KVDB_COMPACT_FULL     KvdbCompactFlag = C.HSE_KVDB_COMPACT_FULL
 )
 
+// Mclass is a media class which a Kvdb stores data on
+type Mclass int
+
+const (
+	// MCLASS_CAPACITY is the capacity media class
+	MCLASS_CAPACITY Mclass = C.HSE_MCLASS_CAPACITY
+	// MCLASS_STAGING is the staging media class
+	MCLASS_STAGING Mclass = C.HSE_MCLASS_STAGING
+	// MCLASS_PMEM is the persistent memory media class
+	MCLASS_PMEM Mclass = C.HSE_MCLASS_PMEM
+)
+
+// MCLASS_COUNT is the number of media classes
+const MCLASS_COUNT = C.HSE_MCLASS_COUNT
+
 // Kvdb is a key-value database which is comprised of one or many Kvs
 type Kvdb struct {
 	impl *C.struct_hse_kvdb
+
+	// kvsMu protects kvs, the Kvs handles opened through this Kvdb by name
+	kvsMu sync.Mutex
+	kvs   map[string]*Kvs
+
+	hook Hook
+	feed changeFeed
+	cdc  *cdcLog
 }
 
 // KvdbCompactStatus is the current state of a compaction
@@ -44,6 +69,21 @@ type KvdbCompactStatus struct {
 	Canceled bool
 }
 
+// MclassInfo is the storage usage of a media class
+type MclassInfo struct {
+	// AllocatedBytes is the number of bytes allocated
+	AllocatedBytes uint64
+	// UsedBytes is the number of bytes used
+	UsedBytes uint64
+	// Path is the path to the media class storage
+	Path string
+}
+
+// String returns the name of the media class
+func (m Mclass) String() string {
+	return C.GoString(C.hse_mclass_name_get(C.enum_hse_mclass(m)))
+}
+
 // KvdbCreate creates a new Kvdb instance within the named mpool
 //
 // The mpool must already exist and the client must have permission to use the
@@ -63,6 +103,22 @@ func KvdbCreate(home string, params ...string) error {
 	return nil
 }
 
+// KvdbDrop removes a Kvdb
+//
+// It is an error to call this function on a Kvdb that is open. This function is
+// not thread safe.
+func KvdbDrop(home string) error {
+	homeC := C.CString(home)
+	defer C.free(unsafe.Pointer(homeC))
+
+	err := C.hse_kvdb_drop(homeC)
+	if err != 0 {
+		return hseErrToErrno(err)
+	}
+
+	return nil
+}
+
 // KvdbOpen opens a Kvdb for use by the application
 //
 // The KVDB must already exist and the client must have permission to use it.
@@ -93,16 +149,65 @@ func (k *Kvdb) Close() error {
 		return nil
 	}
 
-	err := C.hse_kvdb_close(k.impl)
-	if err != 0 {
-		return hseErrToErrno(err)
+	if err := k.DisableCdc(); err != nil {
+		return err
+	}
+
+	t := k.startOp(nil, "kvdb_close", "", false, 0, 0)
+
+	if rc := C.hse_kvdb_close(k.impl); rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(0, 0, err)
+		return err
 	}
 
 	k.impl = nil
+	k.feed.closeAll()
+
+	t.done(0, 0, nil)
 
 	return nil
 }
 
+// Home returns the home directory of the Kvdb
+func (k *Kvdb) Home() string {
+	return C.GoString(C.hse_kvdb_home_get(k.impl))
+}
+
+// ParamGet gets the value of a Kvdb parameter
+//
+// The value is returned in its JSON representation, so string parameters are
+// quoted. This function is thread safe.
+func (k *Kvdb) ParamGet(param string) (string, error) {
+	return paramGet(param, func(param *C.char, buf *C.char, bufSz C.size_t, neededSz *C.size_t) C.hse_err_t {
+		return C.hse_kvdb_param_get(k.impl, param, buf, bufSz, neededSz)
+	})
+}
+
+// MclassIsConfigured returns whether the media class is configured for the
+// Kvdb
+func (k *Kvdb) MclassIsConfigured(mclass Mclass) bool {
+	return bool(C.hse_kvdb_mclass_is_configured(k.impl, C.enum_hse_mclass(mclass)))
+}
+
+// MclassInfo gets the storage usage of a media class
+//
+// This function is thread safe.
+func (k *Kvdb) MclassInfo(mclass Mclass) (MclassInfo, error) {
+	var info C.struct_hse_mclass_info
+
+	err := C.hse_kvdb_mclass_info_get(k.impl, C.enum_hse_mclass(mclass), &info)
+	if err != 0 {
+		return MclassInfo{}, hseErrToErrno(err)
+	}
+
+	return MclassInfo{
+		AllocatedBytes: uint64(info.mi_allocated_bytes),
+		UsedBytes:      uint64(info.mi_used_bytes),
+		Path:           C.GoString(&info.mi_path[0]),
+	}, nil
+}
+
 // KvsCreate creates a new Kvs within the referenced Kvdb
 //
 // If the KVS will store multi-segment keys then the parameter "pfx_len" should
@@ -117,12 +222,16 @@ func (k *Kvdb) KvsCreate(kvsName string, params ...string) error {
 	cparams := newCParams(params)
 	defer cparams.free()
 
-	err := C.hse_kvdb_kvs_create(k.impl, kvsNameC, cparams.Len(), cparams.Ptr())
-	if err != 0 {
-		return hseErrToErrno(err)
+	t := k.startOp(nil, "kvs_create", kvsName, false, 0, 0)
+
+	var err error
+	if rc := C.hse_kvdb_kvs_create(k.impl, kvsNameC, cparams.Len(), cparams.Ptr()); rc != 0 {
+		err = hseErrToErrno(rc)
 	}
 
-	return nil
+	t.done(0, 0, err)
+
+	return err
 }
 
 // KvsDrop removes a Kvs from the referenced Kvdb
@@ -133,12 +242,16 @@ func (k *Kvdb) KvsDrop(kvsName string) error {
 	kvsNameC := C.CString(kvsName)
 	defer C.free(unsafe.Pointer(kvsNameC))
 
-	err := C.hse_kvdb_kvs_drop(k.impl, kvsNameC)
-	if err != 0 {
-		return hseErrToErrno(err)
+	t := k.startOp(nil, "kvs_drop", kvsName, false, 0, 0)
+
+	var err error
+	if rc := C.hse_kvdb_kvs_drop(k.impl, kvsNameC); rc != 0 {
+		err = hseErrToErrno(rc)
 	}
 
-	return nil
+	t.done(0, 0, err)
+
+	return err
 }
 
 // KvsOpen opens a Kvs in a Kvdb
@@ -151,26 +264,65 @@ func (k *Kvdb) KvsOpen(kvsName string, params ...string) (*Kvs, error) {
 	cparams := newCParams(params)
 	defer cparams.free()
 
-	var kvs Kvs
+	kvs := Kvs{kvdb: k, name: kvsName}
 
-	err := C.hse_kvdb_kvs_open(k.impl, kvsNameC, cparams.Len(), cparams.Ptr(), &kvs.impl)
-	if err != 0 {
-		return nil, hseErrToErrno(err)
+	t := k.startOp(nil, "kvs_open", kvsName, false, 0, 0)
+
+	if rc := C.hse_kvdb_kvs_open(k.impl, kvsNameC, cparams.Len(), cparams.Ptr(), &kvs.impl); rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(0, 0, err)
+		return nil, err
+	}
+
+	t.done(0, 0, nil)
+
+	k.kvsMu.Lock()
+	if k.kvs == nil {
+		k.kvs = make(map[string]*Kvs)
 	}
+	k.kvs[kvsName] = &kvs
+	k.kvsMu.Unlock()
 
 	return &kvs, nil
 }
 
+// KvsHandle returns the handle of the named Kvs if it was opened with
+// KvsOpen() and is not closed, or nil
+//
+// Since a Kvs cannot be opened twice, code which shares a Kvdb with other code
+// uses the handle returned by KvsHandle(), if any, rather than opening the Kvs
+// again, and leaves it to its owner to close. This function is thread safe.
+func (k *Kvdb) KvsHandle(kvsName string) *Kvs {
+	k.kvsMu.Lock()
+	defer k.kvsMu.Unlock()
+
+	return k.kvs[kvsName]
+}
+
+func (k *Kvdb) forgetKvs(kvs *Kvs) {
+	k.kvsMu.Lock()
+	defer k.kvsMu.Unlock()
+
+	if k.kvs[kvs.name] == kvs {
+		delete(k.kvs, kvs.name)
+	}
+}
+
 // Names returns the Kvs names within a Kvdb
 func (k *Kvdb) KvsNames() ([]string, error) {
 	var namesc C.size_t
 	var namesv **C.char
 
-	err := C.hse_kvdb_kvs_names_get(k.impl, &namesc, &namesv)
-	if err != 0 {
-		return nil, hseErrToErrno(err)
+	t := k.startOp(nil, "kvs_names", "", false, 0, 0)
+
+	if rc := C.hse_kvdb_kvs_names_get(k.impl, &namesc, &namesv); rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(0, 0, err)
+		return nil, err
 	}
 
+	t.done(0, 0, nil)
+
 	names := make([]string, namesc)
 	for i, s := range (*[limits.KVS_COUNT_MAX]*C.char)(unsafe.Pointer(namesv))[:namesc:namesc] {
 		names[i] = C.GoString(s)
@@ -192,20 +344,25 @@ func (k *Kvdb) NewTransaction() *Transaction {
 	}
 
 	return &Transaction{
-		impl: txn,
-		kvdb: k,
+		impl:    txn,
+		kvdb:    k,
+		pending: &pendingChanges{},
 	}
 }
 
 // Sync flushes data in all of the referenced KVDB's KVSs to stable media and
 // returns
 func (k *Kvdb) Sync() error {
-	err := C.hse_kvdb_sync(k.impl, 0)
-	if err != 0 {
-		return hseErrToErrno(err)
+	t := k.startOp(nil, "kvdb_sync", "", false, 0, 0)
+
+	var err error
+	if rc := C.hse_kvdb_sync(k.impl, 0); rc != 0 {
+		err = hseErrToErrno(rc)
 	}
 
-	return nil
+	t.done(0, 0, err)
+
+	return err
 }
 
 // Compact requests a data compaction operation
@@ -224,12 +381,16 @@ func (k *Kvdb) Sync() error {
 //
 // See the function Kvdb.CompactStatus(). This function is thread safe.
 func (k *Kvdb) Compact(flags KvdbCompactFlag) error {
-	err := C.hse_kvdb_compact(k.impl, C.uint(flags))
-	if err != 0 {
-		return hseErrToErrno(err)
+	t := k.startOp(nil, "kvdb_compact", "", false, 0, 0)
+
+	var err error
+	if rc := C.hse_kvdb_compact(k.impl, C.uint(flags)); rc != 0 {
+		err = hseErrToErrno(rc)
 	}
 
-	return nil
+	t.done(0, 0, err)
+
+	return err
 }
 
 // CompactStatus gets the status of an ongoing compaction activity
@@ -238,13 +399,26 @@ func (k *Kvdb) Compact(flags KvdbCompactFlag) error {
 // determine the current state of maintenance compaction. This function is
 // thread safe.
 func (k *Kvdb) CompactStatus() (KvdbCompactStatus, error) {
+	return k.CompactStatusContext(context.Background())
+}
+
+// CompactStatusContext gets the status of an ongoing compaction activity,
+// passing ctx to the Kvdb's Hook
+//
+// See Kvdb.CompactStatus().
+func (k *Kvdb) CompactStatusContext(ctx context.Context) (KvdbCompactStatus, error) {
 	var compactStatus C.struct_hse_kvdb_compact_status
 
-	err := C.hse_kvdb_compact_status_get(k.impl, &compactStatus)
-	if err != 0 {
-		return KvdbCompactStatus{}, hseErrToErrno(err)
+	t := k.startOp(ctx, "kvdb_compact_status", "", false, 0, 0)
+
+	if rc := C.hse_kvdb_compact_status_get(k.impl, &compactStatus); rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(0, 0, err)
+		return KvdbCompactStatus{}, err
 	}
 
+	t.done(0, 0, nil)
+
 	return KvdbCompactStatus{
 		SampLwm:  uint(compactStatus.kvcs_samp_lwm),
 		SampHwm:  uint(compactStatus.kvcs_samp_hwm),
@@ -13,8 +13,9 @@ import (
 )
 
 const (
-	kvdbName        = "hse-go-test"
-	kvdbTestKvsName = "kvdb-test"
+	kvdbName             = "hse-go-test"
+	kvdbTestKvsName      = "kvdb-test"
+	kvsHandleTestKvsName = "kvs-handle-test"
 )
 
 var kvdb *Kvdb
@@ -25,6 +26,9 @@ type params struct {
 	Rparams []string
 }
 
+// txnParams open a KVS for writes within transactions
+var txnParams = params{Rparams: []string{"transactions.enabled=true"}}
+
 func (p *params) SetCparams(params ...string) {
 	p.Cparams = params
 }
@@ -138,3 +142,59 @@ func TestFlush(t *testing.T) {
 // 		t.Fatal("compaction not canceled")
 // 	}
 // }
+
+func TestHome(t *testing.T) {
+	if kvdb.Home() == "" {
+		t.Fatal("kvdb home is empty")
+	}
+}
+
+func TestMclassInfo(t *testing.T) {
+	if !kvdb.MclassIsConfigured(MCLASS_CAPACITY) {
+		t.Fatal("capacity media class is not configured")
+	}
+
+	info, err := kvdb.MclassInfo(MCLASS_CAPACITY)
+	if err != nil {
+		t.Fatalf("failed to get capacity media class info: %s", err)
+	}
+	if info.Path == "" {
+		t.Fatal("capacity media class path is empty")
+	}
+}
+
+func TestParamGet(t *testing.T) {
+	if _, err := kvdb.ParamGet("durability.enabled"); err != nil {
+		t.Fatalf("failed to get durability.enabled: %s", err)
+	}
+
+	pfxLen, err := kvdbTestKvs.ParamGet("prefix.length")
+	if err != nil {
+		t.Fatalf("failed to get prefix.length: %s", err)
+	}
+	if pfxLen != "3" {
+		t.Fatalf("unexpected prefix.length: %s", pfxLen)
+	}
+}
+
+func TestKvsHandle(t *testing.T) {
+	if kvs := kvdb.KvsHandle(kvdbTestKvsName); kvs != kvdbTestKvs {
+		t.Fatalf("KvsHandle() returned %p, expected the open handle %p", kvs, kvdbTestKvs)
+	}
+	if kvs := kvdb.KvsHandle("missing"); kvs != nil {
+		t.Fatalf("KvsHandle() returned a handle for a missing kvs")
+	}
+
+	kvs := makeAndOpenKvs(kvsHandleTestKvsName, params{})
+	defer kvdb.KvsDrop(kvsHandleTestKvsName)
+
+	if kvdb.KvsHandle(kvsHandleTestKvsName) != kvs {
+		t.Fatalf("KvsHandle() did not return the open handle")
+	}
+	if err := kvs.Close(); err != nil {
+		t.Fatalf("failed to close kvs: %s", err)
+	}
+	if kvdb.KvsHandle(kvsHandleTestKvsName) != nil {
+		t.Fatalf("KvsHandle() returned a closed handle")
+	}
+}
@@ -8,6 +8,7 @@ package hse
 // #include <hse/hse.h>
 import "C"
 import (
+	"context"
 	"unsafe"
 
 	"github.com/hse-project/hse-go/limits"
@@ -15,7 +16,11 @@ import (
 
 // Kvs is a logical grouping of k/v pairs within a Kvdb
 type Kvs struct {
-	impl *C.struct_hse_kvs
+	impl  *C.struct_hse_kvs
+	kvdb  *Kvdb
+	name  string
+	ctx   context.Context
+	merge MergeFunc
 }
 
 type DeleteFlags uint
@@ -37,16 +42,50 @@ func (k *Kvs) Close() error {
 		return nil
 	}
 
-	err := C.hse_kvdb_kvs_close(k.impl)
-	if err != 0 {
-		return hseErrToErrno(err)
+	t := k.startOp(nil, "kvs_close", 0, 0)
+
+	if rc := C.hse_kvdb_kvs_close(k.impl); rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(0, 0, err)
+		return err
 	}
 
+	k.kvdb.forgetKvs(k)
 	k.impl = nil
 
+	t.done(0, 0, nil)
+
 	return nil
 }
 
+// startOp reports the start of an operation on the Kvs to the Kvdb's Hook
+//
+// The context attached to the transaction takes precedence over the one
+// attached to the Kvs.
+func (k *Kvs) startOp(txn *Transaction, name string, keyLen int, valueLen int) *opTrace {
+	ctx := k.ctx
+	if txn != nil && txn.ctx != nil {
+		ctx = txn.ctx
+	}
+
+	return k.kvdb.startOp(ctx, name, k.name, txn != nil, keyLen, valueLen)
+}
+
+// Name returns the name the Kvs was opened with
+func (k *Kvs) Name() string {
+	return k.name
+}
+
+// ParamGet gets the value of a Kvs parameter
+//
+// The value is returned in its JSON representation, so string parameters are
+// quoted. This function is thread safe.
+func (k *Kvs) ParamGet(param string) (string, error) {
+	return paramGet(param, func(param *C.char, buf *C.char, bufSz C.size_t, neededSz *C.size_t) C.hse_err_t {
+		return C.hse_kvs_param_get(k.impl, param, buf, bufSz, neededSz)
+	})
+}
+
 // Put places a KV pair into a Kvs
 //
 // If the key already exists in the Kvs then the value is effectively overwritten. The
@@ -61,53 +100,89 @@ func (k *Kvs) Close() error {
 // Care should be taken when doing so to ensure that the system does not become overrun. As a rough
 // approximation, doing 1M priority puts per second marked as PRIORITY is likely an issue. On the
 // other hand, doing 1K small puts per second marked as PRIORITY is almost certainly fine.
+// The flags are passed through to HSE.
 func (k *Kvs) Put(key, value []byte, flags PutFlags) error {
+	return k.put(nil, key, value, flags)
+}
+
+func (k *Kvs) put(txn *Transaction, key, value []byte, flags PutFlags) error {
+	if txn == nil && k.kvdb.cdc.logs(k.name) {
+		return k.kvdb.Transact(func(txn *Transaction) error {
+			return k.put(txn, key, value, flags)
+		})
+	}
+
 	var keyPtr unsafe.Pointer
 	var valuePtr unsafe.Pointer
 
-	if key != nil {
+	if len(key) > 0 {
 		keyPtr = unsafe.Pointer(&key[0])
 	}
-	if value != nil {
+	if len(value) > 0 {
 		valuePtr = unsafe.Pointer(&value[0])
 	}
 
-	err := C.hse_kvs_put(k.impl, 0, nil, keyPtr, C.size_t(len(key)), valuePtr, C.size_t(len(value)))
-	if err != 0 {
-		return hseErrToErrno(err)
+	if k.kvdb.lockChanges(txn, k.name) {
+		defer k.kvdb.feed.order.Unlock()
 	}
 
-	return nil
+	t := k.startOp(txn, "kvs_put", len(key), len(value))
+
+	var err error
+	if rc := C.hse_kvs_put(k.impl, C.uint(flags), txn.cimpl(), keyPtr, C.size_t(len(key)), valuePtr, C.size_t(len(value))); rc != 0 {
+		err = hseErrToErrno(rc)
+	} else {
+		k.kvdb.recordChange(txn, k.name, CHANGE_PUT, key, value)
+	}
+
+	t.done(len(key), len(value), err)
+
+	return err
 }
 
 // Get retrieves the value for a given key from Kvs
 //
-// If the key exists in the Kvs then the referent of "found" is set to true. If the
-// caller's value buffer is large enough then the data will be returned. Regardless, the
-// actual length of the value is returned . See the section on transactions for
-// information on how gets within transactions are handled. This function is thread
-// safe.
+// If the key exists in the Kvs, its value and the length of the value are
+// returned. The value of a key stored with an empty value is a non-nil empty
+// slice. If the key does not exist, the value is nil and the length is 0. See the
+// section on transactions for information on how gets within transactions are
+// handled. This function is thread safe.
 func (k *Kvs) Get(key []byte, flags GetFlags) ([]byte, uint, error) {
+	return k.get(nil, key, flags)
+}
+
+func (k *Kvs) get(txn *Transaction, key []byte, flags GetFlags) ([]byte, uint, error) {
 	var buf []byte
 	var keyPtr unsafe.Pointer
 	var bufPtr unsafe.Pointer
 	var found C.bool
 	var valueLen C.size_t
 
-	if key != nil {
+	if len(key) > 0 {
 		keyPtr = unsafe.Pointer(&key[0])
 	}
 
 	buf = make([]byte, limits.KVS_VALUE_LEN_MAX)
-	if buf != nil {
+	if len(buf) > 0 {
 		bufPtr = unsafe.Pointer(&buf[0])
 	}
 
-	err := C.hse_kvs_get(k.impl, 0, nil, keyPtr, C.size_t(len(key)), &found, bufPtr, C.size_t(len(buf)), &valueLen)
-	if err != 0 {
-		return nil, uint(valueLen), hseErrToErrno(err)
+	t := k.startOp(txn, "kvs_get", len(key), 0)
+
+	rc := C.hse_kvs_get(k.impl, C.uint(flags), txn.cimpl(), keyPtr, C.size_t(len(key)), &found, bufPtr, C.size_t(len(buf)), &valueLen)
+	if rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(len(key), 0, err)
+		return nil, uint(valueLen), err
+	}
+
+	if !found {
+		t.done(len(key), 0, nil)
+		return nil, 0, nil
 	}
 
+	t.done(len(key), int(valueLen), nil)
+
 	if buf == nil {
 		return nil, uint(valueLen), nil
 	}
@@ -121,18 +196,38 @@ func (k *Kvs) Get(key []byte, flags GetFlags) ([]byte, uint, error) {
 // transactions for information on how deletes within transactions are handled. This
 // function is thread safe.
 func (k *Kvs) Delete(key []byte, flags DeleteFlags) error {
+	return k.delete(nil, key, flags)
+}
+
+func (k *Kvs) delete(txn *Transaction, key []byte, flags DeleteFlags) error {
+	if txn == nil && k.kvdb.cdc.logs(k.name) {
+		return k.kvdb.Transact(func(txn *Transaction) error {
+			return k.delete(txn, key, flags)
+		})
+	}
+
 	var keyPtr unsafe.Pointer
 
-	if key != nil {
+	if len(key) > 0 {
 		keyPtr = unsafe.Pointer(&key[0])
 	}
 
-	err := C.hse_kvs_delete(k.impl, C.uint(flags), nil, keyPtr, C.size_t(len(key)))
-	if err != 0 {
-		return hseErrToErrno(err)
+	if k.kvdb.lockChanges(txn, k.name) {
+		defer k.kvdb.feed.order.Unlock()
 	}
 
-	return nil
+	t := k.startOp(txn, "kvs_delete", len(key), 0)
+
+	var err error
+	if rc := C.hse_kvs_delete(k.impl, C.uint(flags), txn.cimpl(), keyPtr, C.size_t(len(key))); rc != 0 {
+		err = hseErrToErrno(rc)
+	} else {
+		k.kvdb.recordChange(txn, k.name, CHANGE_DELETE, key, nil)
+	}
+
+	t.done(len(key), 0, err)
+
+	return err
 }
 
 // PrefixDelete deletes all KV pairs matching the key prefix from a KVS storing multi-segment keys
@@ -149,18 +244,38 @@ func (k *Kvs) Delete(key []byte, flags DeleteFlags) error {
 // treated as though they were issued serially at the beginning of the transaction
 // regardless of the actual order these commands appeared in.
 func (k *Kvs) PrefixDelete(filt []byte, flags PrefixDeleteFlags) error {
+	return k.prefixDelete(nil, filt, flags)
+}
+
+func (k *Kvs) prefixDelete(txn *Transaction, filt []byte, flags PrefixDeleteFlags) error {
+	if txn == nil && k.kvdb.cdc.logs(k.name) {
+		return k.kvdb.Transact(func(txn *Transaction) error {
+			return k.prefixDelete(txn, filt, flags)
+		})
+	}
+
 	var filtPtr unsafe.Pointer
 
-	if filt != nil {
+	if len(filt) > 0 {
 		filtPtr = unsafe.Pointer(&filt[0])
 	}
 
-	err := C.hse_kvs_prefix_delete(k.impl, C.uint(flags), nil, filtPtr, C.size_t(len(filt)))
-	if err != 0 {
-		return hseErrToErrno(err)
+	if k.kvdb.lockChanges(txn, k.name) {
+		defer k.kvdb.feed.order.Unlock()
 	}
 
-	return nil
+	t := k.startOp(txn, "kvs_prefix_delete", len(filt), 0)
+
+	var err error
+	if rc := C.hse_kvs_prefix_delete(k.impl, C.uint(flags), txn.cimpl(), filtPtr, C.size_t(len(filt))); rc != 0 {
+		err = hseErrToErrno(rc)
+	} else {
+		k.kvdb.recordChange(txn, k.name, CHANGE_PREFIX_DELETE, filt, nil)
+	}
+
+	t.done(len(filt), 0, err)
+
+	return err
 }
 
 // NewCursor creates a cursor used to iterate over a KVS
@@ -216,17 +331,30 @@ func (k *Kvs) PrefixDelete(filt []byte, flags PrefixDeleteFlags) error {
 // the mutations of the transaction, if any. Note that this will make any other
 // mutations that occurred during the lifespan of the transaction visible as well.
 func (k *Kvs) CreateCursor(filt []byte, flags CursorCreateFlag) (*Cursor, error) {
-	var c Cursor
+	return k.createCursor(nil, filt, flags)
+}
+
+func (k *Kvs) createCursor(txn *Transaction, filt []byte, flags CursorCreateFlag) (*Cursor, error) {
 	var filtPtr unsafe.Pointer
 
-	if filt != nil {
+	c := Cursor{kvs: k, ctx: k.ctx, txn: txn != nil}
+	if txn != nil && txn.ctx != nil {
+		c.ctx = txn.ctx
+	}
+
+	if len(filt) > 0 {
 		filtPtr = unsafe.Pointer(&filt[0])
 	}
 
-	err := C.hse_kvs_cursor_create(k.impl, C.uint(flags), nil, filtPtr, C.size_t(len(filt)), &c.impl)
-	if err != 0 {
-		return nil, hseErrToErrno(err)
+	t := k.startOp(txn, "cursor_create", len(filt), 0)
+
+	if rc := C.hse_kvs_cursor_create(k.impl, C.uint(flags), txn.cimpl(), filtPtr, C.size_t(len(filt)), &c.impl); rc != 0 {
+		err := hseErrToErrno(rc)
+		t.done(len(filt), 0, err)
+		return nil, err
 	}
 
+	t.done(len(filt), 0, nil)
+
 	return &c, nil
 }
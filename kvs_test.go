@@ -57,6 +57,62 @@ func TestKvsKeyOperations(t *testing.T) {
 	}
 }
 
+func TestKvsGetMissing(t *testing.T) {
+	value, n, err := kvsTestKvs.Get([]byte("missing"), 0)
+	if err != nil {
+		t.Fatalf("failed to get missing key: %s", err)
+	}
+	if value != nil || n != 0 {
+		t.Fatalf("missing key returned (%q, %d)", value, n)
+	}
+
+	if err = kvsTestKvs.Put([]byte("empty"), nil, KVS_PUT_PRIO|KVS_PUT_VCOMP_OFF); err != nil {
+		t.Fatalf("failed to put with flags: %s", err)
+	}
+	defer kvsTestKvs.Delete([]byte("empty"), 0)
+
+	value, n, err = kvsTestKvs.Get([]byte("empty"), 0)
+	if err != nil {
+		t.Fatalf("failed to get empty value: %s", err)
+	}
+	if value == nil || len(value) != 0 || n != 0 {
+		t.Fatalf("empty value returned (%q, %d)", value, n)
+	}
+}
+
+func TestKvsEmptySlices(t *testing.T) {
+	// Empty slices which are not nil are passed to HSE like nil ones rather
+	// than indexed
+	if err := kvsTestKvs.Put([]byte{}, []byte("value"), 0); err == nil {
+		t.Fatalf("put with an empty key succeeded")
+	}
+	if err := kvsTestKvs.Put([]byte("empty"), []byte{}, 0); err != nil {
+		t.Fatalf("failed to put empty value: %s", err)
+	}
+	defer kvsTestKvs.Delete([]byte("empty"), 0)
+
+	if _, _, err := kvsTestKvs.Get([]byte{}, 0); err == nil {
+		t.Fatalf("get with an empty key succeeded")
+	}
+	if err := kvsTestKvs.Delete([]byte{}, 0); err == nil {
+		t.Fatalf("delete with an empty key succeeded")
+	}
+
+	cursor, err := kvsTestKvs.CreateCursor([]byte{}, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor with an empty filter: %s", err)
+	}
+	defer cursor.Destroy()
+
+	found, err := cursor.Seek([]byte{}, 0)
+	if err != nil {
+		t.Fatalf("failed to seek to an empty key: %s", err)
+	}
+	if string(found) != "empty" {
+		t.Fatalf("seek to an empty key found %q, expected \"empty\"", found)
+	}
+}
+
 func TestPrefixDelete(t *testing.T) {
 
 }
@@ -0,0 +1,331 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Package kvsfs stores a file system hierarchy in a KVS
+//
+// FS implements io/fs.FS, so the files work with http.FS, template.ParseFS,
+// fs.WalkDir and the rest of the standard library. WritableFS adds writes.
+//
+// Every file and directory has a metadata key named after its parent
+// directory and its own name, so that the entries of a directory share a
+// prefix and are listed with one cursor. The contents of a file are kept under
+// a separate key so that listing a directory does not read them.
+//
+//	metadata: 'm' parent 0x00 name -> version(u8) mode(u32) size(u64) mtime(i64)
+//	contents: 'd' path             -> data
+//
+// Paths are as accepted by fs.ValidPath(), and the root directory "." always
+// exists. A file is limited to limits.KVS_VALUE_LEN_MAX bytes.
+package kvsfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	hse "github.com/hse-project/hse-go"
+)
+
+const (
+	metaPfx     = 'm'
+	dataPfx     = 'd'
+	metaVersion = 1
+	metaLen     = 21
+)
+
+// ErrCorrupt is returned when the metadata of a file cannot be decoded
+var ErrCorrupt = errors.New("kvsfs: invalid file metadata")
+
+// metaKey returns the metadata key of a path, which must not be "."
+func metaKey(name string) []byte {
+	key := dirPrefix(path.Dir(name))
+
+	return append(key, path.Base(name)...)
+}
+
+// dirPrefix returns the prefix of the metadata keys of the entries of a
+// directory
+func dirPrefix(dir string) []byte {
+	key := make([]byte, 0, len(dir)+2)
+	key = append(key, metaPfx)
+	key = append(key, dir...)
+
+	return append(key, 0)
+}
+
+func dataKey(name string) []byte {
+	return append([]byte{dataPfx}, name...)
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry
+type fileInfo struct {
+	name    string
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+}
+
+var rootInfo = &fileInfo{name: ".", mode: fs.ModeDir | 0755}
+
+func (i *fileInfo) Name() string               { return i.name }
+func (i *fileInfo) Size() int64                { return i.size }
+func (i *fileInfo) Mode() fs.FileMode          { return i.mode }
+func (i *fileInfo) ModTime() time.Time         { return i.modTime }
+func (i *fileInfo) IsDir() bool                { return i.mode.IsDir() }
+func (i *fileInfo) Sys() interface{}           { return nil }
+func (i *fileInfo) Type() fs.FileMode          { return i.mode.Type() }
+func (i *fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+func encodeMeta(mode fs.FileMode, size int64, modTime time.Time) []byte {
+	buf := make([]byte, metaLen)
+
+	buf[0] = metaVersion
+	binary.BigEndian.PutUint32(buf[1:], uint32(mode))
+	binary.BigEndian.PutUint64(buf[5:], uint64(size))
+	binary.BigEndian.PutUint64(buf[13:], uint64(modTime.UnixNano()))
+
+	return buf
+}
+
+func decodeMeta(name string, buf []byte) (*fileInfo, error) {
+	if len(buf) != metaLen || buf[0] != metaVersion {
+		return nil, ErrCorrupt
+	}
+
+	return &fileInfo{
+		name:    name,
+		mode:    fs.FileMode(binary.BigEndian.Uint32(buf[1:])),
+		size:    int64(binary.BigEndian.Uint64(buf[5:])),
+		modTime: time.Unix(0, int64(binary.BigEndian.Uint64(buf[13:]))),
+	}, nil
+}
+
+// getter is a Kvs or a Kvs within a transaction
+type getter func(key []byte) ([]byte, error)
+
+func kvsGetter(kvs *hse.Kvs) getter {
+	return func(key []byte) ([]byte, error) {
+		value, _, err := kvs.Get(key, 0)
+		return value, err
+	}
+}
+
+// stat returns the metadata of a path, or nil if it does not exist
+func stat(get getter, name string) (*fileInfo, error) {
+	if name == "." {
+		return rootInfo, nil
+	}
+
+	value, err := get(metaKey(name))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	return decodeMeta(path.Base(name), value)
+}
+
+// FS is a read-only file system stored in a Kvs
+//
+// FS implements fs.StatFS, fs.ReadDirFS and fs.ReadFileFS. It is safe for
+// concurrent use.
+type FS struct {
+	kvs *hse.Kvs
+}
+
+// New creates an FS over the files stored in kvs
+func New(kvs *hse.Kvs) *FS {
+	return &FS{kvs: kvs}
+}
+
+// Open opens the named file or directory
+//
+// Files implement io.Seeker and io.ReaderAt, and directories implement
+// fs.ReadDirFile.
+func (f *FS) Open(name string) (fs.File, error) {
+	info, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &dir{fs: f, path: name, info: info}, nil
+	}
+
+	data, err := f.read("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The size is that of the contents read, should a write race with Open
+	info.size = int64(len(data))
+
+	return &file{info: info, r: bytes.NewReader(data)}, nil
+}
+
+// Stat returns the metadata of the named file or directory
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// ReadFile returns the contents of the named file
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	info, err := f.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errIsDir}
+	}
+
+	return f.read("readfile", name)
+}
+
+// ReadDir returns the entries of the named directory sorted by name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+
+	entries, err := readDir(f.kvs, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	return entries, nil
+}
+
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// lookup returns the metadata of a path, reporting errors as a fs.PathError
+func (f *FS) lookup(op string, name string) (*fileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	info, err := stat(kvsGetter(f.kvs), name)
+	if err == nil && info == nil {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	return info, nil
+}
+
+func (f *FS) read(op string, name string) ([]byte, error) {
+	data, _, err := f.kvs.Get(dataKey(name), 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if data == nil {
+		data = []byte{}
+	}
+
+	return data, nil
+}
+
+// readDir lists a directory with a cursor over the prefix of its entries
+func readDir(kvs *hse.Kvs, name string) ([]fs.DirEntry, error) {
+	prefix := dirPrefix(name)
+
+	c, err := kvs.CreateCursor(prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Destroy()
+
+	entries := []fs.DirEntry{}
+	err = c.ForEach(func(key []byte, value []byte) error {
+		info, err := decodeMeta(string(key[len(prefix):]), value)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, info)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// file is an open regular file
+type file struct {
+	info *fileInfo
+	r    *bytes.Reader
+}
+
+func (f *file) Stat() (fs.FileInfo, error)                   { return f.info, nil }
+func (f *file) Read(p []byte) (int, error)                   { return f.r.Read(p) }
+func (f *file) ReadAt(p []byte, off int64) (int, error)      { return f.r.ReadAt(p, off) }
+func (f *file) Seek(offset int64, whence int) (int64, error) { return f.r.Seek(offset, whence) }
+func (f *file) Close() error                                 { return nil }
+
+// dir is an open directory
+type dir struct {
+	fs      *FS
+	path    string
+	info    *fileInfo
+	entries []fs.DirEntry
+	loaded  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errIsDir}
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := readDir(d.fs.kvs, d.path)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.path, Err: err}
+		}
+
+		d.entries = entries
+		d.loaded = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
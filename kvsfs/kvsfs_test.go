@@ -0,0 +1,148 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package kvsfs
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"text/template"
+
+	"github.com/hse-project/hse-go/internal/hsetest"
+	"github.com/hse-project/hse-go/limits"
+)
+
+const (
+	kvsfsTestKvsName = "kvsfs-test"
+)
+
+func newTestFS(t *testing.T) (*WritableFS, func()) {
+	kvdb, cleanup := hsetest.NewKvdb(t, kvsfsTestKvsName)
+
+	kvs, err := kvdb.KvsOpen(kvsfsTestKvsName, "transactions.enabled=true")
+	if err != nil {
+		t.Fatalf("failed to open kvs: %s", err)
+	}
+
+	return NewWritable(kvdb, kvs), func() {
+		kvs.Close()
+		cleanup()
+	}
+}
+
+func TestFS(t *testing.T) {
+	w, cleanup := newTestFS(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"hello.txt":               "hello, {{.}}",
+		"dir/a.txt":               "a",
+		"dir/empty":               "",
+		"dir/sub/b.txt":           "b",
+		"dir/sub/deeper/c.tmpl":   "{{define \"c\"}}c{{end}}",
+		"dir2/with space/d.json":  "{}",
+		"dir/sub/deeper/e.txt.gz": "\x1f\x8b",
+	}
+	for name, data := range files {
+		if err := w.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+	}
+	if err := w.MkdirAll("empty/dir", 0700); err != nil {
+		t.Fatalf("failed to make directory: %s", err)
+	}
+
+	fsys := New(w.kvs)
+
+	expected := []string{"empty/dir"}
+	for name := range files {
+		expected = append(expected, name)
+	}
+	if err := fstest.TestFS(fsys, expected...); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := template.ParseFS(fsys, "hello.txt")
+	if err != nil {
+		t.Fatalf("failed to parse template: %s", err)
+	}
+	if tmpl.Name() != "hello.txt" {
+		t.Fatalf("unexpected template name %s", tmpl.Name())
+	}
+
+	info, err := fs.Stat(fsys, "dir/sub")
+	if err != nil || !info.IsDir() || info.Mode().Perm() != 0755 {
+		t.Fatalf("unexpected info %v for a created parent: %v", info, err)
+	}
+	if info, err = fs.Stat(fsys, "hello.txt"); err != nil || info.Size() != 12 || info.Mode() != 0644 {
+		t.Fatalf("unexpected info %v for a file: %v", info, err)
+	}
+	if _, err = fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("stat of a missing file returned %v", err)
+	}
+}
+
+func TestWritableFS(t *testing.T) {
+	w, cleanup := newTestFS(t)
+	defer cleanup()
+
+	if err := w.WriteFile("dir/file", []byte("1"), 0600); err != nil {
+		t.Fatalf("failed to write: %s", err)
+	}
+
+	if err := w.WriteFile("dir", nil, 0600); !errors.Is(err, errIsDir) {
+		t.Fatalf("wrote over a directory: %v", err)
+	}
+	if err := w.WriteFile("dir/file/child", nil, 0600); !errors.Is(err, errNotDir) {
+		t.Fatalf("wrote beneath a file: %v", err)
+	}
+	if err := w.WriteFile(".", nil, 0600); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("wrote the root directory: %v", err)
+	}
+
+	big := make([]byte, limits.KVS_VALUE_LEN_MAX+1)
+	if err := w.WriteFile("big", big, 0600); !errors.Is(err, syscall.EFBIG) {
+		t.Fatalf("wrote a file larger than a value: %v", err)
+	}
+
+	f, err := w.Create("dir/created", 0600)
+	if err != nil {
+		t.Fatalf("failed to create: %s", err)
+	}
+	f.Write([]byte("abc"))
+	f.Write([]byte("def"))
+	if _, err = w.Stat("dir/created"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("file exists before it is closed: %v", err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+	if data, err := w.ReadFile("dir/created"); err != nil || string(data) != "abcdef" {
+		t.Fatalf("read %q: %v", data, err)
+	}
+
+	if err = w.Remove("dir"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Fatalf("removed a directory with files: %v", err)
+	}
+	if err = w.Remove("dir/file"); err != nil {
+		t.Fatalf("failed to remove a file: %s", err)
+	}
+	if err = w.Remove("dir/file"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("removed a missing file: %v", err)
+	}
+
+	if err = w.RemoveAll("dir"); err != nil {
+		t.Fatalf("failed to remove all: %s", err)
+	}
+	if entries, err := w.ReadDir("."); err != nil || len(entries) != 0 {
+		t.Fatalf("root has entries %v after removing everything: %v", entries, err)
+	}
+	if err = w.RemoveAll("dir"); err != nil {
+		t.Fatalf("failed to remove a missing directory: %s", err)
+	}
+}
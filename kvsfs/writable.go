@@ -0,0 +1,273 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package kvsfs
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	hse "github.com/hse-project/hse-go"
+	"github.com/hse-project/hse-go/limits"
+)
+
+// WritableFS is an FS which can also be modified
+//
+// Every modification is made in one transaction, so readers never see a
+// partially written file. Missing parent directories are created. It is safe
+// for concurrent use.
+type WritableFS struct {
+	FS
+	kvdb *hse.Kvdb
+}
+
+// NewWritable creates a WritableFS over the files stored in kvs, which belongs
+// to kvdb. The Kvs must be opened with "transactions.enabled=true".
+func NewWritable(kvdb *hse.Kvdb, kvs *hse.Kvs) *WritableFS {
+	return &WritableFS{FS: FS{kvs: kvs}, kvdb: kvdb}
+}
+
+func txnGetter(txn *hse.Transaction, kvs *hse.Kvs) getter {
+	return func(key []byte) ([]byte, error) {
+		value, _, err := txn.Get(kvs, key, 0)
+		return value, err
+	}
+}
+
+// checkPath validates a path which is to be modified
+func checkPath(op string, name string) error {
+	if !fs.ValidPath(name) || name == "." || strings.IndexByte(name, 0) >= 0 {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	return nil
+}
+
+// mkdirAll creates the directory and its missing parents within txn
+func (w *WritableFS) mkdirAll(txn *hse.Transaction, name string, perm fs.FileMode, now time.Time) error {
+	if name == "." {
+		return nil
+	}
+
+	info, err := stat(txnGetter(txn, w.kvs), name)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		if !info.IsDir() {
+			return errNotDir
+		}
+		return nil
+	}
+
+	if err = w.mkdirAll(txn, path.Dir(name), perm, now); err != nil {
+		return err
+	}
+
+	return txn.Put(w.kvs, metaKey(name), encodeMeta(fs.ModeDir|perm.Perm(), 0, now), 0)
+}
+
+// MkdirAll creates the named directory along with any missing parents
+func (w *WritableFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) || strings.IndexByte(name, 0) >= 0 {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	err := w.kvdb.Transact(func(txn *hse.Transaction) error {
+		return w.mkdirAll(txn, name, perm, time.Now())
+	})
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// WriteFile writes data to the named file, creating it if needed
+//
+// The file's permissions are set to perm.
+func (w *WritableFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if err := checkPath("write", name); err != nil {
+		return err
+	}
+	if uint(len(data)) > limits.KVS_VALUE_LEN_MAX {
+		return &fs.PathError{Op: "write", Path: name, Err: syscall.EFBIG}
+	}
+
+	err := w.kvdb.Transact(func(txn *hse.Transaction) error {
+		now := time.Now()
+
+		if err := w.mkdirAll(txn, path.Dir(name), 0755, now); err != nil {
+			return err
+		}
+
+		info, err := stat(txnGetter(txn, w.kvs), name)
+		if err != nil {
+			return err
+		}
+		if info != nil && info.IsDir() {
+			return errIsDir
+		}
+
+		if err = txn.Put(w.kvs, metaKey(name), encodeMeta(perm.Perm(), int64(len(data)), now), 0); err != nil {
+			return err
+		}
+
+		return txn.Put(w.kvs, dataKey(name), data, 0)
+	})
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// Remove removes the named file or empty directory
+func (w *WritableFS) Remove(name string) error {
+	if err := checkPath("remove", name); err != nil {
+		return err
+	}
+
+	err := w.kvdb.Transact(func(txn *hse.Transaction) error {
+		info, err := stat(txnGetter(txn, w.kvs), name)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			return fs.ErrNotExist
+		}
+
+		if info.IsDir() {
+			names, err := w.list(txn, name, 1)
+			if err != nil {
+				return err
+			}
+			if len(names) > 0 {
+				return syscall.ENOTEMPTY
+			}
+		}
+
+		return w.remove(txn, name, info)
+	})
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// RemoveAll removes the named file or directory along with everything it
+// contains
+//
+// It is not an error if the path does not exist.
+func (w *WritableFS) RemoveAll(name string) error {
+	if err := checkPath("remove", name); err != nil {
+		return err
+	}
+
+	err := w.kvdb.Transact(func(txn *hse.Transaction) error {
+		return w.removeAll(txn, name)
+	})
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+func (w *WritableFS) removeAll(txn *hse.Transaction, name string) error {
+	info, err := stat(txnGetter(txn, w.kvs), name)
+	if err != nil || info == nil {
+		return err
+	}
+
+	if info.IsDir() {
+		names, err := w.list(txn, name, 0)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range names {
+			if err = w.removeAll(txn, path.Join(name, child)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.remove(txn, name, info)
+}
+
+func (w *WritableFS) remove(txn *hse.Transaction, name string, info *fileInfo) error {
+	if err := txn.Delete(w.kvs, metaKey(name), 0); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+
+	return txn.Delete(w.kvs, dataKey(name), 0)
+}
+
+// list returns the names of up to max entries of a directory within txn, or
+// all of them if max is 0
+func (w *WritableFS) list(txn *hse.Transaction, name string, max int) ([]string, error) {
+	prefix := dirPrefix(name)
+
+	c, err := txn.CreateCursor(w.kvs, prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Destroy()
+
+	var names []string
+	err = c.ForEach(func(key []byte, value []byte) error {
+		if names = append(names, string(key[len(prefix):])); len(names) == max {
+			return hse.ErrStopIteration
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// Writer buffers the contents of a file created by WritableFS.Create() until
+// it is closed
+type Writer struct {
+	fs   *WritableFS
+	name string
+	perm fs.FileMode
+	buf  bytes.Buffer
+}
+
+// Create returns a Writer which writes the named file when it is closed
+func (w *WritableFS) Create(name string, perm fs.FileMode) (*Writer, error) {
+	if err := checkPath("create", name); err != nil {
+		return nil, err
+	}
+
+	return &Writer{fs: w, name: name, perm: perm}, nil
+}
+
+// Write appends p to the contents of the file
+func (w *Writer) Write(p []byte) (int, error) {
+	if uint(w.buf.Len()+len(p)) > limits.KVS_VALUE_LEN_MAX {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: syscall.EFBIG}
+	}
+
+	return w.buf.Write(p)
+}
+
+// Close writes the file
+func (w *Writer) Close() error {
+	return w.fs.WriteFile(w.name, w.buf.Bytes(), w.perm)
+}
@@ -0,0 +1,208 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// MERGE_APPEND appends the operand to the value
+	MERGE_APPEND = "append"
+	// MERGE_ADD_INT64 adds the operand to the value, both 8 byte big-endian
+	// integers as used by Counter
+	MERGE_ADD_INT64 = "add-int64"
+	// MERGE_MAX keeps the lexicographically greater of the value and the
+	// operand
+	MERGE_MAX = "max"
+	// MERGE_SET_UNION adds the elements of the operand to the value, both
+	// sets encoded by EncodeSet()
+	MERGE_SET_UNION = "set-union"
+)
+
+var (
+	// ErrNoMergeOperator is returned by Kvs.Merge() when the Kvs has no merge
+	// operator
+	ErrNoMergeOperator = errors.New("hse: no merge operator")
+	// ErrMergeOperand is returned when a value or operand is not in the
+	// format of the merge operator
+	ErrMergeOperand = errors.New("hse: invalid merge operand")
+)
+
+// MergeFunc computes the new value of a key from its current value, nil if
+// the key does not exist, and an operand
+//
+// A MergeFunc may be called more than once for the same merge when the
+// transaction is retried, so it must not have side effects.
+type MergeFunc func(value []byte, operand []byte) ([]byte, error)
+
+var (
+	mergeMu        sync.RWMutex
+	mergeOperators = map[string]MergeFunc{
+		MERGE_APPEND:    mergeAppend,
+		MERGE_ADD_INT64: mergeAddInt64,
+		MERGE_MAX:       mergeMax,
+		MERGE_SET_UNION: mergeSetUnion,
+	}
+)
+
+// RegisterMergeOperator registers a custom merge operator under name
+//
+// It is an error to register a name twice. This function is thread safe.
+func RegisterMergeOperator(name string, fn MergeFunc) error {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+
+	if _, ok := mergeOperators[name]; ok {
+		return fmt.Errorf("merge operator %s is already registered", name)
+	}
+
+	mergeOperators[name] = fn
+
+	return nil
+}
+
+// SetMergeOperator sets the merge operator used by Kvs.Merge()
+//
+// name is either one of the MERGE_* operators or was registered with
+// RegisterMergeOperator(). This function is not thread safe.
+func (k *Kvs) SetMergeOperator(name string) error {
+	mergeMu.RLock()
+	fn, ok := mergeOperators[name]
+	mergeMu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unknown merge operator %s", name)
+	}
+
+	k.merge = fn
+
+	return nil
+}
+
+// Merge atomically merges operand into the value of key and returns the new
+// value
+//
+// The value is read, merged with the Kvs' merge operator and written back in a
+// transaction which is retried on conflicts. If the merge operator fails, its
+// error is returned and nothing is written. The Kvs must be opened with
+// "transactions.enabled=true". This function is thread safe.
+func (k *Kvs) Merge(key []byte, operand []byte) ([]byte, error) {
+	if k.merge == nil {
+		return nil, ErrNoMergeOperator
+	}
+
+	var merged []byte
+
+	err := k.kvdb.Transact(func(txn *Transaction) error {
+		value, _, err := txn.Get(k, key, 0)
+		if err != nil {
+			return err
+		}
+
+		if merged, err = k.merge(value, operand); err != nil {
+			return err
+		}
+
+		return txn.Put(k, key, merged, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+func mergeAppend(value []byte, operand []byte) ([]byte, error) {
+	merged := make([]byte, 0, len(value)+len(operand))
+
+	return append(append(merged, value...), operand...), nil
+}
+
+func mergeAddInt64(value []byte, operand []byte) ([]byte, error) {
+	n, err := decodeCounter(value)
+	if err != nil {
+		return nil, ErrMergeOperand
+	}
+	if len(operand) != 8 {
+		return nil, ErrMergeOperand
+	}
+
+	merged := make([]byte, 8)
+	binary.BigEndian.PutUint64(merged, uint64(n)+binary.BigEndian.Uint64(operand))
+
+	return merged, nil
+}
+
+func mergeMax(value []byte, operand []byte) ([]byte, error) {
+	if value != nil && bytes.Compare(value, operand) >= 0 {
+		return value, nil
+	}
+
+	return operand, nil
+}
+
+func mergeSetUnion(value []byte, operand []byte) ([]byte, error) {
+	elems, err := DecodeSet(value)
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := DecodeSet(operand)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeSet(append(elems, added...)), nil
+}
+
+// EncodeSet encodes a set of elements for MERGE_SET_UNION
+//
+// The elements are sorted and deduplicated, and each is prefixed with its
+// length as a uvarint.
+func EncodeSet(elems [][]byte) []byte {
+	sorted := append([][]byte(nil), elems...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	var buf []byte
+	var lenBuf [binary.MaxVarintLen64]byte
+
+	for i, elem := range sorted {
+		if i > 0 && bytes.Equal(elem, sorted[i-1]) {
+			continue
+		}
+
+		n := binary.PutUvarint(lenBuf[:], uint64(len(elem)))
+		buf = append(buf, lenBuf[:n]...)
+		buf = append(buf, elem...)
+	}
+
+	return buf
+}
+
+// DecodeSet decodes a set encoded by EncodeSet()
+func DecodeSet(buf []byte) ([][]byte, error) {
+	var elems [][]byte
+
+	for len(buf) > 0 {
+		l, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < l {
+			return nil, ErrMergeOperand
+		}
+
+		elems = append(elems, buf[n:n+int(l)])
+		buf = buf[n+int(l):]
+	}
+
+	return elems, nil
+}
@@ -0,0 +1,148 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const (
+	mergeTestKvsName = "merge-test"
+)
+
+func int64Operand(n int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(n))
+
+	return buf
+}
+
+func TestMerge(t *testing.T) {
+	kvs := makeAndOpenKvs(mergeTestKvsName, txnParams)
+	defer kvdb.KvsDrop(mergeTestKvsName)
+	defer kvs.Close()
+
+	if _, err := kvs.Merge([]byte("key"), []byte("a")); err != ErrNoMergeOperator {
+		t.Fatalf("merged without an operator: %v", err)
+	}
+
+	tests := []struct {
+		operator string
+		operands [][]byte
+		expected []byte
+	}{
+		{MERGE_APPEND, [][]byte{[]byte("a"), []byte("bc")}, []byte("abc")},
+		{MERGE_ADD_INT64, [][]byte{int64Operand(5), int64Operand(-7)}, int64Operand(-2)},
+		{MERGE_MAX, [][]byte{[]byte("b"), []byte("c"), []byte("a")}, []byte("c")},
+		{
+			MERGE_SET_UNION,
+			[][]byte{
+				EncodeSet([][]byte{[]byte("y"), []byte("x")}),
+				EncodeSet([][]byte{[]byte("z"), []byte("x")}),
+			},
+			EncodeSet([][]byte{[]byte("x"), []byte("y"), []byte("z")}),
+		},
+	}
+
+	for _, test := range tests {
+		if err := kvs.SetMergeOperator(test.operator); err != nil {
+			t.Fatalf("failed to set merge operator %s: %s", test.operator, err)
+		}
+
+		key := []byte(test.operator)
+
+		var merged []byte
+		for _, operand := range test.operands {
+			var err error
+			if merged, err = kvs.Merge(key, operand); err != nil {
+				t.Fatalf("%s: failed to merge: %s", test.operator, err)
+			}
+		}
+
+		value, _, err := kvs.Get(key, 0)
+		if err != nil {
+			t.Fatalf("%s: failed to get: %s", test.operator, err)
+		}
+		if !bytes.Equal(value, test.expected) || !bytes.Equal(merged, test.expected) {
+			t.Fatalf("%s: value is %q, expected %q", test.operator, value, test.expected)
+		}
+	}
+
+	if _, err := kvs.Merge([]byte(MERGE_SET_UNION), []byte{0xff}); err != ErrMergeOperand {
+		t.Fatalf("merged an invalid set: %v", err)
+	}
+}
+
+func TestMergeCustom(t *testing.T) {
+	kvs := makeAndOpenKvs(mergeTestKvsName, txnParams)
+	defer kvdb.KvsDrop(mergeTestKvsName)
+	defer kvs.Close()
+
+	errOdd := errors.New("odd length")
+
+	err := RegisterMergeOperator("test-prepend", func(value []byte, operand []byte) ([]byte, error) {
+		if len(operand)%2 != 0 {
+			return nil, errOdd
+		}
+		return append(append([]byte(nil), operand...), value...), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to register merge operator: %s", err)
+	}
+	if err = RegisterMergeOperator(MERGE_APPEND, nil); err == nil {
+		t.Fatalf("registered a builtin merge operator twice")
+	}
+
+	if err = kvs.SetMergeOperator("test-prepend"); err != nil {
+		t.Fatalf("failed to set merge operator: %s", err)
+	}
+
+	kvs.Merge([]byte("key"), []byte("cd"))
+	kvs.Merge([]byte("key"), []byte("ab"))
+	if _, err = kvs.Merge([]byte("key"), []byte("x")); err != errOdd {
+		t.Fatalf("expected the merge operator's error, got %v", err)
+	}
+
+	value, _, err := kvs.Get([]byte("key"), 0)
+	if err != nil || string(value) != "abcd" {
+		t.Fatalf("value is %q, expected abcd: %v", value, err)
+	}
+}
+
+func TestMergeConcurrent(t *testing.T) {
+	kvs := makeAndOpenKvs(mergeTestKvsName, txnParams)
+	defer kvdb.KvsDrop(mergeTestKvsName)
+	defer kvs.Close()
+
+	if err := kvs.SetMergeOperator(MERGE_ADD_INT64); err != nil {
+		t.Fatalf("failed to set merge operator: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if _, err := kvs.Merge([]byte("key"), int64Operand(1)); err != nil {
+					t.Errorf("failed to merge: %s", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, _, err := kvs.Get([]byte("key"), 0)
+	if err != nil || !reflect.DeepEqual(value, int64Operand(100)) {
+		t.Fatalf("value is %x, expected 100: %v", value, err)
+	}
+}
@@ -0,0 +1,393 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Package metrics records counts, errors, sizes and latencies of HSE operations
+//
+// Instrumentation is opt-in: a Collector is an hse.Hook, and
+// Collector.Instrument() installs it on a Kvdb so that every operation made
+// through the Kvdb and the Kvs, cursors and transactions which belong to it is
+// recorded. Metrics are labeled by KVS name and operation, as named by
+// hse.Op, and are exposed through expvar and in the Prometheus text format.
+// Compaction status and media class usage of every instrumented Kvdb are
+// exported as gauges.
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hse "github.com/hse-project/hse-go"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the latency histogram
+// buckets
+var LatencyBuckets = []float64{
+	0.000001, 0.000005, 0.00001, 0.00005, 0.0001, 0.0005,
+	0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1,
+}
+
+type opKey struct {
+	kvs string
+	op  string
+}
+
+type opStats struct {
+	count      uint64
+	errors     uint64
+	keyBytes   uint64
+	valueBytes uint64
+	durationNs uint64
+	// buckets holds non-cumulative counts, with one extra bucket for +Inf
+	buckets []uint64
+}
+
+// Collector accumulates metrics for operations on instrumented Kvdbs
+//
+// Collector implements hse.Hook.
+type Collector struct {
+	mu    sync.RWMutex
+	ops   map[opKey]*opStats
+	kvdbs []*hse.Kvdb
+}
+
+// NewCollector creates an empty Collector
+func NewCollector() *Collector {
+	return &Collector{
+		ops: make(map[opKey]*opStats),
+	}
+}
+
+func (c *Collector) stats(kvs string, op string) *opStats {
+	key := opKey{kvs: kvs, op: op}
+
+	c.mu.RLock()
+	s := c.ops[key]
+	c.mu.RUnlock()
+
+	if s != nil {
+		return s
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s = c.ops[key]; s == nil {
+		s = &opStats{buckets: make([]uint64, len(LatencyBuckets)+1)}
+		c.ops[key] = s
+	}
+
+	return s
+}
+
+// observe records an operation which started at start
+func (c *Collector) observe(kvs string, op string, start time.Time, keyLen int, valueLen int, err error) {
+	elapsed := time.Since(start)
+	s := c.stats(kvs, op)
+
+	atomic.AddUint64(&s.count, 1)
+	if err != nil {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	atomic.AddUint64(&s.keyBytes, uint64(keyLen))
+	atomic.AddUint64(&s.valueBytes, uint64(valueLen))
+	atomic.AddUint64(&s.durationNs, uint64(elapsed))
+
+	i := sort.SearchFloat64s(LatencyBuckets, elapsed.Seconds())
+	atomic.AddUint64(&s.buckets[i], 1)
+}
+
+// startKey is the context key of an operation's start time, which is distinct
+// per Collector so that several can be added to the same Kvdb
+type startKey struct {
+	c *Collector
+}
+
+// scrapeKey marks the context of the calls made to export gauges, which are
+// not recorded
+type scrapeKey struct{}
+
+// Start records the start time of an operation, implementing hse.Hook
+func (c *Collector) Start(ctx context.Context, op *hse.Op) context.Context {
+	if ctx.Value(scrapeKey{}) != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, startKey{c}, time.Now())
+}
+
+// End records a finished operation, implementing hse.Hook
+func (c *Collector) End(ctx context.Context, op *hse.Op) {
+	start, ok := ctx.Value(startKey{c}).(time.Time)
+	if !ok {
+		return
+	}
+
+	c.observe(op.Kvs, op.Name, start, op.KeyLen, op.ValueLen, op.Err)
+}
+
+// Instrument adds the Collector to the hooks of an open Kvdb
+//
+// Any other hook set on the Kvdb keeps observing its operations. The compaction
+// status and media class usage of the Kvdb are exported as gauges until
+// Detach() is called, which must happen before the Kvdb is closed. Like
+// hse.Kvdb.AddHook(), this should be called before the Kvdb is used.
+func (c *Collector) Instrument(kvdb *hse.Kvdb) {
+	kvdb.AddHook(c)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.kvdbs = append(c.kvdbs, kvdb)
+}
+
+// Detach removes the Collector from a Kvdb passed to Instrument() and stops
+// exporting its gauges
+//
+// Other hooks set on the Kvdb are left in place. Metrics already recorded are
+// kept.
+func (c *Collector) Detach(kvdb *hse.Kvdb) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, k := range c.kvdbs {
+		if k == kvdb {
+			kvdb.RemoveHook(c)
+			c.kvdbs = append(c.kvdbs[:i], c.kvdbs[i+1:]...)
+			return
+		}
+	}
+}
+
+type snapshot struct {
+	key        opKey
+	count      uint64
+	errors     uint64
+	keyBytes   uint64
+	valueBytes uint64
+	durationNs uint64
+	buckets    []uint64
+}
+
+// snapshot returns the current value of every operation's metrics, sorted by
+// KVS and operation
+func (c *Collector) snapshot() []snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snaps := make([]snapshot, 0, len(c.ops))
+	for key, s := range c.ops {
+		snap := snapshot{
+			key:        key,
+			count:      atomic.LoadUint64(&s.count),
+			errors:     atomic.LoadUint64(&s.errors),
+			keyBytes:   atomic.LoadUint64(&s.keyBytes),
+			valueBytes: atomic.LoadUint64(&s.valueBytes),
+			durationNs: atomic.LoadUint64(&s.durationNs),
+			buckets:    make([]uint64, len(s.buckets)),
+		}
+		for i := range s.buckets {
+			snap.buckets[i] = atomic.LoadUint64(&s.buckets[i])
+		}
+
+		snaps = append(snaps, snap)
+	}
+
+	sort.Slice(snaps, func(i, j int) bool {
+		if snaps[i].key.kvs != snaps[j].key.kvs {
+			return snaps[i].key.kvs < snaps[j].key.kvs
+		}
+		return snaps[i].key.op < snaps[j].key.op
+	})
+
+	return snaps
+}
+
+func (c *Collector) instrumentedKvdbs() []*hse.Kvdb {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]*hse.Kvdb(nil), c.kvdbs...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labels(pairs ...string) string {
+	var sb strings.Builder
+
+	sb.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1]))
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
+
+// WritePrometheus writes all metrics to w in the Prometheus text exposition
+// format
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	snaps := c.snapshot()
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(s *snapshot) uint64
+	}{
+		{"hse_operations_total", "Number of HSE operations.", func(s *snapshot) uint64 { return s.count }},
+		{"hse_operation_errors_total", "Number of HSE operations which failed.", func(s *snapshot) uint64 { return s.errors }},
+		{"hse_operation_key_bytes_total", "Bytes of keys passed to or returned by HSE operations.", func(s *snapshot) uint64 { return s.keyBytes }},
+		{"hse_operation_value_bytes_total", "Bytes of values passed to or returned by HSE operations.", func(s *snapshot) uint64 { return s.valueBytes }},
+	}
+
+	for _, counter := range counters {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", counter.name, counter.help, counter.name)
+		for i := range snaps {
+			fmt.Fprintf(bw, "%s%s %d\n", counter.name, labels("kvs", snaps[i].key.kvs, "op", snaps[i].key.op), counter.value(&snaps[i]))
+		}
+	}
+
+	const histogram = "hse_operation_duration_seconds"
+	fmt.Fprintf(bw, "# HELP %s Latency of HSE operations.\n# TYPE %s histogram\n", histogram, histogram)
+	for _, s := range snaps {
+		var cumulative uint64
+
+		for i, le := range LatencyBuckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", histogram, labels("kvs", s.key.kvs, "op", s.key.op, "le", fmt.Sprint(le)), cumulative)
+		}
+		cumulative += s.buckets[len(LatencyBuckets)]
+		fmt.Fprintf(bw, "%s_bucket%s %d\n", histogram, labels("kvs", s.key.kvs, "op", s.key.op, "le", "+Inf"), cumulative)
+		fmt.Fprintf(bw, "%s_sum%s %g\n", histogram, labels("kvs", s.key.kvs, "op", s.key.op), time.Duration(s.durationNs).Seconds())
+		fmt.Fprintf(bw, "%s_count%s %d\n", histogram, labels("kvs", s.key.kvs, "op", s.key.op), s.count)
+	}
+
+	c.writeGauges(bw)
+
+	return bw.Flush()
+}
+
+func (c *Collector) writeGauges(w io.Writer) {
+	type gauge struct {
+		labels string
+		value  interface{}
+	}
+
+	gauges := make(map[string][]gauge)
+
+	// Scrapes are not operations of the application, so keep them out of the
+	// metrics
+	ctx := context.WithValue(context.Background(), scrapeKey{}, true)
+
+	for _, kvdb := range c.instrumentedKvdbs() {
+		home := kvdb.Home()
+
+		if status, err := kvdb.CompactStatusContext(ctx); err == nil {
+			l := labels("kvdb", home)
+			gauges["hse_kvdb_compact_samp_lwm"] = append(gauges["hse_kvdb_compact_samp_lwm"], gauge{l, status.SampLwm})
+			gauges["hse_kvdb_compact_samp_hwm"] = append(gauges["hse_kvdb_compact_samp_hwm"], gauge{l, status.SampHwm})
+			gauges["hse_kvdb_compact_samp_curr"] = append(gauges["hse_kvdb_compact_samp_curr"], gauge{l, status.SampCurr})
+			gauges["hse_kvdb_compact_active"] = append(gauges["hse_kvdb_compact_active"], gauge{l, boolGauge(status.Active)})
+			gauges["hse_kvdb_compact_canceled"] = append(gauges["hse_kvdb_compact_canceled"], gauge{l, boolGauge(status.Canceled)})
+		}
+
+		for m := hse.Mclass(0); m < hse.MCLASS_COUNT; m++ {
+			if !kvdb.MclassIsConfigured(m) {
+				continue
+			}
+
+			info, err := kvdb.MclassInfo(m)
+			if err != nil {
+				continue
+			}
+
+			l := labels("kvdb", home, "mclass", m.String())
+			gauges["hse_kvdb_mclass_allocated_bytes"] = append(gauges["hse_kvdb_mclass_allocated_bytes"], gauge{l, info.AllocatedBytes})
+			gauges["hse_kvdb_mclass_used_bytes"] = append(gauges["hse_kvdb_mclass_used_bytes"], gauge{l, info.UsedBytes})
+		}
+	}
+
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		for _, g := range gauges[name] {
+			fmt.Fprintf(w, "%s%s %v\n", name, g.labels, g.value)
+		}
+	}
+}
+
+func boolGauge(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Handler returns an http.Handler which serves the metrics in the Prometheus
+// text exposition format
+func (c *Collector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.WritePrometheus(w)
+	})
+}
+
+type expvarOp struct {
+	Count           uint64  `json:"count"`
+	Errors          uint64  `json:"errors"`
+	KeyBytes        uint64  `json:"key_bytes"`
+	ValueBytes      uint64  `json:"value_bytes"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// String returns the operation metrics as a JSON object keyed by KVS name and
+// then operation, implementing expvar.Var
+func (c *Collector) String() string {
+	out := make(map[string]map[string]expvarOp)
+
+	for _, s := range c.snapshot() {
+		if out[s.key.kvs] == nil {
+			out[s.key.kvs] = make(map[string]expvarOp)
+		}
+
+		out[s.key.kvs][s.key.op] = expvarOp{
+			Count:           s.count,
+			Errors:          s.errors,
+			KeyBytes:        s.keyBytes,
+			ValueBytes:      s.valueBytes,
+			DurationSeconds: time.Duration(s.durationNs).Seconds(),
+		}
+	}
+
+	data, _ := json.Marshal(out)
+
+	return string(data)
+}
+
+// Publish publishes the Collector with expvar under name
+//
+// Like expvar.Publish(), this panics if name is already in use.
+func (c *Collector) Publish(name string) {
+	expvar.Publish(name, c)
+}
@@ -0,0 +1,204 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	hse "github.com/hse-project/hse-go"
+	"github.com/hse-project/hse-go/internal/hsetest"
+)
+
+const (
+	metricsTestKvsName = "metrics-test"
+)
+
+func newTestKvs(t *testing.T) (*hse.Kvdb, *hse.Kvs, func()) {
+	kvdb, cleanup := hsetest.NewKvdb(t, metricsTestKvsName)
+
+	kvs, err := kvdb.KvsOpen(metricsTestKvsName)
+	if err != nil {
+		t.Fatalf("failed to open kvs: %s", err)
+	}
+
+	return kvdb, kvs, func() {
+		kvs.Close()
+		cleanup()
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	c := NewCollector()
+
+	start := time.Now()
+	c.observe("users", "put", start, 3, 5, nil)
+	c.observe("users", "put", start, 3, 7, errors.New("failed"))
+	c.observe("users", "get", start, 3, 5, nil)
+
+	var buf bytes.Buffer
+	if err := c.WritePrometheus(&buf); err != nil {
+		t.Fatalf("failed to write metrics: %s", err)
+	}
+	out := buf.String()
+
+	for _, line := range []string{
+		`hse_operations_total{kvs="users",op="put"} 2`,
+		`hse_operation_errors_total{kvs="users",op="put"} 1`,
+		`hse_operation_value_bytes_total{kvs="users",op="put"} 12`,
+		`hse_operation_duration_seconds_bucket{kvs="users",op="get",le="+Inf"} 1`,
+		`hse_operation_duration_seconds_count{kvs="users",op="put"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("metrics are missing %q:\n%s", line, out)
+		}
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	c := NewCollector()
+
+	c.observe("users", "get", time.Now(), 3, 5, nil)
+
+	var out map[string]map[string]expvarOp
+	if err := json.Unmarshal([]byte(c.String()), &out); err != nil {
+		t.Fatalf("expvar output is not valid JSON: %s", err)
+	}
+
+	if out["users"]["get"].Count != 1 || out["users"]["get"].KeyBytes != 3 {
+		t.Fatalf("unexpected expvar output: %s", c.String())
+	}
+}
+
+// kvsOps returns the metrics the Collector recorded for the test Kvs
+func kvsOps(t *testing.T, c *Collector) map[string]expvarOp {
+	var out map[string]map[string]expvarOp
+	if err := json.Unmarshal([]byte(c.String()), &out); err != nil {
+		t.Fatalf("expvar output is not valid JSON: %s", err)
+	}
+
+	return out[metricsTestKvsName]
+}
+
+func TestInstrument(t *testing.T) {
+	kvdb, kvs, cleanup := newTestKvs(t)
+	defer cleanup()
+
+	c := NewCollector()
+	c.Instrument(kvdb)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := kvs.Put([]byte(key), []byte("value"), 0); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+	if _, _, err := kvs.Get([]byte("a"), 0); err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	cursor, err := kvs.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	if _, _, err = cursor.Read(0); err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	if err = cursor.ForEach(func(key []byte, value []byte) error { return nil }); err != nil {
+		t.Fatalf("failed to iterate: %s", err)
+	}
+	cursor.Destroy()
+
+	ops := kvsOps(t, c)
+	if ops["kvs_put"].Count != 3 || ops["kvs_put"].KeyBytes != 3 || ops["kvs_put"].ValueBytes != 15 {
+		t.Fatalf("unexpected put metrics %+v", ops["kvs_put"])
+	}
+	if ops["kvs_get"].Count != 1 || ops["kvs_get"].ValueBytes != 5 {
+		t.Fatalf("unexpected get metrics %+v", ops["kvs_get"])
+	}
+	if ops["cursor_create"].Count != 1 || ops["cursor_destroy"].Count != 1 {
+		t.Fatalf("unexpected cursor metrics %s", c.String())
+	}
+	if ops["cursor_read"].Count != 1 || ops["cursor_read"].KeyBytes != 1 {
+		t.Fatalf("unexpected read metrics %+v", ops["cursor_read"])
+	}
+	if ops["cursor_read_batch"].KeyBytes != 2 || ops["cursor_read_batch"].ValueBytes != 10 {
+		t.Fatalf("unexpected batch read metrics %+v", ops["cursor_read_batch"])
+	}
+
+	c.Detach(kvdb)
+
+	// Operations after Detach() are not recorded
+	if err = kvs.Put([]byte("d"), []byte("value"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	ops = kvsOps(t, c)
+	if ops["kvs_put"].Count != 3 {
+		t.Fatalf("recorded a put after Detach(): %+v", ops["kvs_put"])
+	}
+	for op, m := range ops {
+		if m.Errors != 0 {
+			t.Fatalf("unexpected errors recorded for %s", op)
+		}
+	}
+}
+
+type countingHook struct {
+	count int
+}
+
+func (h *countingHook) Start(ctx context.Context, op *hse.Op) context.Context {
+	return ctx
+}
+
+func (h *countingHook) End(ctx context.Context, op *hse.Op) {
+	h.count++
+}
+
+func TestInstrumentHooks(t *testing.T) {
+	kvdb, kvs, cleanup := newTestKvs(t)
+	defer cleanup()
+
+	hook := &countingHook{}
+	kvdb.SetHook(hook)
+	defer kvdb.SetHook(nil)
+
+	c := NewCollector()
+	c.Instrument(kvdb)
+
+	if err := kvs.Put([]byte("a"), []byte("value"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if hook.count != 1 || kvsOps(t, c)["kvs_put"].Count != 1 {
+		t.Fatalf("put was not seen by both hooks: %d, %s", hook.count, c.String())
+	}
+
+	// Exporting the gauges is not an operation of the application
+	var buf bytes.Buffer
+	if err := c.WritePrometheus(&buf); err != nil {
+		t.Fatalf("failed to write metrics: %s", err)
+	}
+	if !strings.Contains(buf.String(), "hse_kvdb_compact_active") {
+		t.Fatalf("metrics are missing the compaction gauges:\n%s", buf.String())
+	}
+	if strings.Contains(c.String(), "kvdb_compact_status") || hook.count != 2 {
+		t.Fatalf("scrape was recorded as an operation: %s", c.String())
+	}
+
+	c.Detach(kvdb)
+
+	if err := kvs.Put([]byte("b"), []byte("value"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if hook.count != 3 {
+		t.Fatalf("Detach() removed the other hook")
+	}
+}
@@ -0,0 +1,308 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+/*
+#include <hse/hse.h>
+
+enum {
+	HSE_GO_NOT_FOUND,
+	HSE_GO_FOUND,
+	HSE_GO_TRUNCATED,
+};
+
+// Keys are packed back to back in keys. Values are stored back to back in buf
+// as long as they fit, otherwise the value is reported as truncated and the
+// next value is stored where it would have gone.
+static void
+hse_go_kvs_multi_get(
+	struct hse_kvs *kvs,
+	unsigned int flags,
+	struct hse_kvdb_txn *txn,
+	const char *keys,
+	const size_t *key_lens,
+	size_t count,
+	char *buf,
+	size_t buf_len,
+	size_t *value_lens,
+	unsigned char *found,
+	hse_err_t *errs)
+{
+	size_t off = 0;
+
+	for (size_t i = 0; i < count; i++) {
+		bool f = false;
+
+		value_lens[i] = 0;
+		errs[i] = hse_kvs_get(kvs, flags, txn, keys, key_lens[i], &f, buf + off, buf_len - off,
+			&value_lens[i]);
+		keys += key_lens[i];
+
+		if (errs[i] || !f) {
+			found[i] = HSE_GO_NOT_FOUND;
+		} else if (value_lens[i] > buf_len - off) {
+			found[i] = HSE_GO_TRUNCATED;
+		} else {
+			found[i] = HSE_GO_FOUND;
+			off += value_lens[i];
+		}
+	}
+}
+
+// Each key is followed by its value in data.
+static void
+hse_go_kvs_multi_put(
+	struct hse_kvs *kvs,
+	unsigned int flags,
+	struct hse_kvdb_txn *txn,
+	const char *data,
+	const size_t *key_lens,
+	const size_t *value_lens,
+	size_t count,
+	hse_err_t *errs)
+{
+	for (size_t i = 0; i < count; i++) {
+		const char *key = data;
+		const char *value = data + key_lens[i];
+
+		errs[i] = hse_kvs_put(kvs, flags, txn, key, key_lens[i], value_lens[i] ? value : NULL,
+			value_lens[i]);
+		data = value + value_lens[i];
+	}
+}
+*/
+import "C"
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/hse-project/hse-go/limits"
+)
+
+// KeyValue is a key-value pair written by Kvs.MultiPut()
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// multiGetBufs holds the buffers values are read into, which are as large as
+// the largest value
+var multiGetBufs = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, limits.KVS_VALUE_LEN_MAX)
+		return &buf
+	},
+}
+
+// packKeys returns keys packed back to back along with their lengths
+func packKeys(keys [][]byte) ([]byte, []C.size_t) {
+	size := 0
+	for _, key := range keys {
+		size += len(key)
+	}
+
+	packed := make([]byte, 0, size)
+	lens := make([]C.size_t, len(keys))
+	for i, key := range keys {
+		packed = append(packed, key...)
+		lens[i] = C.size_t(len(key))
+	}
+
+	return packed, lens
+}
+
+func bytesPtr(b []byte) *C.char {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return (*C.char)(unsafe.Pointer(&b[0]))
+}
+
+// MultiGet retrieves the values for many keys from the Kvs with a single call
+// into HSE
+//
+// Each key is looked up as by Kvs.Get(), and values[i] and errs[i] are the
+// result for keys[i]. As with Kvs.Get(), the value of a key which does not
+// exist is nil. errs is nil if every lookup succeeded. Values are copied out of
+// HSE into memory owned by Go. This function is thread safe.
+func (k *Kvs) MultiGet(keys [][]byte, flags GetFlags) ([][]byte, []error) {
+	return k.multiGet(nil, keys, flags)
+}
+
+func (k *Kvs) multiGet(txn *Transaction, keys [][]byte, flags GetFlags) ([][]byte, []error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	packed, keyLens := packKeys(keys)
+
+	bufp := multiGetBufs.Get().(*[]byte)
+	defer multiGetBufs.Put(bufp)
+	buf := *bufp
+
+	valueLens := make([]C.size_t, len(keys))
+	found := make([]C.uchar, len(keys))
+	rcs := make([]C.hse_err_t, len(keys))
+
+	t := k.startOp(txn, "kvs_multi_get", len(packed), 0)
+
+	C.hse_go_kvs_multi_get(k.impl, C.uint(flags), txn.cimpl(), bytesPtr(packed), &keyLens[0],
+		C.size_t(len(keys)), bytesPtr(buf), C.size_t(len(buf)), &valueLens[0], &found[0], &rcs[0])
+
+	size := 0
+	for i := range keys {
+		if found[i] == C.HSE_GO_FOUND {
+			size += int(valueLens[i])
+		}
+	}
+
+	// Copy the values out of the shared buffer into one allocation
+	values := make([][]byte, len(keys))
+	out := make([]byte, size)
+	copy(out, buf[:size])
+
+	var errs []error
+	var firstErr error
+	for i, key := range keys {
+		switch {
+		case rcs[i] != 0:
+			if errs == nil {
+				errs = make([]error, len(keys))
+			}
+			errs[i] = hseErrToErrno(C.ulong(rcs[i]))
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+		case found[i] == C.HSE_GO_FOUND:
+			n := int(valueLens[i])
+			values[i] = out[:n:n]
+			out = out[n:]
+		case found[i] == C.HSE_GO_TRUNCATED:
+			value, _, err := k.get(txn, key, flags)
+			if err != nil {
+				if errs == nil {
+					errs = make([]error, len(keys))
+				}
+				errs[i] = err
+			}
+			values[i] = value
+		}
+	}
+
+	t.done(len(packed), size, firstErr)
+
+	return values, errs
+}
+
+// MultiPut places many KV pairs into the Kvs with a single call into HSE
+//
+// Each pair is put as by Kvs.Put() and errs[i] is the result for pairs[i].
+// errs is nil if every put succeeded. Outside of a transaction, the puts are
+// independent of each other, so some may succeed while others fail, unless the
+// Kvs is recorded by change data capture, in which case they are made in one
+// transaction. This function is thread safe.
+func (k *Kvs) MultiPut(pairs []KeyValue, flags PutFlags) []error {
+	return k.multiPut(nil, pairs, flags)
+}
+
+func (k *Kvs) multiPut(txn *Transaction, pairs []KeyValue, flags PutFlags) []error {
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	if txn == nil && k.kvdb.cdc.logs(k.name) {
+		var errs []error
+
+		err := k.kvdb.Transact(func(txn *Transaction) error {
+			if errs = k.multiPut(txn, pairs, flags); errs != nil {
+				for _, err := range errs {
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			// None of the puts were committed
+			if errs == nil {
+				errs = make([]error, len(pairs))
+			}
+			for i := range errs {
+				if errs[i] == nil {
+					errs[i] = err
+				}
+			}
+		}
+
+		return errs
+	}
+
+	size := 0
+	for _, pair := range pairs {
+		size += len(pair.Key) + len(pair.Value)
+	}
+
+	data := make([]byte, 0, size)
+	keyLens := make([]C.size_t, len(pairs))
+	valueLens := make([]C.size_t, len(pairs))
+	for i, pair := range pairs {
+		data = append(data, pair.Key...)
+		data = append(data, pair.Value...)
+		keyLens[i] = C.size_t(len(pair.Key))
+		valueLens[i] = C.size_t(len(pair.Value))
+	}
+
+	rcs := make([]C.hse_err_t, len(pairs))
+
+	if k.kvdb.lockChanges(txn, k.name) {
+		defer k.kvdb.feed.order.Unlock()
+	}
+
+	t := k.startOp(txn, "kvs_multi_put", size, 0)
+
+	C.hse_go_kvs_multi_put(k.impl, C.uint(flags), txn.cimpl(), bytesPtr(data), &keyLens[0],
+		&valueLens[0], C.size_t(len(pairs)), &rcs[0])
+
+	var errs []error
+	var firstErr error
+	for i, pair := range pairs {
+		if rcs[i] == 0 {
+			k.kvdb.recordChange(txn, k.name, CHANGE_PUT, pair.Key, pair.Value)
+			continue
+		}
+
+		if errs == nil {
+			errs = make([]error, len(pairs))
+		}
+		errs[i] = hseErrToErrno(C.ulong(rcs[i]))
+		if firstErr == nil {
+			firstErr = errs[i]
+		}
+	}
+
+	t.done(size, 0, firstErr)
+
+	return errs
+}
+
+// MultiGet retrieves the values for many keys from a Kvs within the context of
+// the transaction
+//
+// See Kvs.MultiGet().
+func (t *Transaction) MultiGet(kvs *Kvs, keys [][]byte, flags GetFlags) ([][]byte, []error) {
+	return kvs.multiGet(t, keys, flags)
+}
+
+// MultiPut places many KV pairs into a Kvs within the context of the
+// transaction
+//
+// See Kvs.MultiPut().
+func (t *Transaction) MultiPut(kvs *Kvs, pairs []KeyValue, flags PutFlags) []error {
+	return kvs.multiPut(t, pairs, flags)
+}
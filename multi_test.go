@@ -0,0 +1,219 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+const (
+	multiTestKvsName = "multi-test"
+	multiBatch       = 64
+)
+
+func TestMultiGetPut(t *testing.T) {
+	kvs := makeAndOpenKvs(multiTestKvsName, params{})
+	defer kvdb.KvsDrop(multiTestKvsName)
+	defer kvs.Close()
+
+	pairs := []KeyValue{
+		{Key: []byte("key0"), Value: []byte("value0")},
+		{Key: []byte("key1"), Value: []byte{}},
+		{Key: []byte{}, Value: []byte("no key")},
+		{Key: []byte("key3"), Value: bytes.Repeat([]byte("v"), 4096)},
+	}
+
+	errs := kvs.MultiPut(pairs, 0)
+	if len(errs) != len(pairs) {
+		t.Fatalf("expected %d errors, got %v", len(pairs), errs)
+	}
+	for i, err := range errs {
+		if i == 2 && err != syscall.EINVAL {
+			t.Fatalf("put of an empty key returned %v", err)
+		}
+		if i != 2 && err != nil {
+			t.Fatalf("failed to put %s: %s", pairs[i].Key, err)
+		}
+	}
+
+	keys := [][]byte{[]byte("key0"), []byte("missing"), []byte("key1"), []byte("key3"), {}}
+	values, errs := kvs.MultiGet(keys, 0)
+	if len(values) != len(keys) || len(errs) != len(keys) {
+		t.Fatalf("expected %d results, got %d values and %d errors", len(keys), len(values), len(errs))
+	}
+
+	if string(values[0]) != "value0" || errs[0] != nil {
+		t.Fatalf("unexpected value %q for key0: %v", values[0], errs[0])
+	}
+	if values[1] != nil || errs[1] != nil {
+		t.Fatalf("found a missing key: %v", errs[1])
+	}
+	if values[2] == nil || len(values[2]) != 0 || errs[2] != nil {
+		t.Fatalf("unexpected value %q for an empty value: %v", values[2], errs[2])
+	}
+	if !bytes.Equal(values[3], pairs[3].Value) || errs[3] != nil {
+		t.Fatalf("unexpected value for key3: %v", errs[3])
+	}
+	if errs[4] != syscall.EINVAL {
+		t.Fatalf("get of an empty key returned %v", errs[4])
+	}
+
+	if values, errs = kvs.MultiGet(keys[:1], 0); errs != nil || string(values[0]) != "value0" {
+		t.Fatalf("unexpected result %q: %v", values[0], errs)
+	}
+}
+
+func TestMultiGetPutTransaction(t *testing.T) {
+	kvs := makeAndOpenKvs(multiTestKvsName, txnParams)
+	defer kvdb.KvsDrop(multiTestKvsName)
+	defer kvs.Close()
+
+	err := kvdb.Transact(func(txn *Transaction) error {
+		if errs := txn.MultiPut(kvs, []KeyValue{{Key: []byte("key0"), Value: []byte("value0")}}, 0); errs != nil {
+			return errs[0]
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed transaction: %s", err)
+	}
+
+	err = kvdb.Transact(func(txn *Transaction) error {
+		if errs := txn.MultiPut(kvs, []KeyValue{{Key: []byte("key4"), Value: []byte("value4")}}, 0); errs != nil {
+			return errs[0]
+		}
+
+		values, errs := txn.MultiGet(kvs, [][]byte{[]byte("key4"), []byte("key0")}, 0)
+		if errs != nil {
+			return errs[0]
+		}
+		if string(values[0]) != "value4" || string(values[1]) != "value0" {
+			t.Errorf("unexpected values %q within transaction", values)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed transaction: %s", err)
+	}
+}
+
+// Values larger than what is left of the buffer are fetched separately
+func TestMultiGetLarge(t *testing.T) {
+	kvs := makeAndOpenKvs(multiTestKvsName, params{})
+	defer kvdb.KvsDrop(multiTestKvsName)
+	defer kvs.Close()
+
+	var keys [][]byte
+	var pairs []KeyValue
+	for i := 0; i < 5; i++ {
+		value := bytes.Repeat([]byte{byte(i)}, BLOB_CHUNK_SIZE/2+1)
+		pairs = append(pairs, KeyValue{Key: []byte(fmt.Sprintf("key%d", i)), Value: value})
+		keys = append(keys, pairs[i].Key)
+	}
+
+	if errs := kvs.MultiPut(pairs, 0); errs != nil {
+		t.Fatalf("failed to put: %v", errs)
+	}
+
+	values, errs := kvs.MultiGet(keys, 0)
+	if errs != nil {
+		t.Fatalf("failed to get: %v", errs)
+	}
+	for i := range pairs {
+		if !bytes.Equal(values[i], pairs[i].Value) {
+			t.Fatalf("value of %s differs", keys[i])
+		}
+	}
+}
+
+func benchmarkPairs(n int) []KeyValue {
+	pairs := make([]KeyValue, n)
+	for i := range pairs {
+		pairs[i] = KeyValue{Key: []byte(fmt.Sprintf("key%08d", i)), Value: []byte("value")}
+	}
+
+	return pairs
+}
+
+func BenchmarkPut(b *testing.B) {
+	kvs := makeAndOpenKvs(multiTestKvsName, params{})
+	defer kvdb.KvsDrop(multiTestKvsName)
+	defer kvs.Close()
+
+	pairs := benchmarkPairs(multiBatch)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, pair := range pairs {
+			if err := kvs.Put(pair.Key, pair.Value, 0); err != nil {
+				b.Fatalf("failed to put: %s", err)
+			}
+		}
+	}
+}
+
+func BenchmarkMultiPut(b *testing.B) {
+	kvs := makeAndOpenKvs(multiTestKvsName, params{})
+	defer kvdb.KvsDrop(multiTestKvsName)
+	defer kvs.Close()
+
+	pairs := benchmarkPairs(multiBatch)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if errs := kvs.MultiPut(pairs, 0); errs != nil {
+			b.Fatalf("failed to put: %v", errs)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	kvs := makeAndOpenKvs(multiTestKvsName, params{})
+	defer kvdb.KvsDrop(multiTestKvsName)
+	defer kvs.Close()
+
+	pairs := benchmarkPairs(multiBatch)
+	if errs := kvs.MultiPut(pairs, 0); errs != nil {
+		b.Fatalf("failed to put: %v", errs)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, pair := range pairs {
+			if _, _, err := kvs.Get(pair.Key, 0); err != nil {
+				b.Fatalf("failed to get: %s", err)
+			}
+		}
+	}
+}
+
+func BenchmarkMultiGet(b *testing.B) {
+	kvs := makeAndOpenKvs(multiTestKvsName, params{})
+	defer kvdb.KvsDrop(multiTestKvsName)
+	defer kvs.Close()
+
+	pairs := benchmarkPairs(multiBatch)
+	if errs := kvs.MultiPut(pairs, 0); errs != nil {
+		b.Fatalf("failed to put: %v", errs)
+	}
+
+	keys := make([][]byte, len(pairs))
+	for i := range pairs {
+		keys[i] = pairs[i].Key
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, errs := kvs.MultiGet(keys, 0); errs != nil {
+			b.Fatalf("failed to get: %v", errs)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bytes"
+	"context"
+)
+
+// DELETE_RANGE_BATCH is the number of keys Kvs.DeleteRange() deletes per
+// transaction
+const DELETE_RANGE_BATCH = 1024
+
+// DeleteRange deletes all keys in the range [start, end) from the Kvs
+//
+// A nil start deletes from the first key and a nil end deletes through the last
+// key. Keys are found with Cursor.SeekRange() and deleted in transactions of
+// DELETE_RANGE_BATCH keys, so the range is not deleted atomically. The number
+// of deleted keys is returned, including when an error interrupts the deletion.
+// Every batch which was committed stays deleted, so calling DeleteRange() again
+// with the same range resumes the deletion. The Kvs must be opened with
+// "transactions.enabled=true". This function is thread safe.
+func (k *Kvs) DeleteRange(start []byte, end []byte) (uint64, error) {
+	return k.DeleteRangeContext(context.Background(), start, end, DELETE_RANGE_BATCH)
+}
+
+// DeleteRangeContext deletes all keys in the range [start, end) from the Kvs in
+// transactions of batch keys
+//
+// ctx is checked between batches. See Kvs.DeleteRange().
+func (k *Kvs) DeleteRangeContext(ctx context.Context, start []byte, end []byte, batch int) (uint64, error) {
+	var deleted uint64
+
+	if batch <= 0 {
+		batch = DELETE_RANGE_BATCH
+	}
+
+	from := start
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		var keys [][]byte
+
+		err := k.kvdb.Transact(func(txn *Transaction) error {
+			var err error
+
+			if keys, err = k.rangeBatch(txn, from, end, batch); err != nil {
+				return err
+			}
+
+			for _, key := range keys {
+				if err = txn.Delete(k, key, 0); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += uint64(len(keys))
+
+		if len(keys) < batch {
+			return deleted, nil
+		}
+
+		// Start the next batch after the last deleted key rather than
+		// skipping over its tombstone
+		from = append(keys[len(keys)-1], 0)
+	}
+}
+
+// rangeBatch reads up to n keys of the range [from, end) in the snapshot of txn
+func (k *Kvs) rangeBatch(txn *Transaction, from []byte, end []byte, n int) ([][]byte, error) {
+	c, err := txn.CreateCursor(k, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Destroy()
+
+	if err = c.seekRange(from, end); err != nil {
+		return nil, err
+	}
+
+	keys := make([][]byte, 0, n)
+	err = c.readRange(end, n, func(key []byte, value []byte) bool {
+		keys = append(keys, append([]byte(nil), key...))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// seekRange positions the cursor at the first key of the range [from, end)
+//
+// A nil from or end leaves the range open on that side.
+func (c *Cursor) seekRange(from []byte, end []byte) error {
+	var err error
+
+	switch {
+	case end != nil:
+		_, err = c.SeekRange(from, end, 0)
+	case from != nil:
+		_, err = c.Seek(from, 0)
+	}
+
+	return err
+}
+
+// readRange calls fn with the KV pairs of the cursor until EOF, end is passed
+// or fn returns false
+//
+// At most max pairs are read, in batches with ReadBatch(), or every pair of the
+// range if max is 0. key and value are only valid until fn returns.
+func (c *Cursor) readRange(end []byte, max int, fn func(key []byte, value []byte) bool) error {
+	bufp := cursorBatchBufs.Get().(*[]byte)
+	defer cursorBatchBufs.Put(bufp)
+
+	for read := 0; max == 0 || read < max; {
+		n := CURSOR_BATCH_LEN
+		if max != 0 && max-read < n {
+			n = max - read
+		}
+
+		pairs, err := c.ReadBatch(n, *bufp, 0)
+		for _, pair := range pairs {
+			if end != nil && bytes.Compare(pair.Key, end) >= 0 {
+				return nil
+			}
+			if !fn(pair.Key, pair.Value) {
+				return nil
+			}
+		}
+		if err != nil || c.Eof() {
+			return err
+		}
+
+		read += len(pairs)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+const (
+	rangeTestKvsName = "range-test"
+)
+
+func rangeTestKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%03d", i))
+}
+
+func rangeTestPut(t *testing.T, kvs *Kvs, n int) {
+	err := kvdb.Transact(func(txn *Transaction) error {
+		for i := 0; i < n; i++ {
+			if err := txn.Put(kvs, rangeTestKey(i), []byte("value"), 0); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+}
+
+func rangeTestExists(t *testing.T, kvs *Kvs, i int) bool {
+	value, _, err := kvs.Get(rangeTestKey(i), 0)
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	return value != nil
+}
+
+func TestDeleteRange(t *testing.T) {
+	kvs := makeAndOpenKvs(rangeTestKvsName, txnParams)
+	defer kvdb.KvsDrop(rangeTestKvsName)
+	defer kvs.Close()
+
+	rangeTestPut(t, kvs, 100)
+
+	deleted, err := kvs.DeleteRangeContext(context.Background(), rangeTestKey(10), rangeTestKey(50), 7)
+	if err != nil {
+		t.Fatalf("failed to delete range: %s", err)
+	}
+	if deleted != 40 {
+		t.Fatalf("deleted %d keys, expected 40", deleted)
+	}
+
+	for i := 0; i < 100; i++ {
+		if exists := rangeTestExists(t, kvs, i); exists != (i < 10 || i >= 50) {
+			t.Fatalf("%s exists: %t", rangeTestKey(i), exists)
+		}
+	}
+
+	if deleted, err = kvs.DeleteRange(nil, rangeTestKey(10)); err != nil || deleted != 10 {
+		t.Fatalf("deleted %d keys before key010: %v", deleted, err)
+	}
+	if deleted, err = kvs.DeleteRange(nil, nil); err != nil || deleted != 50 {
+		t.Fatalf("deleted %d remaining keys: %v", deleted, err)
+	}
+}
+
+func TestDeleteRangeResume(t *testing.T) {
+	kvs := makeAndOpenKvs(rangeTestKvsName, txnParams)
+	defer kvdb.KvsDrop(rangeTestKvsName)
+	defer kvs.Close()
+
+	rangeTestPut(t, kvs, 20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	deleted, err := kvs.DeleteRangeContext(ctx, nil, nil, 5)
+	if err != context.Canceled || deleted != 0 {
+		t.Fatalf("deleted %d keys with a canceled context: %v", deleted, err)
+	}
+
+	deleted, err = kvs.DeleteRange(rangeTestKey(5), nil)
+	if err != nil || deleted != 15 {
+		t.Fatalf("deleted %d keys from key005: %v", deleted, err)
+	}
+
+	// Deleting the same range again finds nothing left to delete
+	if deleted, err = kvs.DeleteRange(rangeTestKey(5), nil); err != nil || deleted != 0 {
+		t.Fatalf("deleted %d keys when resuming a finished deletion: %v", deleted, err)
+	}
+}
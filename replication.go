@@ -0,0 +1,955 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// REPLICA_KVS_NAME is the name of the Kvs which holds the replication state of
+// a follower
+const REPLICA_KVS_NAME = "hse-go-replica"
+
+const (
+	// REPLICATION_VERSION is the version of the replication protocol
+	REPLICATION_VERSION uint32 = 1
+	// REPLICATION_BATCH is the maximum number of log records read for a
+	// follower at once. They are sent in as many messages as their size
+	// requires.
+	REPLICATION_BATCH = 256
+	// REPLICATION_POLL_INTERVAL is how often a primary which has sent every
+	// log record checks for new ones
+	REPLICATION_POLL_INTERVAL = 10 * time.Millisecond
+	// REPLICATION_HEARTBEAT_INTERVAL is how often an idle primary tells its
+	// followers the last sequence number of its log
+	REPLICATION_HEARTBEAT_INTERVAL = 100 * time.Millisecond
+)
+
+var (
+	// ErrReplicaBehind is returned when a follower needs log records which the
+	// primary has truncated. The follower must be bootstrapped again into an
+	// empty Kvdb.
+	ErrReplicaBehind = errors.New("hse: replica needs truncated log records")
+	// ErrReplicaDiverged is returned when a follower has applied log records
+	// which the primary does not have
+	ErrReplicaDiverged = errors.New("hse: replica is ahead of the primary")
+	// ErrReplicaNotEmpty is returned when creating a follower for a Kvdb which
+	// holds Kvs but was never bootstrapped
+	ErrReplicaNotEmpty = errors.New("hse: replica kvdb is not empty")
+	// ErrReplicationProtocol is returned when a peer sends an unexpected
+	// message
+	ErrReplicationProtocol = errors.New("hse: replication protocol error")
+)
+
+// replicationErrors are the errors a primary can report to a follower, which
+// are sent by message
+var replicationErrors = []error{
+	ErrCdcDisabled,
+	ErrReplicaBehind,
+	ErrReplicaDiverged,
+	ErrReplicationProtocol,
+}
+
+// Replication messages are framed as type(u8) length(u32) payload. All
+// integers are big-endian.
+//
+//	hello:     magic[8] version(u32) bootstrapped(u8) applied(u64)
+//	ack:       applied(u64)
+//	snapshot:  seq(u64)
+//	data:      backup bytes
+//	end:
+//	records:   last(u64) (seq(u64) length(u32) record length(u32) value)*
+//	heartbeat: last(u64)
+//	error:     message
+//
+// A follower sends hello once and then an ack after applying records. A
+// primary answers hello with an error, or with a snapshot of the Kvdb in the
+// format written by Kvdb.Backup() split over data messages if the follower is
+// not bootstrapped, followed by records and heartbeats. Records are encoded as
+// in the change data capture log, each followed by its value, which is empty
+// unless the record is a put.
+const (
+	frameHello byte = iota + 1
+	frameAck
+	frameSnapshot
+	frameData
+	frameEnd
+	frameRecords
+	frameHeartbeat
+	frameError
+)
+
+const (
+	replicationFrameMax = 1 << 28
+	replicationHelloLen = 21
+
+	replicaAppliedKey   = "applied"
+	replicaBootstrapKey = "bootstrap"
+)
+
+var replicationMagic = [8]byte{'H', 'S', 'E', 'G', 'O', 'R', 'E', 'P'}
+
+// systemKvs reports whether name is a Kvs used internally by this package,
+// whose mutations are neither published nor logged
+func systemKvs(name string) bool {
+	return name == CDC_KVS_NAME || name == REPLICA_KVS_NAME
+}
+
+type replicationConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func newReplicationConn(conn net.Conn) *replicationConn {
+	return &replicationConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+func (c *replicationConn) write(typ byte, payload []byte) error {
+	var header [5]byte
+
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := c.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(payload); err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+func (c *replicationConn) writeUint64(typ byte, v uint64) error {
+	var buf [8]byte
+
+	binary.BigEndian.PutUint64(buf[:], v)
+
+	return c.write(typ, buf[:])
+}
+
+// fail reports err to the follower and returns it
+func (c *replicationConn) fail(err error) error {
+	c.write(frameError, []byte(err.Error()))
+
+	return err
+}
+
+func (c *replicationConn) read() (byte, []byte, error) {
+	var header [5]byte
+
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > replicationFrameMax {
+		return 0, nil, ErrReplicationProtocol
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
+
+// snapshotWriter sends the bytes written to it as data messages
+type snapshotWriter struct {
+	c *replicationConn
+}
+
+func (w snapshotWriter) Write(p []byte) (int, error) {
+	if err := w.c.write(frameData, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// snapshotReader reads data messages until the end of a snapshot
+type snapshotReader struct {
+	c   *replicationConn
+	buf []byte
+	eof bool
+}
+
+func (r *snapshotReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		typ, payload, err := r.c.read()
+		if err != nil {
+			return 0, err
+		}
+
+		switch typ {
+		case frameData:
+			r.buf = payload
+		case frameEnd:
+			r.eof = true
+		default:
+			return 0, ErrReplicationProtocol
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+// encodedRecordLen returns the length of a record in a records message
+func encodedRecordLen(r *CdcRecord) int {
+	return 12 + 3 + len(r.Kvs) + len(r.Key) + 4 + len(r.Value)
+}
+
+// splitRecords returns how many of the leading records fit in a records
+// message of at most max bytes, which is at least one
+func splitRecords(records []CdcRecord, max int) int {
+	size := 8
+	for i := range records {
+		if size += encodedRecordLen(&records[i]); size > max && i > 0 {
+			return i
+		}
+	}
+
+	return len(records)
+}
+
+func encodeRecords(last uint64, records []CdcRecord) []byte {
+	size := 8
+	for i := range records {
+		size += encodedRecordLen(&records[i])
+	}
+
+	buf := make([]byte, 8, size)
+	binary.BigEndian.PutUint64(buf, last)
+
+	for i := range records {
+		r := &records[i]
+		record := encodeCdcRecord(&Change{Kvs: r.Kvs, Op: r.Op, Key: r.Key}, r.Last)
+
+		var header [12]byte
+		binary.BigEndian.PutUint64(header[:], r.Seq)
+		binary.BigEndian.PutUint32(header[8:], uint32(len(record)))
+
+		buf = append(buf, header[:]...)
+		buf = append(buf, record...)
+
+		var valueLen [4]byte
+		binary.BigEndian.PutUint32(valueLen[:], uint32(len(r.Value)))
+
+		buf = append(buf, valueLen[:]...)
+		buf = append(buf, r.Value...)
+	}
+
+	return buf
+}
+
+func decodeRecords(payload []byte) (uint64, []CdcRecord, error) {
+	if len(payload) < 8 {
+		return 0, nil, ErrReplicationProtocol
+	}
+
+	last := binary.BigEndian.Uint64(payload)
+	payload = payload[8:]
+
+	var records []CdcRecord
+	for len(payload) > 0 {
+		if len(payload) < 12 {
+			return 0, nil, ErrReplicationProtocol
+		}
+
+		seq := binary.BigEndian.Uint64(payload)
+		n := binary.BigEndian.Uint32(payload[8:])
+		payload = payload[12:]
+		if uint64(len(payload)) < uint64(n) {
+			return 0, nil, ErrReplicationProtocol
+		}
+
+		record, err := decodeCdcRecord(cdcRecordKey(seq), payload[:n])
+		if err != nil {
+			return 0, nil, err
+		}
+		payload = payload[n:]
+
+		if len(payload) < 4 {
+			return 0, nil, ErrReplicationProtocol
+		}
+		n = binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+		if uint64(len(payload)) < uint64(n) {
+			return 0, nil, ErrReplicationProtocol
+		}
+		if record.Op == CHANGE_PUT {
+			record.Value = append([]byte{}, payload[:n]...)
+		}
+		payload = payload[n:]
+
+		records = append(records, record)
+	}
+
+	return last, records, nil
+}
+
+// closeOnDone closes conn if ctx is done before the returned function is
+// called
+func closeOnDone(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Primary serves the change data capture log of a Kvdb to followers
+type Primary struct {
+	kvdb *Kvdb
+
+	mu       sync.Mutex
+	replicas map[*replica]struct{}
+}
+
+type replica struct {
+	// applied is accessed atomically
+	applied uint64
+	addr    string
+}
+
+// ReplicaStatus describes a follower served by a Primary
+type ReplicaStatus struct {
+	// Addr is the remote address of the follower's connection
+	Addr string
+	// Applied is the sequence number of the last log record the follower has
+	// acknowledged applying
+	Applied uint64
+	// Lag is the number of committed log records the follower has not
+	// acknowledged applying
+	Lag uint64
+}
+
+// NewPrimary creates a Primary which replicates the Kvdb to followers
+//
+// The change data capture log must be enabled with EnableCdc() while
+// followers are served. Log records are never truncated by the Primary;
+// CdcTruncate() may be called with the lowest sequence number applied by
+// every follower, as reported by Replicas().
+func (k *Kvdb) NewPrimary() *Primary {
+	return &Primary{
+		kvdb:     k,
+		replicas: make(map[*replica]struct{}),
+	}
+}
+
+// Serve replicates the Kvdb to the follower at the other end of conn
+//
+// A follower which has not been bootstrapped is first sent a consistent
+// snapshot of every Kvs along with the sequence number of the last log record
+// it includes. The log records following the follower's position are then
+// sent as they are committed. Serve returns when ctx is done or the
+// connection fails, and closes conn. This function is thread safe.
+func (p *Primary) Serve(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	err := p.serve(ctx, newReplicationConn(conn))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (p *Primary) serve(ctx context.Context, c *replicationConn) error {
+	typ, payload, err := c.read()
+	if err != nil {
+		return err
+	}
+
+	if typ != frameHello || len(payload) != replicationHelloLen ||
+		!bytes.Equal(payload[:8], replicationMagic[:]) ||
+		binary.BigEndian.Uint32(payload[8:]) != REPLICATION_VERSION {
+		return c.fail(ErrReplicationProtocol)
+	}
+
+	bootstrapped := payload[12] != 0
+	applied := binary.BigEndian.Uint64(payload[13:])
+
+	cdc := p.kvdb.cdc
+	if cdc == nil {
+		return c.fail(ErrCdcDisabled)
+	}
+
+	if bootstrapped {
+		truncated, err := cdcTruncatedSeq(cdc.kvs)
+		if err != nil {
+			return c.fail(err)
+		}
+		if applied < truncated {
+			return c.fail(ErrReplicaBehind)
+		}
+		if applied > cdc.last() {
+			return c.fail(ErrReplicaDiverged)
+		}
+	} else if applied, err = p.snapshot(ctx, c); err != nil {
+		return err
+	}
+
+	r := &replica{applied: applied, addr: c.conn.RemoteAddr().String()}
+
+	p.mu.Lock()
+	p.replicas[r] = struct{}{}
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.replicas, r)
+		p.mu.Unlock()
+	}()
+
+	acks := make(chan error, 1)
+	go func() {
+		acks <- r.readAcks(c)
+	}()
+
+	reader := p.kvdb.NewCdcReader(applied)
+	heartbeat := time.Now()
+
+	for {
+		records, err := reader.Read(REPLICATION_BATCH)
+		if err != nil {
+			return c.fail(err)
+		}
+
+		if len(records) > 0 {
+			last := cdc.last()
+			for len(records) > 0 {
+				n := splitRecords(records, replicationFrameMax)
+				if err = c.write(frameRecords, encodeRecords(last, records[:n])); err != nil {
+					return err
+				}
+				records = records[n:]
+			}
+			heartbeat = time.Now()
+			continue
+		}
+
+		if time.Since(heartbeat) >= REPLICATION_HEARTBEAT_INTERVAL {
+			if err = c.writeUint64(frameHeartbeat, cdc.last()); err != nil {
+				return err
+			}
+			heartbeat = time.Now()
+		}
+
+		select {
+		case err = <-acks:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(REPLICATION_POLL_INTERVAL):
+		}
+	}
+}
+
+// snapshot sends a snapshot of the Kvdb and returns the sequence number of the
+// last log record it includes
+func (p *Primary) snapshot(ctx context.Context, c *replicationConn) (uint64, error) {
+	names, err := p.kvdb.KvsNames()
+	if err != nil {
+		return 0, c.fail(err)
+	}
+
+	user := names[:0]
+	for _, name := range names {
+		if !systemKvs(name) {
+			user = append(user, name)
+		}
+	}
+
+	txn := p.kvdb.NewTransaction()
+	if txn == nil {
+		return 0, c.fail(syscall.ENOMEM)
+	}
+	defer txn.Free()
+
+	seq, err := p.kvdb.cdc.begin(txn)
+	if err != nil {
+		return 0, c.fail(err)
+	}
+	defer txn.Abort()
+
+	if err = c.writeUint64(frameSnapshot, seq); err != nil {
+		return 0, err
+	}
+	if err = p.kvdb.backupTxn(ctx, txn, user, snapshotWriter{c: c}); err != nil {
+		return 0, err
+	}
+
+	return seq, c.write(frameEnd, nil)
+}
+
+func (r *replica) readAcks(c *replicationConn) error {
+	for {
+		typ, payload, err := c.read()
+		if err != nil {
+			return err
+		}
+		if typ != frameAck || len(payload) != 8 {
+			return ErrReplicationProtocol
+		}
+
+		atomic.StoreUint64(&r.applied, binary.BigEndian.Uint64(payload))
+	}
+}
+
+// Replicas returns the status of the followers being served
+//
+// This function is thread safe.
+func (p *Primary) Replicas() []ReplicaStatus {
+	var last uint64
+	if cdc := p.kvdb.cdc; cdc != nil {
+		last = cdc.last()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := make([]ReplicaStatus, 0, len(p.replicas))
+	for r := range p.replicas {
+		s := ReplicaStatus{Addr: r.addr, Applied: atomic.LoadUint64(&r.applied)}
+		if last > s.Applied {
+			s.Lag = last - s.Applied
+		}
+
+		status = append(status, s)
+	}
+
+	return status
+}
+
+// Follower applies the change data capture log of a primary Kvdb to a Kvdb
+type Follower struct {
+	// applied and last are accessed atomically
+	applied uint64
+	last    uint64
+
+	kvdb         *Kvdb
+	state        *Kvs
+	bootstrapped bool
+	kvs          map[string]*Kvs
+	owned        []*Kvs
+	pending      []CdcRecord
+}
+
+// NewFollower creates a Follower which replicates a primary into the Kvdb
+//
+// The position of the follower is kept in the REPLICA_KVS_NAME Kvs, which is
+// created if needed, so that replication resumes where it left off. A Kvdb
+// which has never been bootstrapped must not hold any other Kvs. Kvs created
+// on the primary after the snapshot are created with default parameters, and
+// Kvs dropped on the primary are not dropped. Records are applied within
+// transactions, so a Kvs the application has open while the follower runs must
+// be opened with "transactions.enabled=true". This function is not thread
+// safe.
+func (k *Kvdb) NewFollower() (*Follower, error) {
+	names, err := k.KvsNames()
+	if err != nil {
+		return nil, err
+	}
+
+	exists, empty := false, true
+	for _, name := range names {
+		exists = exists || name == REPLICA_KVS_NAME
+		empty = empty && systemKvs(name)
+	}
+
+	if !exists {
+		if err = k.KvsCreate(REPLICA_KVS_NAME); err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := k.KvsOpen(REPLICA_KVS_NAME, "transactions.enabled=true")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Follower{kvdb: k, state: state, kvs: make(map[string]*Kvs)}
+
+	applied, _, err := state.Get([]byte(replicaAppliedKey), 0)
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
+
+	if len(applied) == 8 {
+		f.bootstrapped = true
+		f.applied = binary.BigEndian.Uint64(applied)
+		f.last = f.applied
+
+		return f, nil
+	}
+
+	// The Kvs of an interrupted bootstrap are dropped when it is retried
+	marker, _, err := state.Get([]byte(replicaBootstrapKey), 0)
+	if err == nil && len(marker) == 0 && !empty {
+		err = ErrReplicaNotEmpty
+	}
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Close closes the Kvs handles opened by the follower
+//
+// This function is not thread safe.
+func (f *Follower) Close() error {
+	err := f.closeKvs()
+
+	if rc := f.state.Close(); err == nil {
+		err = rc
+	}
+
+	return err
+}
+
+func (f *Follower) closeKvs() error {
+	var err error
+
+	for _, kvs := range f.owned {
+		if rc := kvs.Close(); err == nil {
+			err = rc
+		}
+	}
+
+	f.owned = nil
+	f.kvs = make(map[string]*Kvs)
+
+	return err
+}
+
+// Applied returns the sequence number of the last log record of the primary
+// which has been applied
+//
+// This function is thread safe.
+func (f *Follower) Applied() uint64 {
+	return atomic.LoadUint64(&f.applied)
+}
+
+// Lag returns the number of log records the primary was last known to have
+// committed which have not been applied
+//
+// This function is thread safe.
+func (f *Follower) Lag() uint64 {
+	applied, last := f.Applied(), atomic.LoadUint64(&f.last)
+	if last <= applied {
+		return 0
+	}
+
+	return last - applied
+}
+
+// Run replicates the primary at the other end of conn into the Kvdb
+//
+// The follower is bootstrapped from a snapshot of the primary if it never has
+// been, then applies the primary's log records as they arrive. The records of
+// each transaction on the primary are applied in one transaction together
+// with the follower's position, so the follower always holds the result of a
+// prefix of the primary's commits. Run returns when ctx is done or the
+// connection fails, and closes conn. It may be called again with a new
+// connection to resume. This function is not thread safe.
+func (f *Follower) Run(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	err := f.run(ctx, newReplicationConn(conn))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (f *Follower) run(ctx context.Context, c *replicationConn) error {
+	f.pending = nil
+
+	hello := make([]byte, replicationHelloLen)
+	copy(hello, replicationMagic[:])
+	binary.BigEndian.PutUint32(hello[8:], REPLICATION_VERSION)
+	if f.bootstrapped {
+		hello[12] = 1
+	}
+	binary.BigEndian.PutUint64(hello[13:], f.Applied())
+
+	if err := c.write(frameHello, hello); err != nil {
+		return err
+	}
+
+	for {
+		typ, payload, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case frameSnapshot:
+			if f.bootstrapped || len(payload) != 8 {
+				return ErrReplicationProtocol
+			}
+			err = f.bootstrap(ctx, c, binary.BigEndian.Uint64(payload))
+		case frameRecords:
+			var last uint64
+			var records []CdcRecord
+
+			if last, records, err = decodeRecords(payload); err != nil {
+				return err
+			}
+			atomic.StoreUint64(&f.last, last)
+
+			if err = f.apply(records); err == nil {
+				err = c.writeUint64(frameAck, f.Applied())
+			}
+		case frameHeartbeat:
+			if len(payload) != 8 {
+				return ErrReplicationProtocol
+			}
+			atomic.StoreUint64(&f.last, binary.BigEndian.Uint64(payload))
+		case frameError:
+			return replicationError(string(payload))
+		default:
+			return ErrReplicationProtocol
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func replicationError(message string) error {
+	for _, err := range replicationErrors {
+		if err.Error() == message {
+			return err
+		}
+	}
+
+	return errors.New(message)
+}
+
+// bootstrap restores the snapshot of the primary which includes the log
+// records up to seq
+func (f *Follower) bootstrap(ctx context.Context, c *replicationConn, seq uint64) error {
+	err := f.kvdb.Transact(func(txn *Transaction) error {
+		return txn.Put(f.state, []byte(replicaBootstrapKey), []byte{1}, 0)
+	})
+	if err != nil {
+		return err
+	}
+	if err := f.dropKvs(); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(&snapshotReader{c: c})
+
+	count, err := readBackupHeader(r)
+	if err != nil {
+		return err
+	}
+	if err = f.kvdb.restore(ctx, r, count); err != nil {
+		return err
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+
+	applied := make([]byte, 8)
+	binary.BigEndian.PutUint64(applied, seq)
+
+	err = f.kvdb.Transact(func(txn *Transaction) error {
+		if err := txn.Put(f.state, []byte(replicaAppliedKey), applied, 0); err != nil {
+			return err
+		}
+		return txn.Delete(f.state, []byte(replicaBootstrapKey), 0)
+	})
+	if err != nil {
+		return err
+	}
+
+	f.bootstrapped = true
+	atomic.StoreUint64(&f.applied, seq)
+	if seq > atomic.LoadUint64(&f.last) {
+		atomic.StoreUint64(&f.last, seq)
+	}
+
+	return nil
+}
+
+// dropKvs drops every Kvs but the system ones before a bootstrap
+func (f *Follower) dropKvs() error {
+	if err := f.closeKvs(); err != nil {
+		return err
+	}
+
+	names, err := f.kvdb.KvsNames()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if systemKvs(name) {
+			continue
+		}
+		if err = f.kvdb.KvsDrop(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// open returns a handle for the named Kvs, creating the Kvs if needed
+func (f *Follower) open(name string) (*Kvs, error) {
+	if kvs := f.kvs[name]; kvs != nil {
+		return kvs, nil
+	}
+
+	// Share a handle the application has open, since a Kvs cannot be opened
+	// twice
+	kvs := f.kvdb.KvsHandle(name)
+	if kvs == nil {
+		names, err := f.kvdb.KvsNames()
+		if err != nil {
+			return nil, err
+		}
+
+		exists := false
+		for _, n := range names {
+			exists = exists || n == name
+		}
+
+		if !exists {
+			if err = f.kvdb.KvsCreate(name); err != nil {
+				return nil, err
+			}
+		}
+
+		if kvs, err = f.kvdb.KvsOpen(name, "transactions.enabled=true"); err != nil {
+			return nil, err
+		}
+		f.owned = append(f.owned, kvs)
+	}
+
+	f.kvs[name] = kvs
+
+	return kvs, nil
+}
+
+// apply applies the records of every complete transaction received so far
+//
+// Each transaction of the primary is applied in a transaction of its own, since
+// a prefix delete within a transaction takes effect as of the start of the
+// transaction and would also delete keys put by earlier transactions in the
+// same one.
+func (f *Follower) apply(records []CdcRecord) error {
+	f.pending = append(f.pending, records...)
+
+	start := 0
+	for i := range f.pending {
+		if !f.pending[i].Last {
+			continue
+		}
+
+		if err := f.applyTxn(f.pending[start : i+1]); err != nil {
+			f.pending = append([]CdcRecord(nil), f.pending[start:]...)
+			return err
+		}
+		start = i + 1
+	}
+
+	f.pending = append([]CdcRecord(nil), f.pending[start:]...)
+
+	return nil
+}
+
+// applyTxn applies the records of one transaction of the primary along with
+// the sequence number of its last record
+func (f *Follower) applyTxn(batch []CdcRecord) error {
+	for i := range batch {
+		if _, err := f.open(batch[i].Kvs); err != nil {
+			return err
+		}
+	}
+
+	seq := batch[len(batch)-1].Seq
+	applied := make([]byte, 8)
+	binary.BigEndian.PutUint64(applied, seq)
+
+	err := f.kvdb.Transact(func(txn *Transaction) error {
+		for i := range batch {
+			r := &batch[i]
+			kvs := f.kvs[r.Kvs]
+
+			var err error
+			switch r.Op {
+			case CHANGE_PUT:
+				err = txn.Put(kvs, r.Key, r.Value, 0)
+			case CHANGE_DELETE:
+				err = txn.Delete(kvs, r.Key, 0)
+			case CHANGE_PREFIX_DELETE:
+				err = txn.PrefixDelete(kvs, r.Key, 0)
+			default:
+				err = ErrCdcRecord
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return txn.Put(f.state, []byte(replicaAppliedKey), applied, 0)
+	})
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreUint64(&f.applied, seq)
+
+	return nil
+}
@@ -0,0 +1,272 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	replicationTestKvsName = "replication-test"
+)
+
+func makeReplicaKvdb(t *testing.T) (*Kvdb, func()) {
+	home, err := ioutil.TempDir("", "hse-go-replica")
+	if err != nil {
+		t.Fatalf("failed to create replica directory: %s", err)
+	}
+
+	if err = KvdbCreate(home); err != nil {
+		os.RemoveAll(home)
+		t.Fatalf("failed to create replica kvdb: %s", err)
+	}
+
+	replica, err := KvdbOpen(home, nil)
+	if err != nil {
+		os.RemoveAll(home)
+		t.Fatalf("failed to open replica kvdb: %s", err)
+	}
+
+	return replica, func() {
+		replica.Close()
+		os.RemoveAll(home)
+	}
+}
+
+// replicate serves the primary to the follower until the returned function is
+// called, which returns the errors of both ends
+func replicate(primary *Primary, follower *Follower) func() (error, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p, f := net.Pipe()
+
+	primaryErr, followerErr := make(chan error, 1), make(chan error, 1)
+	go func() { primaryErr <- primary.Serve(ctx, p) }()
+	go func() { followerErr <- follower.Run(ctx, f) }()
+
+	return func() (error, error) {
+		cancel()
+		return <-primaryErr, <-followerErr
+	}
+}
+
+func waitApplied(t *testing.T, follower *Follower, seq uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for follower.Applied() < seq || follower.Lag() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower applied %d with lag %d, expected %d", follower.Applied(), follower.Lag(), seq)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func replicaValue(t *testing.T, kvs *Kvs, key string) []byte {
+	value, _, err := kvs.Get([]byte(key), 0)
+	if err != nil {
+		t.Fatalf("failed to get %s from the replica: %s", key, err)
+	}
+
+	return value
+}
+
+func TestReplication(t *testing.T) {
+	kvs := makeAndOpenKvs(replicationTestKvsName, txnParams)
+	defer kvdb.KvsDrop(replicationTestKvsName)
+	defer kvs.Close()
+
+	if err := kvdb.EnableCdc(); err != nil {
+		t.Fatalf("failed to enable cdc: %s", err)
+	}
+	defer kvdb.KvsDrop(CDC_KVS_NAME)
+	defer kvdb.DisableCdc()
+
+	if err := kvs.Put([]byte("a"), []byte("1"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	replica, cleanup := makeReplicaKvdb(t)
+	defer cleanup()
+
+	follower, err := replica.NewFollower()
+	if err != nil {
+		t.Fatalf("failed to create follower: %s", err)
+	}
+	defer follower.Close()
+
+	primary := kvdb.NewPrimary()
+
+	// The follower is bootstrapped from a snapshot, then follows the log
+	stop := replicate(primary, follower)
+
+	waitApplied(t, follower, kvdb.cdc.last())
+
+	// The follower shares the handle rather than opening the Kvs again
+	replicaKvs, err := replica.KvsOpen(replicationTestKvsName, txnParams.Rparams...)
+	if err != nil {
+		t.Fatalf("failed to open replicated kvs: %s", err)
+	}
+	defer replicaKvs.Close()
+
+	if value := replicaValue(t, replicaKvs, "a"); string(value) != "1" {
+		t.Fatalf("replica has a=%q after bootstrap, expected 1", value)
+	}
+
+	err = kvdb.Transact(func(txn *Transaction) error {
+		if err := txn.Put(kvs, []byte("b"), []byte("2"), 0); err != nil {
+			return err
+		}
+		return txn.Delete(kvs, []byte("a"), 0)
+	})
+	if err != nil {
+		t.Fatalf("failed to run transaction: %s", err)
+	}
+
+	waitApplied(t, follower, kvdb.cdc.last())
+	if value := replicaValue(t, replicaKvs, "a"); value != nil {
+		t.Fatalf("replica has deleted key a=%q", value)
+	}
+	if value := replicaValue(t, replicaKvs, "b"); string(value) != "2" {
+		t.Fatalf("replica has b=%q, expected 2", value)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := primary.Replicas()
+		if len(status) != 1 {
+			t.Fatalf("primary has %d replicas, expected 1", len(status))
+		}
+		if status[0].Lag == 0 && status[0].Applied == follower.Applied() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected replica status %+v", status[0])
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if primaryErr, followerErr := stop(); primaryErr != context.Canceled || followerErr != context.Canceled {
+		t.Fatalf("unexpected replication errors: %v, %v", primaryErr, followerErr)
+	}
+	if len(primary.Replicas()) != 0 {
+		t.Fatalf("primary still has replicas after disconnect")
+	}
+
+	// A reconnecting follower resumes from its position
+	if err = kvs.Put([]byte("c"), []byte("3"), 0); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	stop = replicate(primary, follower)
+	waitApplied(t, follower, kvdb.cdc.last())
+	stop()
+
+	if value := replicaValue(t, replicaKvs, "c"); string(value) != "3" {
+		t.Fatalf("replica has c=%q after resuming, expected 3", value)
+	}
+}
+
+func TestReplicationErrors(t *testing.T) {
+	if _, err := kvdb.NewFollower(); err != ErrReplicaNotEmpty {
+		t.Fatalf("created a follower of a kvdb with data: %v", err)
+	}
+	defer kvdb.KvsDrop(REPLICA_KVS_NAME)
+
+	replica, cleanup := makeReplicaKvdb(t)
+	defer cleanup()
+
+	follower, err := replica.NewFollower()
+	if err != nil {
+		t.Fatalf("failed to create follower: %s", err)
+	}
+	defer follower.Close()
+
+	p, f := net.Pipe()
+	go kvdb.NewPrimary().Serve(context.Background(), p)
+
+	if err = follower.Run(context.Background(), f); err != ErrCdcDisabled {
+		t.Fatalf("replicated without cdc: %v", err)
+	}
+}
+
+// A prefix delete only deletes the keys put by earlier transactions of the
+// primary, even when they arrive together
+func TestReplicationApply(t *testing.T) {
+	replica, cleanup := makeReplicaKvdb(t)
+	defer cleanup()
+
+	follower, err := replica.NewFollower()
+	if err != nil {
+		t.Fatalf("failed to create follower: %s", err)
+	}
+	defer follower.Close()
+
+	records := []CdcRecord{
+		{Seq: 1, Kvs: replicationTestKvsName, Op: CHANGE_PUT, Key: []byte("px1"), Value: []byte("1"), Last: true},
+		{Seq: 2, Kvs: replicationTestKvsName, Op: CHANGE_PREFIX_DELETE, Key: []byte("px")},
+		{Seq: 3, Kvs: replicationTestKvsName, Op: CHANGE_PUT, Key: []byte("px2"), Value: []byte("2"), Last: true},
+		{Seq: 4, Kvs: replicationTestKvsName, Op: CHANGE_PUT, Key: []byte("px3"), Value: []byte("3")},
+	}
+
+	if err = follower.apply(records); err != nil {
+		t.Fatalf("failed to apply: %s", err)
+	}
+	if follower.Applied() != 3 {
+		t.Fatalf("applied %d, expected 3", follower.Applied())
+	}
+
+	kvs := follower.kvs[replicationTestKvsName]
+	if value := replicaValue(t, kvs, "px1"); value != nil {
+		t.Fatalf("replica kept px1=%q put before the prefix delete", value)
+	}
+	if value := replicaValue(t, kvs, "px2"); string(value) != "2" {
+		t.Fatalf("replica has px2=%q, expected 2", value)
+	}
+	if value := replicaValue(t, kvs, "px3"); value != nil {
+		t.Fatalf("replica applied px3=%q of an incomplete transaction", value)
+	}
+	if len(follower.pending) != 1 {
+		t.Fatalf("follower holds %d pending records, expected 1", len(follower.pending))
+	}
+}
+
+func TestReplicationSplit(t *testing.T) {
+	records := make([]CdcRecord, 10)
+	for i := range records {
+		records[i] = CdcRecord{Seq: uint64(i + 1), Kvs: "kvs", Op: CHANGE_PUT, Key: []byte("key"), Value: make([]byte, 100)}
+	}
+
+	const max = 500
+
+	var received []CdcRecord
+	for rest := records; len(rest) > 0; {
+		n := splitRecords(rest, max)
+		payload := encodeRecords(10, rest[:n])
+		if len(payload) > max {
+			t.Fatalf("message of %d bytes exceeds %d", len(payload), max)
+		}
+
+		_, decoded, err := decodeRecords(payload)
+		if err != nil {
+			t.Fatalf("failed to decode records: %s", err)
+		}
+		received = append(received, decoded...)
+		rest = rest[n:]
+	}
+
+	if len(received) != len(records) || received[9].Seq != 10 || len(received[9].Value) != 100 {
+		t.Fatalf("received %d records, expected %d", len(received), len(records))
+	}
+
+	// A record larger than a message is sent on its own
+	if n := splitRecords(records, 10); n != 1 {
+		t.Fatalf("split %d records into an undersized message", n)
+	}
+}
@@ -0,0 +1,630 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	hse "github.com/hse-project/hse-go"
+)
+
+const (
+	errSyntax     = replyError("ERR syntax error")
+	errNotInteger = replyError("ERR value is not an integer or out of range")
+	errOverflow   = replyError("ERR increment or decrement would overflow")
+)
+
+// scanCountDefault is the number of keys a SCAN examines without COUNT
+const scanCountDefault = 10
+
+// command is a Redis command
+type command struct {
+	// arity is the number of arguments including the command name, or its
+	// negation if that is the minimum
+	arity int
+	fn    func(c *client, args [][]byte) (interface{}, error)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":    {-1, (*client).ping},
+		"ECHO":    {2, (*client).echo},
+		"SELECT":  {2, (*client).selectDb},
+		"GET":     {2, (*client).get},
+		"SET":     {-3, (*client).set},
+		"DEL":     {-2, (*client).del},
+		"EXISTS":  {-2, (*client).exists},
+		"MGET":    {-2, (*client).mget},
+		"MSET":    {-3, (*client).mset},
+		"INCR":    {2, (*client).incr},
+		"DECR":    {2, (*client).incr},
+		"INCRBY":  {3, (*client).incr},
+		"DECRBY":  {3, (*client).incr},
+		"SCAN":    {-2, (*client).scan},
+		"COMMAND": {-1, (*client).command},
+	}
+}
+
+// client is the state of a connection
+type client struct {
+	s *Server
+	r *bufio.Reader
+	w *bufio.Writer
+
+	db    int
+	multi bool
+	queue [][][]byte
+	// txn is the transaction of the commands being run, if any
+	txn *hse.Transaction
+
+	scans    map[uint64][]byte
+	nextScan uint64
+}
+
+func (c *client) serve() {
+	for {
+		args, err := readCommand(c.r)
+		if err != nil {
+			if err == errProtocol {
+				writeReply(c.w, replyError("ERR Protocol error"))
+				c.w.Flush()
+			}
+			return
+		}
+
+		if len(args) > 0 {
+			name := strings.ToUpper(string(args[0]))
+			if name == "QUIT" {
+				writeReply(c.w, replyOK)
+				c.w.Flush()
+				return
+			}
+
+			writeReply(c.w, c.handle(name, args))
+		}
+
+		// Replies to pipelined commands are sent together
+		if c.r.Buffered() == 0 {
+			if c.w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+func wrongArgs(name string) replyError {
+	return replyError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// lookup finds the named command and checks its number of arguments
+func lookup(name string, args [][]byte) (command, replyError) {
+	cmd, ok := commands[name]
+	if !ok {
+		return cmd, replyError(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		return cmd, wrongArgs(name)
+	}
+
+	return cmd, ""
+}
+
+// handle runs or queues a command and returns its reply
+func (c *client) handle(name string, args [][]byte) interface{} {
+	switch name {
+	case "MULTI":
+		if c.multi {
+			return replyError("ERR MULTI calls can not be nested")
+		}
+		c.multi = true
+		c.queue = nil
+		return replyOK
+	case "EXEC":
+		if !c.multi {
+			return replyError("ERR EXEC without MULTI")
+		}
+		return c.exec()
+	case "DISCARD":
+		if !c.multi {
+			return replyError("ERR DISCARD without MULTI")
+		}
+		c.multi = false
+		c.queue = nil
+		return replyOK
+	case "WATCH", "UNWATCH":
+		return replyError("ERR WATCH is not supported")
+	}
+
+	cmd, e := lookup(name, args)
+	if e != "" {
+		if c.multi {
+			// A command which cannot be queued fails the transaction
+			c.queue = append(c.queue, nil)
+		}
+		return e
+	}
+
+	if c.multi {
+		c.queue = append(c.queue, args)
+		return replyQueued
+	}
+
+	reply, err := cmd.fn(c, args)
+	if err != nil {
+		return replyError("ERR " + err.Error())
+	}
+
+	return reply
+}
+
+// exec runs the queued commands in one transaction
+func (c *client) exec() interface{} {
+	queue := c.queue
+	c.multi = false
+	c.queue = nil
+
+	for _, args := range queue {
+		if args == nil {
+			return replyError("EXECABORT Transaction discarded because of previous errors.")
+		}
+	}
+
+	db := c.db
+
+	var replies []interface{}
+	err := c.atomic(func() error {
+		// The transaction is retried from the state before EXEC
+		c.db = db
+		replies = make([]interface{}, 0, len(queue))
+
+		for _, args := range queue {
+			cmd, _ := lookup(strings.ToUpper(string(args[0])), args)
+
+			reply, err := cmd.fn(c, args)
+			if err != nil {
+				return err
+			}
+
+			replies = append(replies, reply)
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.db = db
+		return replyError("EXECABORT " + err.Error())
+	}
+
+	return replies
+}
+
+func (c *client) kvs() *hse.Kvs {
+	return c.s.dbs[c.db]
+}
+
+// atomic runs fn in a transaction, unless it is already part of one
+func (c *client) atomic(fn func() error) error {
+	if c.txn != nil {
+		return fn()
+	}
+
+	return c.s.kvdb.Transact(func(txn *hse.Transaction) error {
+		c.txn = txn
+		defer func() { c.txn = nil }()
+
+		return fn()
+	})
+}
+
+func (c *client) load(key []byte) ([]byte, error) {
+	var value []byte
+	var err error
+
+	if c.txn != nil {
+		value, _, err = c.txn.Get(c.kvs(), key, 0)
+	} else {
+		value, _, err = c.kvs().Get(key, 0)
+	}
+
+	return value, err
+}
+
+// store puts a value, within a transaction of its own unless it is already
+// part of one, since the KVSs only accept mutations within transactions
+func (c *client) store(key []byte, value []byte) error {
+	return c.atomic(func() error {
+		return c.txn.Put(c.kvs(), key, value, 0)
+	})
+}
+
+// remove deletes a key like store() puts one
+func (c *client) remove(key []byte) error {
+	return c.atomic(func() error {
+		return c.txn.Delete(c.kvs(), key, 0)
+	})
+}
+
+func (c *client) ping(args [][]byte) (interface{}, error) {
+	switch len(args) {
+	case 1:
+		return simpleString("PONG"), nil
+	case 2:
+		return args[1], nil
+	}
+
+	return wrongArgs("ping"), nil
+}
+
+func (c *client) echo(args [][]byte) (interface{}, error) {
+	return args[1], nil
+}
+
+func (c *client) command(args [][]byte) (interface{}, error) {
+	// Clients such as redis-cli fetch the command table when starting
+	return []interface{}{}, nil
+}
+
+func (c *client) selectDb(args [][]byte) (interface{}, error) {
+	db, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return errNotInteger, nil
+	}
+	if db < 0 || db >= len(c.s.dbs) {
+		return replyError("ERR DB index is out of range"), nil
+	}
+
+	c.db = db
+
+	return replyOK, nil
+}
+
+func (c *client) get(args [][]byte) (interface{}, error) {
+	return c.load(args[1])
+}
+
+func (c *client) set(args [][]byte) (interface{}, error) {
+	var nx, xx bool
+
+	for _, opt := range args[3:] {
+		switch strings.ToUpper(string(opt)) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return errSyntax, nil
+		}
+	}
+	if nx && xx {
+		return errSyntax, nil
+	}
+
+	if !nx && !xx {
+		if err := c.store(args[1], args[2]); err != nil {
+			return nil, err
+		}
+		return replyOK, nil
+	}
+
+	var reply interface{}
+	err := c.atomic(func() error {
+		value, err := c.load(args[1])
+		if err != nil {
+			return err
+		}
+
+		if (nx && value != nil) || (xx && value == nil) {
+			reply = []byte(nil)
+			return nil
+		}
+
+		reply = replyOK
+		return c.store(args[1], args[2])
+	})
+
+	return reply, err
+}
+
+func (c *client) del(args [][]byte) (interface{}, error) {
+	var n int64
+
+	err := c.atomic(func() error {
+		n = 0
+		for _, key := range args[1:] {
+			value, err := c.load(key)
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				continue
+			}
+
+			if err = c.remove(key); err != nil {
+				return err
+			}
+			n++
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+func (c *client) exists(args [][]byte) (interface{}, error) {
+	var n int64
+
+	for _, key := range args[1:] {
+		value, err := c.load(key)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (c *client) mget(args [][]byte) (interface{}, error) {
+	values := make([]interface{}, 0, len(args)-1)
+
+	for _, key := range args[1:] {
+		value, err := c.load(key)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (c *client) mset(args [][]byte) (interface{}, error) {
+	if len(args)%2 != 1 {
+		return wrongArgs("mset"), nil
+	}
+
+	err := c.atomic(func() error {
+		for i := 1; i < len(args); i += 2 {
+			if err := c.store(args[i], args[i+1]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return replyOK, nil
+}
+
+// incr implements INCR, DECR, INCRBY and DECRBY
+func (c *client) incr(args [][]byte) (interface{}, error) {
+	name := strings.ToUpper(string(args[0]))
+
+	delta := int64(1)
+	if len(args) == 3 {
+		var err error
+		if delta, err = strconv.ParseInt(string(args[2]), 10, 64); err != nil {
+			return errNotInteger, nil
+		}
+	}
+	if name == "DECR" || name == "DECRBY" {
+		if delta == math.MinInt64 {
+			return errOverflow, nil
+		}
+		delta = -delta
+	}
+
+	var reply interface{}
+	err := c.atomic(func() error {
+		value, err := c.load(args[1])
+		if err != nil {
+			return err
+		}
+
+		var n int64
+		if value != nil {
+			if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				reply = errNotInteger
+				return nil
+			}
+		}
+
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			reply = errOverflow
+			return nil
+		}
+
+		n += delta
+		reply = n
+
+		return c.store(args[1], []byte(strconv.FormatInt(n, 10)))
+	})
+
+	return reply, err
+}
+
+// scan implements SCAN, whose cursors are IDs of the key to resume from
+func (c *client) scan(args [][]byte) (interface{}, error) {
+	id, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return replyError("ERR invalid cursor"), nil
+	}
+
+	var pattern []byte
+	count := scanCountDefault
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return errSyntax, nil
+		}
+
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil || count < 1 {
+				return errSyntax, nil
+			}
+		default:
+			return errSyntax, nil
+		}
+	}
+
+	var from []byte
+	if id != 0 {
+		var ok bool
+		if from, ok = c.scans[id]; !ok {
+			return replyError("ERR invalid cursor"), nil
+		}
+		delete(c.scans, id)
+	}
+
+	var cursor *hse.Cursor
+	if c.txn != nil {
+		cursor, err = c.txn.CreateCursor(c.kvs(), nil, 0)
+	} else {
+		cursor, err = c.kvs().CreateCursor(nil, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Destroy()
+
+	if from != nil {
+		if _, err = cursor.Seek(from, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := []interface{}{}
+	next := []byte("0")
+
+	n := 0
+	err = cursor.ForEach(func(key []byte, value []byte) error {
+		if n == count {
+			c.nextScan++
+			c.scans[c.nextScan] = append([]byte(nil), key...)
+			delete(c.scans, c.nextScan-SCAN_CURSORS_MAX)
+
+			next = []byte(strconv.FormatUint(c.nextScan, 10))
+			return hse.ErrStopIteration
+		}
+
+		if pattern == nil || match(pattern, key) {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+		n++
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{next, keys}, nil
+}
+
+// match reports whether s matches the Redis glob pattern
+//
+// On a mismatch, only the last '*' seen is backtracked to, letting it consume
+// one more byte, which keeps matching linear in the length of s for each
+// position of that '*' rather than exponential in the number of '*'.
+func match(pattern []byte, s []byte) bool {
+	p, i := 0, 0
+	starP, starI := -1, 0
+
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starI = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				end := bytes.IndexByte(pattern[p+1:], ']')
+				if end >= 0 && matchClass(pattern[p+1:p+1+end], s[i]) {
+					p += end + 2
+					i++
+					continue
+				}
+			case '\\':
+				q := p
+				if q+1 < len(pattern) {
+					q++
+				}
+				if pattern[q] == s[i] {
+					p = q + 1
+					i++
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+
+		if starP < 0 {
+			return false
+		}
+
+		starI++
+		p, i = starP+1, starI
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchClass reports whether b is in a bracketed character class such as
+// "a-z" or "^0-9"
+func matchClass(class []byte, b byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (b >= lo && b <= hi)
+			i += 2
+			continue
+		}
+
+		matched = matched || class[i] == b
+	}
+
+	return matched != negate
+}
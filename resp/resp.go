@@ -0,0 +1,311 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Package resp serves a KVDB over the Redis protocol (RESP2)
+//
+// Each Redis database number selects one KVS of the KVDB, so that redis-cli
+// and Redis client libraries can read and write it. Values are plain strings;
+// the other Redis data types are not supported. The supported commands are
+// GET, SET (with NX or XX), DEL, EXISTS, MGET, MSET, INCR, INCRBY, DECR,
+// DECRBY, SCAN (with MATCH and COUNT), MULTI, EXEC, DISCARD, SELECT, PING,
+// ECHO and QUIT.
+//
+// Commands which touch several keys, such as MSET and DEL, and the commands
+// queued by MULTI are each run in one transaction, which is retried on
+// conflict. WATCH is not supported since conflicting transactions are already
+// detected by HSE.
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	hse "github.com/hse-project/hse-go"
+	"github.com/hse-project/hse-go/limits"
+)
+
+const (
+	// ARGS_MAX is the maximum number of arguments of a command
+	ARGS_MAX = 1024 * 1024
+	// COMMAND_LEN_MAX is the maximum total length in bytes of the arguments of
+	// a command
+	COMMAND_LEN_MAX = 64 * 1024 * 1024
+	// SCAN_CURSORS_MAX is the number of SCAN cursors a connection keeps, after
+	// which the oldest are forgotten
+	SCAN_CURSORS_MAX = 128
+)
+
+// ErrServerClosed is returned by Serve() once the Server is closed
+var ErrServerClosed = errors.New("resp: server closed")
+
+var errProtocol = errors.New("Protocol error")
+
+// simpleString is a reply sent as a RESP simple string
+type simpleString string
+
+// replyError is a reply sent as a RESP error
+type replyError string
+
+const (
+	replyOK     = simpleString("OK")
+	replyQueued = simpleString("QUEUED")
+)
+
+// Server serves the Redis protocol for a KVDB
+type Server struct {
+	kvdb *hse.Kvdb
+	dbs  []*hse.Kvs
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// New creates a Server for the KVDB in which database number i is the KVS
+// named by kvs[i]
+//
+// The KVSs are opened with "transactions.enabled=true", since every mutation is
+// made within a transaction, and stay open until Close().
+func New(kvdb *hse.Kvdb, kvs []string) (*Server, error) {
+	if len(kvs) == 0 {
+		return nil, errors.New("resp: no kvs to serve")
+	}
+
+	s := &Server{
+		kvdb:      kvdb,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+
+	for _, name := range kvs {
+		handle, err := kvdb.KvsOpen(name, "transactions.enabled=true")
+		if err != nil {
+			s.closeKvs()
+			return nil, fmt.Errorf("failed to open kvs %s: %s", name, err)
+		}
+
+		s.dbs = append(s.dbs, handle)
+	}
+
+	return s, nil
+}
+
+func (s *Server) closeKvs() error {
+	var err error
+
+	for _, kvs := range s.dbs {
+		if rc := kvs.Close(); err == nil {
+			err = rc
+		}
+	}
+	s.dbs = nil
+
+	return err
+}
+
+// Serve accepts connections on l and serves each in its own goroutine
+//
+// Serve returns when l fails, or ErrServerClosed once the Server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves conn until the client quits or the connection fails, and
+// closes it
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	c := &client{
+		s:     s,
+		r:     bufio.NewReader(conn),
+		w:     bufio.NewWriter(conn),
+		scans: make(map[uint64][]byte),
+	}
+	c.serve()
+}
+
+// Close stops the listeners, closes the connections and the KVSs
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return s.closeKvs()
+}
+
+// readLine reads a line terminated by CRLF, or LF for inline commands, and
+// returns it without the terminator
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+
+	return line, nil
+}
+
+// readLength reads a line holding a length prefixed by the given type byte
+func readLength(r *bufio.Reader, typ byte, max int) (int, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 || line[0] != typ {
+		return 0, errProtocol
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > max {
+		return 0, errProtocol
+	}
+
+	return n, nil
+}
+
+// readCommand reads a command sent as an array of bulk strings, or inline as
+// space separated words
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if b[0] != '*' {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return bytes.Fields(line), nil
+	}
+
+	n, err := readLength(r, '*', ARGS_MAX)
+	if err != nil {
+		return nil, err
+	}
+
+	// The argument count is untrusted, so the slice grows as arguments arrive
+	var args [][]byte
+	total := 0
+	for i := 0; i < n; i++ {
+		size, err := readLength(r, '$', int(limits.KVS_VALUE_LEN_MAX))
+		if err != nil {
+			return nil, err
+		}
+		total += size
+		if total > COMMAND_LEN_MAX {
+			return nil, errProtocol
+		}
+
+		arg := make([]byte, size+2)
+		if _, err = io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, errProtocol
+		}
+		args = append(args, arg[:size])
+	}
+
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case simpleString:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case replyError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []byte:
+		if v == nil {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n", len(v))
+		w.Write(v)
+		w.WriteString("\r\n")
+	case []interface{}:
+		if v == nil {
+			w.WriteString("*-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, elem := range v {
+			writeReply(w, elem)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unexpected reply type %T\r\n", reply)
+	}
+}
@@ -0,0 +1,300 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hse-project/hse-go/internal/hsetest"
+	"github.com/hse-project/hse-go/limits"
+)
+
+var respTestKvsNames = []string{"resp-test-0", "resp-test-1"}
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestServer(t *testing.T) (*testClient, func()) {
+	kvdb, cleanupKvdb := hsetest.NewKvdb(t, respTestKvsNames...)
+
+	s, err := New(kvdb, respTestKvsNames)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}, func() {
+		conn.Close()
+		if err := s.Close(); err != nil {
+			t.Errorf("failed to close server: %s", err)
+		}
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("serve returned %v", err)
+		}
+		cleanupKvdb()
+	}
+}
+
+func encodeCommand(args ...string) string {
+	s := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		s += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	return s
+}
+
+func (tc *testClient) readReply() interface{} {
+	line, err := readLine(tc.r)
+	if err != nil || len(line) == 0 {
+		tc.t.Fatalf("failed to read reply: %v", err)
+	}
+
+	switch line[0] {
+	case '+':
+		return simpleString(line[1:])
+	case '-':
+		return replyError(line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(string(line[1:]), 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(string(line[1:]))
+		if n < 0 {
+			return []byte(nil)
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(tc.r, buf); err != nil {
+			tc.t.Fatalf("failed to read bulk string: %s", err)
+		}
+		return buf[:n]
+	case '*':
+		n, _ := strconv.Atoi(string(line[1:]))
+		elems := make([]interface{}, n)
+		for i := range elems {
+			elems[i] = tc.readReply()
+		}
+		return elems
+	}
+
+	tc.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func (tc *testClient) expect(expected interface{}, args ...string) {
+	tc.t.Helper()
+
+	if _, err := tc.conn.Write([]byte(encodeCommand(args...))); err != nil {
+		tc.t.Fatalf("failed to send command: %s", err)
+	}
+
+	if reply := tc.readReply(); !reflect.DeepEqual(reply, expected) {
+		tc.t.Fatalf("%v replied %#v, expected %#v", args, reply, expected)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tc, cleanup := newTestServer(t)
+	defer cleanup()
+
+	tc.expect(simpleString("PONG"), "PING")
+	tc.expect([]byte(nil), "GET", "a")
+	tc.expect(replyOK, "SET", "a", "1")
+	tc.expect([]byte("1"), "GET", "a")
+	tc.expect([]byte(nil), "SET", "a", "2", "NX")
+	tc.expect(replyOK, "SET", "b", "2", "NX")
+	tc.expect([]byte(nil), "SET", "c", "3", "XX")
+	tc.expect(errSyntax, "SET", "c", "3", "NX", "XX")
+	tc.expect(int64(3), "EXISTS", "a", "b", "c", "a")
+	tc.expect(replyOK, "MSET", "c", "3", "d", "4")
+	tc.expect([]interface{}{[]byte("1"), []byte(nil), []byte("4")}, "MGET", "a", "x", "d")
+	tc.expect(int64(2), "DEL", "a", "x", "d")
+	tc.expect(int64(4), "INCR", "c")
+	tc.expect(int64(-6), "DECRBY", "c", "10")
+	tc.expect(int64(1), "INCR", "b2")
+	tc.expect(replyOK, "SET", "b2", "x")
+	tc.expect(errNotInteger, "INCR", "b2")
+	tc.expect(wrongArgs("get"), "GET")
+	tc.expect(replyError("ERR unknown command 'HSET'"), "HSET", "h", "f", "v")
+
+	// Each database is a separate KVS
+	tc.expect(replyOK, "SELECT", "1")
+	tc.expect([]byte(nil), "GET", "b")
+	tc.expect(replyError("ERR DB index is out of range"), "SELECT", "2")
+	tc.expect(replyOK, "SELECT", "0")
+
+	// Pipelined commands are answered in order
+	tc.conn.Write([]byte(encodeCommand("SET", "p", "1") + encodeCommand("INCR", "p") + "PING\r\n"))
+	for _, expected := range []interface{}{replyOK, int64(2), simpleString("PONG")} {
+		if reply := tc.readReply(); !reflect.DeepEqual(reply, expected) {
+			t.Fatalf("pipeline replied %#v, expected %#v", reply, expected)
+		}
+	}
+
+	tc.expect(replyOK, "QUIT")
+}
+
+func TestMulti(t *testing.T) {
+	tc, cleanup := newTestServer(t)
+	defer cleanup()
+
+	tc.expect(replyOK, "MULTI")
+	tc.expect(replyQueued, "SET", "a", "1")
+	tc.expect(replyQueued, "INCR", "a")
+	tc.expect(replyQueued, "SELECT", "1")
+	tc.expect(replyQueued, "SET", "a", "x")
+	tc.expect([]interface{}{replyOK, int64(2), replyOK, replyOK}, "EXEC")
+
+	tc.expect([]byte("x"), "GET", "a")
+	tc.expect(replyOK, "SELECT", "0")
+	tc.expect([]byte("2"), "GET", "a")
+
+	tc.expect(replyOK, "MULTI")
+	tc.expect(replyQueued, "SET", "b", "1")
+	tc.expect(replyOK, "DISCARD")
+	tc.expect([]byte(nil), "GET", "b")
+
+	tc.expect(replyOK, "MULTI")
+	tc.expect(replyQueued, "SET", "b", "1")
+	tc.expect(wrongArgs("get"), "GET")
+	tc.expect(replyError("EXECABORT Transaction discarded because of previous errors."), "EXEC")
+	tc.expect([]byte(nil), "GET", "b")
+
+	tc.expect(replyError("ERR EXEC without MULTI"), "EXEC")
+}
+
+func TestScan(t *testing.T) {
+	tc, cleanup := newTestServer(t)
+	defer cleanup()
+
+	for i := 0; i < 25; i++ {
+		tc.expect(replyOK, "SET", fmt.Sprintf("key:%02d", i), "v")
+	}
+	tc.expect(replyOK, "SET", "other", "v")
+
+	cursor := "0"
+	var keys []string
+	for {
+		if _, err := tc.conn.Write([]byte(encodeCommand("SCAN", cursor, "MATCH", "key:[0-1]?", "COUNT", "7"))); err != nil {
+			t.Fatalf("failed to send scan: %s", err)
+		}
+
+		reply := tc.readReply().([]interface{})
+		for _, key := range reply[1].([]interface{}) {
+			keys = append(keys, string(key.([]byte)))
+		}
+
+		if cursor = string(reply[0].([]byte)); cursor == "0" {
+			break
+		}
+	}
+
+	if len(keys) != 20 || keys[0] != "key:00" || keys[19] != "key:19" {
+		t.Fatalf("scan returned %v", keys)
+	}
+
+	tc.expect(replyError("ERR invalid cursor"), "SCAN", "12345")
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		matched bool
+	}{
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:email", false},
+		{"*a*b", "xaxxb", true},
+		{"a*", "", false},
+		{"*", "", true},
+		{"h[", "h[", false},
+		{`\`, `\`, true},
+	}
+
+	for _, test := range tests {
+		if match([]byte(test.pattern), []byte(test.s)) != test.matched {
+			t.Errorf("match(%q, %q) != %v", test.pattern, test.s, test.matched)
+		}
+	}
+
+	// Patterns with many stars do not backtrack exponentially
+	pattern := []byte(strings.Repeat("*a", 32) + "b")
+	s := bytes.Repeat([]byte("a"), 1024)
+
+	done := make(chan bool)
+	go func() { done <- match(pattern, s) }()
+
+	select {
+	case matched := <-done:
+		if matched {
+			t.Errorf("match(%q, %q) matched", pattern, s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("matching %q took too long", pattern)
+	}
+}
+
+func TestReadCommandTooLong(t *testing.T) {
+	arg := make([]byte, limits.KVS_VALUE_LEN_MAX)
+	n := COMMAND_LEN_MAX/len(arg) + 1
+
+	readers := []io.Reader{strings.NewReader(fmt.Sprintf("*%d\r\n", n))}
+	for i := 0; i < n; i++ {
+		readers = append(readers,
+			strings.NewReader(fmt.Sprintf("$%d\r\n", len(arg))),
+			bytes.NewReader(arg),
+			strings.NewReader("\r\n"))
+	}
+
+	if _, err := readCommand(bufio.NewReader(io.MultiReader(readers...))); err != errProtocol {
+		t.Errorf("expected %v, got %v", errProtocol, err)
+	}
+}
+
+func TestWriteReplyUnknown(t *testing.T) {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+
+	writeReply(w, 1.5)
+	w.Flush()
+
+	if b.String() != "-ERR unexpected reply type float64\r\n" {
+		t.Errorf("unexpected reply %q", b.String())
+	}
+}
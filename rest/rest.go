@@ -0,0 +1,305 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Package rest is a client for the HSE REST API
+//
+// libhse serves runtime introspection and management of the global, KVDB and
+// KVS params, compaction and performance counters over HTTP on a UNIX domain
+// socket. The socket is configured by the rest.enabled and rest.socket_path
+// global params.
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	hse "github.com/hse-project/hse-go"
+)
+
+// ErrDisabled is returned by SocketPath() and Discover() when the REST server
+// is disabled
+var ErrDisabled = errors.New("the HSE REST server is disabled")
+
+// Error is returned when the REST server responds with an error status
+type Error struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Message is the body of the response
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("hse rest: %s", http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("hse rest: %s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// CompactStatus is the state of a KVDB compaction
+type CompactStatus struct {
+	// SampLwm is the space amp low watermark (%)
+	SampLwm uint `json:"samp_lwm_pct"`
+	// SampHwm is the space amp high watermark (%)
+	SampHwm uint `json:"samp_hwm_pct"`
+	// SampCurr is the current space amplification (%)
+	SampCurr uint `json:"samp_curr_pct"`
+	// Active is whether an externally requested compaction is underway
+	Active bool `json:"active"`
+	// Canceled is whether an externally requested compaction was canceled
+	Canceled bool `json:"canceled"`
+}
+
+// Params maps param names to their values in their JSON representation
+type Params map[string]json.RawMessage
+
+// Client makes requests to the HSE REST server
+type Client struct {
+	socketPath string
+	client     *http.Client
+}
+
+// NewClient creates a Client for the REST server listening on socketPath
+func NewClient(socketPath string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &Client{
+		socketPath: socketPath,
+		client:     &http.Client{Transport: transport},
+	}
+}
+
+// SocketPath returns the socket path of the REST server from the global
+// config
+//
+// hse.Init() must have been called.
+func SocketPath() (string, error) {
+	enabled, err := hse.ParamGet("rest.enabled")
+	if err != nil {
+		return "", err
+	}
+	if enabled != "true" {
+		return "", ErrDisabled
+	}
+
+	value, err := hse.ParamGet("rest.socket_path")
+	if err != nil {
+		return "", err
+	}
+
+	var path string
+	if err = json.Unmarshal([]byte(value), &path); err != nil {
+		return "", fmt.Errorf("failed to parse rest.socket_path %s: %s", value, err)
+	}
+
+	return path, nil
+}
+
+// Discover creates a Client for the REST server of this process
+//
+// See SocketPath().
+func Discover() (*Client, error) {
+	path, err := SocketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(path), nil
+}
+
+// SocketPath returns the path of the socket the Client connects to
+func (c *Client) SocketPath() string {
+	return c.socketPath
+}
+
+// Close closes idle connections to the REST server
+func (c *Client) Close() {
+	if t, ok := c.client.Transport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+func escape(segments ...string) string {
+	var sb strings.Builder
+
+	for _, s := range segments {
+		sb.WriteByte('/')
+		sb.WriteString(url.PathEscape(s))
+	}
+
+	return sb.String()
+}
+
+// do makes a request and decodes the JSON response into out, if it is not nil
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, "http://hse"+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	if out == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Params gets the values of all global params
+func (c *Client) Params(ctx context.Context) (Params, error) {
+	var params Params
+
+	err := c.do(ctx, http.MethodGet, "/params", nil, &params)
+
+	return params, err
+}
+
+// Param gets the value of a global param
+func (c *Client) Param(ctx context.Context, param string) (json.RawMessage, error) {
+	var value json.RawMessage
+
+	err := c.do(ctx, http.MethodGet, escape("params", param), nil, &value)
+
+	return value, err
+}
+
+// SetParam sets the value of a writable global param
+//
+// value is marshaled to JSON.
+func (c *Client) SetParam(ctx context.Context, param string, value interface{}) error {
+	return c.do(ctx, http.MethodPut, escape("params", param), value, nil)
+}
+
+// Kvdbs lists the KVDBs open in the process
+//
+// The returned names identify the KVDBs in the other calls.
+func (c *Client) Kvdbs(ctx context.Context) ([]string, error) {
+	var kvdbs []string
+
+	err := c.do(ctx, http.MethodGet, "/kvdbs", nil, &kvdbs)
+
+	return kvdbs, err
+}
+
+// KvdbParams gets the values of all params of a KVDB
+func (c *Client) KvdbParams(ctx context.Context, kvdb string) (Params, error) {
+	var params Params
+
+	err := c.do(ctx, http.MethodGet, escape("kvdbs", kvdb, "params"), nil, &params)
+
+	return params, err
+}
+
+// KvdbParam gets the value of a KVDB param
+func (c *Client) KvdbParam(ctx context.Context, kvdb string, param string) (json.RawMessage, error) {
+	var value json.RawMessage
+
+	err := c.do(ctx, http.MethodGet, escape("kvdbs", kvdb, "params", param), nil, &value)
+
+	return value, err
+}
+
+// SetKvdbParam sets the value of a writable KVDB param
+//
+// value is marshaled to JSON.
+func (c *Client) SetKvdbParam(ctx context.Context, kvdb string, param string, value interface{}) error {
+	return c.do(ctx, http.MethodPut, escape("kvdbs", kvdb, "params", param), value, nil)
+}
+
+// KvsNames lists the open KVSs of a KVDB
+func (c *Client) KvsNames(ctx context.Context, kvdb string) ([]string, error) {
+	var names []string
+
+	err := c.do(ctx, http.MethodGet, escape("kvdbs", kvdb, "kvs"), nil, &names)
+
+	return names, err
+}
+
+// KvsParams gets the values of all params of a KVS
+func (c *Client) KvsParams(ctx context.Context, kvdb string, kvs string) (Params, error) {
+	var params Params
+
+	err := c.do(ctx, http.MethodGet, escape("kvdbs", kvdb, "kvs", kvs, "params"), nil, &params)
+
+	return params, err
+}
+
+// KvsParam gets the value of a KVS param
+func (c *Client) KvsParam(ctx context.Context, kvdb string, kvs string, param string) (json.RawMessage, error) {
+	var value json.RawMessage
+
+	err := c.do(ctx, http.MethodGet, escape("kvdbs", kvdb, "kvs", kvs, "params", param), nil, &value)
+
+	return value, err
+}
+
+// Compact requests a compaction of a KVDB
+func (c *Client) Compact(ctx context.Context, kvdb string) error {
+	return c.do(ctx, http.MethodPost, escape("kvdbs", kvdb, "compact"), nil, nil)
+}
+
+// CancelCompact cancels an ongoing compaction of a KVDB
+func (c *Client) CancelCompact(ctx context.Context, kvdb string) error {
+	return c.do(ctx, http.MethodDelete, escape("kvdbs", kvdb, "compact"), nil, nil)
+}
+
+// CompactStatus gets the compaction status of a KVDB
+func (c *Client) CompactStatus(ctx context.Context, kvdb string) (CompactStatus, error) {
+	var status CompactStatus
+
+	err := c.do(ctx, http.MethodGet, escape("kvdbs", kvdb, "compact"), nil, &status)
+
+	return status, err
+}
+
+// PerfCounters gets the performance counters of a KVDB
+//
+// The counters are returned in their JSON representation since their set
+// depends on the perfc.level global param.
+func (c *Client) PerfCounters(ctx context.Context, kvdb string) (json.RawMessage, error) {
+	var counters json.RawMessage
+
+	err := c.do(ctx, http.MethodGet, escape("kvdbs", kvdb, "perfc"), nil, &counters)
+
+	return counters, err
+}
@@ -0,0 +1,214 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	hse "github.com/hse-project/hse-go"
+)
+
+// fakeServer serves a subset of the HSE REST API on a temporary socket
+type fakeServer struct {
+	*httptest.Server
+	dir     string
+	path    string
+	params  map[string]json.RawMessage
+	compact CompactStatus
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	dir, err := ioutil.TempDir("", "hse-go-rest")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+
+	path := filepath.Join(dir, "hse.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to listen on %s: %s", path, err)
+	}
+
+	s := &fakeServer{
+		dir:  dir,
+		path: path,
+		params: map[string]json.RawMessage{
+			"logging.level": json.RawMessage(`7`),
+			"rest.enabled":  json.RawMessage(`true`),
+		},
+		compact: CompactStatus{SampLwm: 117, SampHwm: 137, SampCurr: 120},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/params", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(s.params)
+	})
+	mux.HandleFunc("/params/", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[len("/params/"):]
+		if r.Method == http.MethodPut {
+			value, _ := ioutil.ReadAll(r.Body)
+			s.params[name] = value
+			return
+		}
+		value, ok := s.params[name]
+		if !ok {
+			http.Error(w, "no such param", http.StatusNotFound)
+			return
+		}
+		w.Write(value)
+	})
+	mux.HandleFunc("/kvdbs", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]string{"/var/lib/hse/kvdb"})
+	})
+	mux.HandleFunc("/kvdbs/%2Fvar%2Flib%2Fhse%2Fkvdb/kvs", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]string{"users", "orders"})
+	})
+	mux.HandleFunc("/kvdbs/%2Fvar%2Flib%2Fhse%2Fkvdb/kvs/users/params/prefix.length", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`4`))
+	})
+	mux.HandleFunc("/kvdbs/%2Fvar%2Flib%2Fhse%2Fkvdb/compact", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.compact.Active = true
+		case http.MethodDelete:
+			s.compact.Active = false
+			s.compact.Canceled = true
+		default:
+			json.NewEncoder(w).Encode(s.compact)
+		}
+	})
+
+	s.Server = httptest.NewUnstartedServer(escapedPathHandler{mux})
+	s.Server.Listener = l
+	s.Server.Start()
+
+	return s
+}
+
+func (s *fakeServer) Close() {
+	s.Server.Close()
+	os.RemoveAll(s.dir)
+}
+
+// escapedPathHandler routes on the escaped path, like HSE does, so that KVDB
+// homes containing slashes stay within one path segment
+type escapedPathHandler struct {
+	mux *http.ServeMux
+}
+
+func (h escapedPathHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.URL.Path = r.URL.EscapedPath()
+	r.URL.RawPath = ""
+	h.mux.ServeHTTP(w, r)
+}
+
+func TestClient(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	c := NewClient(s.path)
+	defer c.Close()
+
+	ctx := context.Background()
+	const kvdb = "/var/lib/hse/kvdb"
+
+	params, err := c.Params(ctx)
+	if err != nil {
+		t.Fatalf("failed to get params: %s", err)
+	}
+	if string(params["logging.level"]) != "7" {
+		t.Fatalf("unexpected params %s", params)
+	}
+
+	if err = c.SetParam(ctx, "logging.level", 3); err != nil {
+		t.Fatalf("failed to set param: %s", err)
+	}
+	value, err := c.Param(ctx, "logging.level")
+	if err != nil {
+		t.Fatalf("failed to get param: %s", err)
+	}
+	if string(value) != "3" {
+		t.Fatalf("logging.level is %s after setting it to 3", value)
+	}
+
+	kvdbs, err := c.Kvdbs(ctx)
+	if err != nil || len(kvdbs) != 1 || kvdbs[0] != kvdb {
+		t.Fatalf("unexpected kvdbs %q: %v", kvdbs, err)
+	}
+
+	names, err := c.KvsNames(ctx, kvdb)
+	if err != nil || len(names) != 2 {
+		t.Fatalf("unexpected kvs names %q: %v", names, err)
+	}
+
+	value, err = c.KvsParam(ctx, kvdb, "users", "prefix.length")
+	if err != nil || string(value) != "4" {
+		t.Fatalf("unexpected prefix.length %s: %v", value, err)
+	}
+
+	if err = c.Compact(ctx, kvdb); err != nil {
+		t.Fatalf("failed to request compaction: %s", err)
+	}
+	status, err := c.CompactStatus(ctx, kvdb)
+	if err != nil {
+		t.Fatalf("failed to get compaction status: %s", err)
+	}
+	if !status.Active || status.SampHwm != 137 {
+		t.Fatalf("unexpected compaction status %+v", status)
+	}
+	if err = c.CancelCompact(ctx, kvdb); err != nil {
+		t.Fatalf("failed to cancel compaction: %s", err)
+	}
+	if status, _ = c.CompactStatus(ctx, kvdb); !status.Canceled {
+		t.Fatalf("compaction was not canceled: %+v", status)
+	}
+}
+
+func TestClientError(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	c := NewClient(s.path)
+	defer c.Close()
+
+	_, err := c.Param(context.Background(), "no.such.param")
+
+	rerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if rerr.StatusCode != http.StatusNotFound || rerr.Message != "no such param" {
+		t.Fatalf("unexpected error %+v", rerr)
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	if err := hse.Init(); err != nil {
+		t.Fatalf("failed to initialize hse: %s", err)
+	}
+	defer hse.Fini()
+
+	path, err := SocketPath()
+	if err == ErrDisabled {
+		t.Skip("the REST server is disabled")
+	}
+	if err != nil {
+		t.Fatalf("failed to get socket path: %s", err)
+	}
+	if path == "" {
+		t.Fatalf("socket path is empty")
+	}
+}
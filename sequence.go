@@ -0,0 +1,94 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"encoding/binary"
+	"sync"
+	"syscall"
+)
+
+// Sequence allocates monotonically increasing IDs persisted in a Kvs
+//
+// The stored value is the next ID which has not been handed out to any
+// Sequence. Rather than running a transaction for every ID, a Sequence leases a
+// block of IDs at a time and hands them out from memory. IDs left in a lease
+// when the process exits are never reused, so IDs are unique and increasing
+// across restarts but not necessarily contiguous. IDs start at 1. The Kvs must
+// be opened with "transactions.enabled=true".
+type Sequence struct {
+	mu    sync.Mutex
+	kvs   *Kvs
+	key   []byte
+	lease uint64
+	next  uint64
+	end   uint64
+}
+
+// NewSequence creates a Sequence stored under key in kvs which leases lease IDs
+// per transaction
+func NewSequence(kvs *Kvs, key []byte, lease uint64) *Sequence {
+	if lease == 0 {
+		lease = 1
+	}
+
+	return &Sequence{
+		kvs:   kvs,
+		key:   append([]byte(nil), key...),
+		lease: lease,
+	}
+}
+
+// Next returns the next ID in the sequence
+//
+// This function is thread safe.
+func (s *Sequence) Next() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == s.end {
+		if err := s.renew(); err != nil {
+			return 0, err
+		}
+	}
+
+	id := s.next
+	s.next++
+
+	return id, nil
+}
+
+func (s *Sequence) renew() error {
+	var start uint64
+
+	err := s.kvs.kvdb.Transact(func(txn *Transaction) error {
+		value, _, err := txn.Get(s.kvs, s.key, 0)
+		if err != nil {
+			return err
+		}
+
+		start = 1
+		if value != nil {
+			if len(value) != 8 {
+				return syscall.EINVAL
+			}
+			start = binary.BigEndian.Uint64(value)
+		}
+
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, start+s.lease)
+
+		return txn.Put(s.kvs, s.key, buf, 0)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.next = start
+	s.end = start + s.lease
+
+	return nil
+}
@@ -0,0 +1,41 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import "testing"
+
+const (
+	sequenceTestKvsName = "sequence-test"
+)
+
+func TestSequence(t *testing.T) {
+	kvs := makeAndOpenKvs(sequenceTestKvsName, txnParams)
+	defer kvdb.KvsDrop(sequenceTestKvsName)
+	defer kvs.Close()
+
+	seq := NewSequence(kvs, []byte("seq"), 4)
+
+	var last uint64
+	for i := 0; i < 10; i++ {
+		id, err := seq.Next()
+		if err != nil {
+			t.Fatalf("failed to get next id: %s", err)
+		}
+		if id <= last {
+			t.Fatalf("id %d is not greater than %d", id, last)
+		}
+		last = id
+	}
+
+	// A new Sequence over the same key acts like a restarted process
+	id, err := NewSequence(kvs, []byte("seq"), 4).Next()
+	if err != nil {
+		t.Fatalf("failed to get next id: %s", err)
+	}
+	if id <= last {
+		t.Fatalf("id %d after restart is not greater than %d", id, last)
+	}
+}
@@ -0,0 +1,306 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// TXN_RETRY_MAX is the number of times Client.Transact() retries a
+// transaction which conflicted with another
+const TXN_RETRY_MAX = 8
+
+// Error is returned when the server responds with an error status
+type Error struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Message is the error reported by the server
+	Message string
+	// Errno is the errno returned by HSE, if any
+	Errno syscall.Errno
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("hse server: %s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client is a client of a Server
+//
+// A Client is safe for concurrent use.
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// Txn is a transaction begun by a Client
+type Txn struct {
+	c  *Client
+	id uint64
+}
+
+// NewClient creates a client of the server at baseURL, such as
+// "http://localhost:8080"
+//
+// httpClient is used to make requests, or http.DefaultClient if nil.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{base: strings.TrimSuffix(baseURL, "/"), http: httpClient}
+}
+
+// send makes a request and returns the response if it has a success status
+func (c *Client) send(ctx context.Context, method string, path string, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+
+		data, _ := ioutil.ReadAll(resp.Body)
+
+		var e ErrorResponse
+		if json.Unmarshal(data, &e) != nil {
+			e.Error = strings.TrimSpace(string(data))
+		}
+
+		return nil, &Error{StatusCode: resp.StatusCode, Message: e.Error, Errno: syscall.Errno(e.Errno)}
+	}
+
+	return resp, nil
+}
+
+// do makes a request, decoding the response into v if it is not nil
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, v interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// KvsNames lists the KVSs of the KVDB
+func (c *Client) KvsNames(ctx context.Context) ([]string, error) {
+	var resp KvsNamesResponse
+
+	if err := c.do(ctx, http.MethodGet, "/v1/kvs", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Names, nil
+}
+
+// Get retrieves the value of key, reporting whether it was found
+func (c *Client) Get(ctx context.Context, kvs string, key []byte) ([]byte, bool, error) {
+	return c.get(ctx, 0, kvs, key)
+}
+
+// Put sets the value of key
+func (c *Client) Put(ctx context.Context, kvs string, key []byte, value []byte) error {
+	return c.do(ctx, http.MethodPost, "/v1/put", PutRequest{Kvs: kvs, Key: key, Value: value}, nil)
+}
+
+// Delete deletes key
+func (c *Client) Delete(ctx context.Context, kvs string, key []byte) error {
+	return c.do(ctx, http.MethodPost, "/v1/delete", DeleteRequest{Kvs: kvs, Key: key}, nil)
+}
+
+// PrefixDelete deletes the keys with prefix
+func (c *Client) PrefixDelete(ctx context.Context, kvs string, prefix []byte) error {
+	return c.do(ctx, http.MethodPost, "/v1/prefix-delete", PrefixDeleteRequest{Kvs: kvs, Prefix: prefix}, nil)
+}
+
+// Scan reads one page of the range of req
+//
+// Its Txn field is ignored; use Txn.Scan() within a transaction.
+func (c *Client) Scan(ctx context.Context, req ScanRequest) (*ScanResponse, error) {
+	req.Txn = 0
+
+	return c.scan(ctx, req)
+}
+
+// ScanStream calls fn with every pair in the range of req as they are
+// received, stopping early if fn returns an error
+//
+// Its Txn field is ignored; use Txn.ScanStream() within a transaction.
+func (c *Client) ScanStream(ctx context.Context, req ScanRequest, fn func(key, value []byte) error) error {
+	req.Txn = 0
+
+	return c.scanStream(ctx, req, fn)
+}
+
+func (c *Client) get(ctx context.Context, txn uint64, kvs string, key []byte) ([]byte, bool, error) {
+	var resp GetResponse
+
+	if err := c.do(ctx, http.MethodPost, "/v1/get", GetRequest{Kvs: kvs, Key: key, Txn: txn}, &resp); err != nil {
+		return nil, false, err
+	}
+
+	return resp.Value, resp.Found, nil
+}
+
+func (c *Client) scan(ctx context.Context, req ScanRequest) (*ScanResponse, error) {
+	var resp ScanResponse
+
+	if err := c.do(ctx, http.MethodPost, "/v1/scan", req, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (c *Client) scanStream(ctx context.Context, req ScanRequest, fn func(key, value []byte) error) error {
+	resp, err := c.send(ctx, http.MethodPost, "/v1/scan/stream", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var line StreamLine
+		if err = dec.Decode(&line); err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line.Error != "" {
+			return &Error{StatusCode: resp.StatusCode, Message: line.Error}
+		}
+
+		if err = fn(line.Key, line.Value); err != nil {
+			return err
+		}
+	}
+}
+
+// Begin begins a transaction
+//
+// The server aborts a transaction which goes unused for TXN_IDLE_TIMEOUT.
+func (c *Client) Begin(ctx context.Context) (*Txn, error) {
+	var resp TxnResponse
+
+	if err := c.do(ctx, http.MethodPost, "/v1/txns", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &Txn{c: c, id: resp.Txn}, nil
+}
+
+// Transact runs fn in a transaction which is committed if fn succeeds and
+// aborted otherwise
+//
+// A transaction which conflicts with another is retried up to TXN_RETRY_MAX
+// times, so fn may be called more than once.
+func (c *Client) Transact(ctx context.Context, fn func(txn *Txn) error) error {
+	for retries := 0; ; retries++ {
+		txn, err := c.Begin(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(txn); err == nil {
+			err = txn.Commit(ctx)
+		} else {
+			txn.Abort(ctx)
+		}
+		if err == nil {
+			return nil
+		}
+
+		if e, ok := err.(*Error); !ok || e.Errno != syscall.ECANCELED || retries == TXN_RETRY_MAX {
+			return err
+		}
+	}
+}
+
+// ID returns the server's identifier of the transaction
+func (t *Txn) ID() uint64 {
+	return t.id
+}
+
+// Get retrieves the value of key within the transaction
+func (t *Txn) Get(ctx context.Context, kvs string, key []byte) ([]byte, bool, error) {
+	return t.c.get(ctx, t.id, kvs, key)
+}
+
+// Put sets the value of key within the transaction
+func (t *Txn) Put(ctx context.Context, kvs string, key []byte, value []byte) error {
+	return t.c.do(ctx, http.MethodPost, "/v1/put", PutRequest{Kvs: kvs, Key: key, Value: value, Txn: t.id}, nil)
+}
+
+// Delete deletes key within the transaction
+func (t *Txn) Delete(ctx context.Context, kvs string, key []byte) error {
+	return t.c.do(ctx, http.MethodPost, "/v1/delete", DeleteRequest{Kvs: kvs, Key: key, Txn: t.id}, nil)
+}
+
+// PrefixDelete deletes the keys with prefix within the transaction
+func (t *Txn) PrefixDelete(ctx context.Context, kvs string, prefix []byte) error {
+	return t.c.do(ctx, http.MethodPost, "/v1/prefix-delete", PrefixDeleteRequest{Kvs: kvs, Prefix: prefix, Txn: t.id}, nil)
+}
+
+// Scan reads one page of the range of req within the transaction
+func (t *Txn) Scan(ctx context.Context, req ScanRequest) (*ScanResponse, error) {
+	req.Txn = t.id
+
+	return t.c.scan(ctx, req)
+}
+
+// ScanStream calls fn with every pair in the range of req within the
+// transaction
+func (t *Txn) ScanStream(ctx context.Context, req ScanRequest, fn func(key, value []byte) error) error {
+	req.Txn = t.id
+
+	return t.c.scanStream(ctx, req, fn)
+}
+
+// Commit commits the transaction
+func (t *Txn) Commit(ctx context.Context) error {
+	return t.c.do(ctx, http.MethodPost, fmt.Sprintf("/v1/txns/%d/commit", t.id), nil, nil)
+}
+
+// Abort aborts the transaction
+func (t *Txn) Abort(ctx context.Context) error {
+	return t.c.do(ctx, http.MethodPost, fmt.Sprintf("/v1/txns/%d/abort", t.id), nil, nil)
+}
@@ -0,0 +1,728 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Package server exposes a KVDB over HTTP/JSON
+//
+// Every endpoint takes and returns JSON, in which keys and values are base64
+// encoded. Endpoints other than /v1/kvs are POSTs of the request types below.
+//
+//	GET  /v1/kvs                  list the KVSs
+//	POST /v1/get                  get a value
+//	POST /v1/put                  put a value
+//	POST /v1/delete               delete a key
+//	POST /v1/prefix-delete        delete the keys with a prefix
+//	POST /v1/scan                 read one page of a range of keys
+//	POST /v1/scan/stream          read a range of keys as newline-delimited JSON
+//	POST /v1/txns                 begin a transaction
+//	POST /v1/txns/{id}/commit     commit a transaction
+//	POST /v1/txns/{id}/abort      abort a transaction
+//
+// Data requests which name a transaction are made within it, and mutations which
+// do not are each made in a transaction of their own. KVSs are thus opened with
+// "transactions.enabled=true", and a KVS the application has open must be too.
+// Errors are returned as an ErrorResponse with a status derived from the errno.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	hse "github.com/hse-project/hse-go"
+	"github.com/hse-project/hse-go/limits"
+)
+
+const (
+	// REQUEST_SIZE_MAX is the maximum size of a request body, which fits a
+	// key and a value of the maximum lengths once base64 encoded
+	REQUEST_SIZE_MAX = (int64(limits.KVS_KEY_LEN_MAX)+int64(limits.KVS_VALUE_LEN_MAX))*4/3 + 4096
+	// SCAN_LIMIT_DEFAULT is the number of pairs in a page of a scan which does
+	// not set a limit
+	SCAN_LIMIT_DEFAULT = 1000
+	// SCAN_LIMIT_MAX is the maximum number of pairs in a page of a scan
+	SCAN_LIMIT_MAX = 10000
+	// TXN_IDLE_TIMEOUT is how long a transaction may go unused before it is
+	// aborted
+	TXN_IDLE_TIMEOUT = time.Minute
+)
+
+// streamFlushPairs is how many pairs a streaming scan writes between flushes
+const streamFlushPairs = 64
+
+var (
+	errKeyTooLong   = fmt.Errorf("key is longer than %d bytes", limits.KVS_KEY_LEN_MAX)
+	errValueTooLong = fmt.Errorf("value is longer than %d bytes", limits.KVS_VALUE_LEN_MAX)
+	errNoTxn        = errors.New("no such transaction")
+	errScanDone     = errors.New("scan done")
+)
+
+// KvsNamesResponse is the response to GET /v1/kvs
+type KvsNamesResponse struct {
+	Names []string `json:"names"`
+}
+
+// GetRequest is the request of /v1/get
+type GetRequest struct {
+	Kvs string `json:"kvs"`
+	Key []byte `json:"key"`
+	Txn uint64 `json:"txn,omitempty"`
+}
+
+// GetResponse is the response of /v1/get
+type GetResponse struct {
+	Found bool   `json:"found"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// PutRequest is the request of /v1/put
+type PutRequest struct {
+	Kvs   string `json:"kvs"`
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Txn   uint64 `json:"txn,omitempty"`
+}
+
+// DeleteRequest is the request of /v1/delete
+type DeleteRequest struct {
+	Kvs string `json:"kvs"`
+	Key []byte `json:"key"`
+	Txn uint64 `json:"txn,omitempty"`
+}
+
+// PrefixDeleteRequest is the request of /v1/prefix-delete
+type PrefixDeleteRequest struct {
+	Kvs    string `json:"kvs"`
+	Prefix []byte `json:"prefix"`
+	Txn    uint64 `json:"txn,omitempty"`
+}
+
+// ScanRequest is the request of /v1/scan and /v1/scan/stream
+//
+// The keys with Prefix in the range [Start, End) are returned, in reverse
+// order if Reverse is set. A nil Start or End leaves the range open on that
+// side.
+type ScanRequest struct {
+	Kvs     string `json:"kvs"`
+	Prefix  []byte `json:"prefix,omitempty"`
+	Start   []byte `json:"start,omitempty"`
+	End     []byte `json:"end,omitempty"`
+	Reverse bool   `json:"reverse,omitempty"`
+	// Limit is the maximum number of pairs in a page, up to SCAN_LIMIT_MAX.
+	// It is ignored by streaming scans.
+	Limit int    `json:"limit,omitempty"`
+	Txn   uint64 `json:"txn,omitempty"`
+}
+
+// Pair is a key-value pair returned by a scan
+type Pair struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// ScanResponse is the response of /v1/scan
+type ScanResponse struct {
+	Pairs []Pair `json:"pairs"`
+	// Next is the first key following the page, if any. It is the Start of
+	// the next page of a forward scan and the End of the next page of a
+	// reverse scan after appending a zero byte.
+	Next []byte `json:"next,omitempty"`
+}
+
+// StreamLine is a line of the response of /v1/scan/stream
+//
+// A scan which fails part way through ends with a line holding the error.
+type StreamLine struct {
+	Pair
+	Error string `json:"error,omitempty"`
+}
+
+// TxnResponse is the response of /v1/txns
+type TxnResponse struct {
+	Txn uint64 `json:"txn"`
+}
+
+// ErrorResponse is the body of an error response
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Errno is the errno returned by HSE, if any
+	Errno int `json:"errno,omitempty"`
+}
+
+type txnState struct {
+	mu    sync.Mutex
+	txn   *hse.Transaction
+	timer *time.Timer
+	done  bool
+}
+
+// Server is an http.Handler serving a KVDB
+type Server struct {
+	kvdb *hse.Kvdb
+	mux  *http.ServeMux
+
+	mu      sync.Mutex
+	kvs     map[string]*hse.Kvs
+	txns    map[uint64]*txnState
+	nextTxn uint64
+}
+
+// New creates a Server for the KVDB
+//
+// KVSs are opened when first used and stay open until Close().
+func New(kvdb *hse.Kvdb) *Server {
+	s := &Server{
+		kvdb: kvdb,
+		mux:  http.NewServeMux(),
+		kvs:  make(map[string]*hse.Kvs),
+		txns: make(map[uint64]*txnState),
+	}
+
+	s.mux.HandleFunc("/v1/kvs", s.handleKvsNames)
+	s.mux.HandleFunc("/v1/get", s.post(s.handleGet))
+	s.mux.HandleFunc("/v1/put", s.post(s.handlePut))
+	s.mux.HandleFunc("/v1/delete", s.post(s.handleDelete))
+	s.mux.HandleFunc("/v1/prefix-delete", s.post(s.handlePrefixDelete))
+	s.mux.HandleFunc("/v1/scan", s.post(s.handleScan))
+	s.mux.HandleFunc("/v1/scan/stream", s.post(s.handleScanStream))
+	s.mux.HandleFunc("/v1/txns", s.post(s.handleBegin))
+	s.mux.HandleFunc("/v1/txns/", s.post(s.handleTxn))
+
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close aborts the open transactions and closes the KVSs opened by the server
+//
+// The http.Server serving s should be shut down first.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	txns := s.txns
+	s.txns = make(map[uint64]*txnState)
+	s.mu.Unlock()
+
+	for _, t := range txns {
+		t.finish(false)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for name, kvs := range s.kvs {
+		if rc := kvs.Close(); err == nil {
+			err = rc
+		}
+		delete(s.kvs, name)
+	}
+
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with err and a status derived from it
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	resp := ErrorResponse{Error: err.Error()}
+
+	var errno syscall.Errno
+	switch e := err.(type) {
+	case syscall.Errno:
+		errno = e
+	case *kvsError:
+		errno = e.errno
+	}
+
+	switch {
+	case err == errKeyTooLong || err == errValueTooLong:
+		status = http.StatusRequestEntityTooLarge
+	case err == errNoTxn:
+		status = http.StatusNotFound
+	case errno == syscall.ENOENT:
+		status = http.StatusNotFound
+	case errno == syscall.ECANCELED:
+		status = http.StatusConflict
+	case errno == syscall.EINVAL || errno == syscall.ENAMETOOLONG:
+		status = http.StatusBadRequest
+	}
+
+	if errno != 0 {
+		resp.Errno = int(errno)
+	}
+
+	writeJSON(w, status, resp)
+}
+
+// kvsError is a failure to open a KVS
+type kvsError struct {
+	name  string
+	errno syscall.Errno
+}
+
+func (e *kvsError) Error() string {
+	return fmt.Sprintf("kvs %s: %s", e.name, e.errno)
+}
+
+// post restricts a handler to POST requests and limits the size of their
+// bodies
+func (s *Server) post(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, REQUEST_SIZE_MAX)
+		h(w, r)
+	}
+}
+
+// decode reads the JSON request body into v, responding with an error if it
+// cannot
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		status := http.StatusBadRequest
+		if err.Error() == "http: request body too large" {
+			status = http.StatusRequestEntityTooLarge
+		}
+
+		writeJSON(w, status, ErrorResponse{Error: err.Error()})
+		return false
+	}
+
+	return true
+}
+
+func checkKey(key []byte) error {
+	if uint(len(key)) > limits.KVS_KEY_LEN_MAX {
+		return errKeyTooLong
+	}
+
+	return nil
+}
+
+// open returns the handle of the named KVS, opening it if needed
+//
+// A handle the application has open is shared rather than opened again, since
+// a KVS cannot be opened twice, and is left to the application to close.
+func (s *Server) open(name string) (*hse.Kvs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if kvs, ok := s.kvs[name]; ok {
+		return kvs, nil
+	}
+
+	if kvs := s.kvdb.KvsHandle(name); kvs != nil {
+		return kvs, nil
+	}
+
+	kvs, err := s.kvdb.KvsOpen(name, "transactions.enabled=true")
+	if err != nil {
+		if errno, ok := err.(syscall.Errno); ok {
+			return nil, &kvsError{name: name, errno: errno}
+		}
+		return nil, err
+	}
+
+	s.kvs[name] = kvs
+
+	return kvs, nil
+}
+
+// do runs fn on the named KVS, within the transaction with the given ID if it
+// is not 0
+func (s *Server) do(name string, id uint64, fn func(kvs *hse.Kvs, txn *hse.Transaction) error) error {
+	kvs, err := s.open(name)
+	if err != nil {
+		return err
+	}
+
+	if id == 0 {
+		return fn(kvs, nil)
+	}
+
+	s.mu.Lock()
+	t := s.txns[id]
+	s.mu.Unlock()
+
+	if t == nil {
+		return errNoTxn
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return errNoTxn
+	}
+	t.timer.Reset(TXN_IDLE_TIMEOUT)
+
+	return fn(kvs, t.txn)
+}
+
+// write runs the mutation fn within txn, or within a transaction of its own if
+// txn is nil
+func (s *Server) write(txn *hse.Transaction, fn func(txn *hse.Transaction) error) error {
+	if txn != nil {
+		return fn(txn)
+	}
+
+	return s.kvdb.Transact(fn)
+}
+
+func (s *Server) handleKvsNames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+		return
+	}
+
+	names, err := s.kvdb.KvsNames()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, KvsNamesResponse{Names: names})
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	var req GetRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var resp GetResponse
+
+	err := checkKey(req.Key)
+	if err == nil {
+		err = s.do(req.Kvs, req.Txn, func(kvs *hse.Kvs, txn *hse.Transaction) error {
+			var err error
+
+			if txn != nil {
+				resp.Value, _, err = txn.Get(kvs, req.Key, 0)
+			} else {
+				resp.Value, _, err = kvs.Get(req.Key, 0)
+			}
+
+			return err
+		})
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp.Found = resp.Value != nil
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
+	var req PutRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	err := checkKey(req.Key)
+	if err == nil && uint(len(req.Value)) > limits.KVS_VALUE_LEN_MAX {
+		err = errValueTooLong
+	}
+	if err == nil {
+		err = s.do(req.Kvs, req.Txn, func(kvs *hse.Kvs, txn *hse.Transaction) error {
+			return s.write(txn, func(txn *hse.Transaction) error {
+				return txn.Put(kvs, req.Key, req.Value, 0)
+			})
+		})
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var req DeleteRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	err := checkKey(req.Key)
+	if err == nil {
+		err = s.do(req.Kvs, req.Txn, func(kvs *hse.Kvs, txn *hse.Transaction) error {
+			return s.write(txn, func(txn *hse.Transaction) error {
+				return txn.Delete(kvs, req.Key, 0)
+			})
+		})
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handlePrefixDelete(w http.ResponseWriter, r *http.Request) {
+	var req PrefixDeleteRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	err := checkKey(req.Prefix)
+	if err == nil {
+		err = s.do(req.Kvs, req.Txn, func(kvs *hse.Kvs, txn *hse.Transaction) error {
+			return s.write(txn, func(txn *hse.Transaction) error {
+				return txn.PrefixDelete(kvs, req.Prefix, 0)
+			})
+		})
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scan calls fn with a copy of every pair in the range of req, stopping early
+// if fn returns an error
+func (s *Server) scan(req *ScanRequest, fn func(key, value []byte) error) error {
+	for _, key := range [][]byte{req.Prefix, req.Start, req.End} {
+		if err := checkKey(key); err != nil {
+			return err
+		}
+	}
+
+	return s.do(req.Kvs, req.Txn, func(kvs *hse.Kvs, txn *hse.Transaction) error {
+		var flags hse.CursorCreateFlag
+		if req.Reverse {
+			flags |= hse.CURSOR_CREATE_REV
+		}
+
+		var c *hse.Cursor
+		var err error
+
+		if txn != nil {
+			c, err = txn.CreateCursor(kvs, req.Prefix, flags)
+		} else {
+			c, err = kvs.CreateCursor(req.Prefix, flags)
+		}
+		if err != nil {
+			return err
+		}
+		defer c.Destroy()
+
+		// A reverse cursor seeks to the last key at or before its target
+		seek := req.Start
+		if req.Reverse {
+			seek = req.End
+		}
+		if seek != nil {
+			if _, err = c.Seek(seek, 0); err != nil {
+				return err
+			}
+		}
+
+		return c.ForEach(func(key []byte, value []byte) error {
+			if req.Reverse {
+				if req.End != nil && bytes.Compare(key, req.End) >= 0 {
+					return nil
+				}
+				if req.Start != nil && bytes.Compare(key, req.Start) < 0 {
+					return hse.ErrStopIteration
+				}
+			} else if req.End != nil && bytes.Compare(key, req.End) >= 0 {
+				return hse.ErrStopIteration
+			}
+
+			// ForEach() reuses its buffer once the callback returns
+			return fn(append([]byte(nil), key...), append([]byte{}, value...))
+		})
+	})
+}
+
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	var req ScanRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = SCAN_LIMIT_DEFAULT
+	}
+	if limit > SCAN_LIMIT_MAX {
+		limit = SCAN_LIMIT_MAX
+	}
+
+	resp := ScanResponse{Pairs: []Pair{}}
+
+	err := s.scan(&req, func(key, value []byte) error {
+		if len(resp.Pairs) == limit {
+			resp.Next = key
+			return errScanDone
+		}
+
+		resp.Pairs = append(resp.Pairs, Pair{Key: key, Value: value})
+		return nil
+	})
+	if err != nil && err != errScanDone {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleScanStream(w http.ResponseWriter, r *http.Request) {
+	var req ScanRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	started := false
+	n := 0
+
+	err := s.scan(&req, func(key, value []byte) error {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		if err := enc.Encode(StreamLine{Pair: Pair{Key: key, Value: value}}); err != nil {
+			return err
+		}
+
+		if n++; n%streamFlushPairs == 0 && flusher != nil {
+			flusher.Flush()
+		}
+
+		return r.Context().Err()
+	})
+
+	switch {
+	case err != nil && !started:
+		writeError(w, err)
+	case err != nil:
+		enc.Encode(StreamLine{Error: err.Error()})
+	case !started:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleBegin(w http.ResponseWriter, r *http.Request) {
+	txn := s.kvdb.NewTransaction()
+	if txn == nil {
+		writeError(w, syscall.ENOMEM)
+		return
+	}
+
+	if err := txn.Begin(); err != nil {
+		txn.Free()
+		writeError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.nextTxn++
+	id := s.nextTxn
+
+	t := &txnState{txn: txn}
+	t.timer = time.AfterFunc(TXN_IDLE_TIMEOUT, func() {
+		s.remove(id)
+		t.finish(false)
+	})
+	s.txns[id] = t
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, TxnResponse{Txn: id})
+}
+
+// remove forgets the transaction with the given ID, returning it
+func (s *Server) remove(id uint64) *txnState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.txns[id]
+	delete(s.txns, id)
+
+	return t
+}
+
+// finish commits or aborts the transaction and frees it
+func (t *txnState) finish(commit bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return errNoTxn
+	}
+	t.done = true
+	t.timer.Stop()
+
+	var err error
+	if commit {
+		err = t.txn.Commit()
+	}
+	if !commit || t.txn.State() == hse.TransactionActive {
+		t.txn.Abort()
+	}
+	t.txn.Free()
+
+	return err
+}
+
+func (s *Server) handleTxn(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/txns/"), "/")
+	if len(parts) != 2 || (parts[1] != "commit" && parts[1] != "abort") {
+		http.NotFound(w, r)
+		return
+	}
+
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t := s.remove(id)
+	if t == nil {
+		writeError(w, errNoTxn)
+		return
+	}
+
+	if err = t.finish(parts[1] == "commit"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,202 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	hse "github.com/hse-project/hse-go"
+	"github.com/hse-project/hse-go/internal/hsetest"
+	"github.com/hse-project/hse-go/limits"
+)
+
+const (
+	serverTestKvsName = "server-test"
+)
+
+func newTestServer(t *testing.T) (*Client, func()) {
+	kvdb, cleanup := hsetest.NewKvdb(t, serverTestKvsName)
+
+	s := New(kvdb)
+	ts := httptest.NewServer(s)
+
+	return NewClient(ts.URL, nil), func() {
+		ts.Close()
+		s.Close()
+		cleanup()
+	}
+}
+
+func expectStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	e, ok := err.(*Error)
+	if !ok || e.StatusCode != status {
+		t.Fatalf("expected status %d, got %v", status, err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	c, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	names, err := c.KvsNames(ctx)
+	if err != nil || len(names) != 1 || names[0] != serverTestKvsName {
+		t.Fatalf("unexpected kvs names %v: %v", names, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err = c.Put(ctx, serverTestKvsName, key, []byte{byte(i), 0xff}); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+
+	value, found, err := c.Get(ctx, serverTestKvsName, []byte("key3"))
+	if err != nil || !found || string(value) != "\x03\xff" {
+		t.Fatalf("got %x, %v for key3: %v", value, found, err)
+	}
+
+	if err = c.Delete(ctx, serverTestKvsName, []byte("key3")); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+	if _, found, err = c.Get(ctx, serverTestKvsName, []byte("key3")); err != nil || found {
+		t.Fatalf("found deleted key: %v", err)
+	}
+
+	resp, err := c.Scan(ctx, ScanRequest{Kvs: serverTestKvsName, Start: []byte("key1"), Limit: 2})
+	if err != nil {
+		t.Fatalf("failed to scan: %s", err)
+	}
+	if len(resp.Pairs) != 2 || string(resp.Pairs[0].Key) != "key1" || string(resp.Next) != "key4" {
+		t.Fatalf("unexpected scan page %+v", resp)
+	}
+
+	resp, err = c.Scan(ctx, ScanRequest{Kvs: serverTestKvsName, End: []byte("key4"), Reverse: true})
+	if err != nil {
+		t.Fatalf("failed to scan in reverse: %s", err)
+	}
+	if len(resp.Pairs) != 3 || string(resp.Pairs[0].Key) != "key2" || resp.Next != nil {
+		t.Fatalf("unexpected reverse scan page %+v", resp)
+	}
+
+	var keys []string
+	err = c.ScanStream(ctx, ScanRequest{Kvs: serverTestKvsName}, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil || strings.Join(keys, ",") != "key0,key1,key2,key4" {
+		t.Fatalf("streamed keys %v: %v", keys, err)
+	}
+
+	if err = c.PrefixDelete(ctx, serverTestKvsName, []byte("key")); err != nil {
+		t.Fatalf("failed to prefix delete: %s", err)
+	}
+	if resp, err = c.Scan(ctx, ScanRequest{Kvs: serverTestKvsName}); err != nil || len(resp.Pairs) != 0 {
+		t.Fatalf("keys remain after prefix delete: %+v: %v", resp, err)
+	}
+}
+
+func TestServerTxn(t *testing.T) {
+	c, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	txn, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	if err = txn.Put(ctx, serverTestKvsName, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+	if _, found, _ := c.Get(ctx, serverTestKvsName, []byte("a")); found {
+		t.Fatalf("uncommitted put is visible outside the transaction")
+	}
+	if value, found, err := txn.Get(ctx, serverTestKvsName, []byte("a")); err != nil || !found || string(value) != "1" {
+		t.Fatalf("transaction got %q, %v: %v", value, found, err)
+	}
+	if err = txn.Abort(ctx); err != nil {
+		t.Fatalf("failed to abort: %s", err)
+	}
+	expectStatus(t, txn.Commit(ctx), http.StatusNotFound)
+
+	err = c.Transact(ctx, func(txn *Txn) error {
+		return txn.Put(ctx, serverTestKvsName, []byte("b"), []byte("2"))
+	})
+	if err != nil {
+		t.Fatalf("failed to run transaction: %s", err)
+	}
+	if value, found, err := c.Get(ctx, serverTestKvsName, []byte("b")); err != nil || !found || string(value) != "2" {
+		t.Fatalf("got %q, %v after commit: %v", value, found, err)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	c, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	_, _, err := c.Get(ctx, "missing", []byte("key"))
+	expectStatus(t, err, http.StatusNotFound)
+
+	key := make([]byte, limits.KVS_KEY_LEN_MAX+1)
+	expectStatus(t, c.Put(ctx, serverTestKvsName, key, nil), http.StatusRequestEntityTooLarge)
+
+	value := make([]byte, limits.KVS_VALUE_LEN_MAX+1)
+	expectStatus(t, c.Put(ctx, serverTestKvsName, []byte("key"), value), http.StatusRequestEntityTooLarge)
+
+	_, _, err = (&Txn{c: c, id: 42}).Get(ctx, serverTestKvsName, []byte("key"))
+	expectStatus(t, err, http.StatusNotFound)
+
+	_, err = c.send(ctx, http.MethodGet, "/v1/put", nil)
+	expectStatus(t, err, http.StatusMethodNotAllowed)
+}
+
+// A KVS the application has open is shared with the server
+func TestServerSharedKvs(t *testing.T) {
+	kvdb, cleanup := hsetest.NewKvdb(t, serverTestKvsName)
+	defer cleanup()
+
+	kvs, err := kvdb.KvsOpen(serverTestKvsName, "transactions.enabled=true")
+	if err != nil {
+		t.Fatalf("failed to open kvs: %s", err)
+	}
+	defer kvs.Close()
+
+	err = kvdb.Transact(func(txn *hse.Transaction) error {
+		return txn.Put(kvs, []byte("key"), []byte("value"), 0)
+	})
+	if err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	s := New(kvdb)
+	ts := httptest.NewServer(s)
+	c := NewClient(ts.URL, nil)
+
+	value, found, err := c.Get(context.Background(), serverTestKvsName, []byte("key"))
+	if err != nil || !found || string(value) != "value" {
+		t.Fatalf("unexpected value %q through the server: %v", value, err)
+	}
+
+	ts.Close()
+	if err = s.Close(); err != nil {
+		t.Fatalf("failed to close server: %s", err)
+	}
+
+	if kvdb.KvsHandle(serverTestKvsName) != kvs {
+		t.Fatalf("server closed the application's handle")
+	}
+}
@@ -0,0 +1,219 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+const (
+	// STATS_VALUE_SIZE_BUCKETS is the number of buckets in
+	// KvsStats.ValueSizes
+	STATS_VALUE_SIZE_BUCKETS = 22
+	// STATS_SAMPLES is the number of samples Kvs.EstimateStats() takes by
+	// default
+	STATS_SAMPLES = 64
+	// STATS_SAMPLE_KEYS is the number of keys read per sample
+	STATS_SAMPLE_KEYS = 32
+)
+
+// KeyRange selects the keys of a Kvs
+//
+// Keys must match Prefix and fall in the range [Start, End). Nil fields do not
+// restrict the range.
+type KeyRange struct {
+	Prefix []byte
+	Start  []byte
+	End    []byte
+}
+
+// KvsStats describes the keys and values in a KeyRange
+type KvsStats struct {
+	// Keys is the number of keys
+	Keys uint64
+	// KeyBytes is the total length of the keys
+	KeyBytes uint64
+	// ValueBytes is the total length of the values
+	ValueBytes uint64
+	// ValueSizes is the distribution of value lengths. ValueSizes[0] counts
+	// empty values and ValueSizes[i] counts values whose length is in the
+	// range [2^(i-1), 2^i).
+	ValueSizes [STATS_VALUE_SIZE_BUCKETS]uint64
+	// Approximate is whether the stats were estimated from samples
+	Approximate bool
+}
+
+func (s *KvsStats) add(key []byte, value []byte) {
+	s.Keys++
+	s.KeyBytes += uint64(len(key))
+	s.ValueBytes += uint64(len(value))
+	s.ValueSizes[bits.Len(uint(len(value)))]++
+}
+
+// scan calls fn for every KV pair in the range until fn returns false
+func (k *Kvs) scan(r KeyRange, fn func(key []byte, value []byte) bool) error {
+	c, err := k.CreateCursor(r.Prefix, 0)
+	if err != nil {
+		return err
+	}
+	defer c.Destroy()
+
+	if err = c.seekRange(r.Start, r.End); err != nil {
+		return err
+	}
+
+	return c.readRange(r.End, 0, fn)
+}
+
+// Count counts the keys in the range with a cursor scan
+//
+// This function is thread safe.
+func (k *Kvs) Count(r KeyRange) (uint64, error) {
+	var count uint64
+
+	err := k.scan(r, func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+
+	return count, err
+}
+
+// Stats computes exact stats of the range with a cursor scan
+//
+// This function is thread safe.
+func (k *Kvs) Stats(r KeyRange) (KvsStats, error) {
+	var stats KvsStats
+
+	err := k.scan(r, func(key []byte, value []byte) bool {
+		stats.add(key, value)
+		return true
+	})
+
+	return stats, err
+}
+
+// keyPosition maps a key to its position in [0, 1) within the key space
+// under prefix, using the 8 bytes which follow the prefix
+func keyPosition(key []byte, prefix []byte) float64 {
+	var buf [8]byte
+
+	if len(key) > len(prefix) {
+		copy(buf[:], key[len(prefix):])
+	}
+
+	return float64(binary.BigEndian.Uint64(buf[:])) / (1 << 64)
+}
+
+// positionKey is the inverse of keyPosition
+func positionKey(pos float64, prefix []byte) []byte {
+	key := make([]byte, len(prefix)+8)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(pos*(1<<64)))
+
+	return key
+}
+
+// EstimateStats estimates the stats of the range by sampling
+//
+// Ranges of up to samples * STATS_SAMPLE_KEYS keys are scanned exactly.
+// Larger ranges are probed at samples evenly spaced positions, reading
+// STATS_SAMPLE_KEYS keys from each. The key count is extrapolated from the
+// density of keys at the probes, which assumes that the 8 bytes following
+// Prefix are spread evenly over the range, and the sizes are extrapolated from
+// the sampled KV pairs. A samples of 0 or less uses STATS_SAMPLES. This
+// function is thread safe.
+func (k *Kvs) EstimateStats(r KeyRange, samples int) (KvsStats, error) {
+	var stats KvsStats
+
+	if samples <= 0 {
+		samples = STATS_SAMPLES
+	}
+
+	limit := uint64(samples) * STATS_SAMPLE_KEYS
+
+	err := k.scan(r, func(key []byte, value []byte) bool {
+		stats.add(key, value)
+		return stats.Keys <= limit
+	})
+	if err != nil || stats.Keys <= limit {
+		return stats, err
+	}
+
+	lo, hi := 0.0, 1.0
+	if r.Start != nil {
+		lo = keyPosition(r.Start, r.Prefix)
+	}
+	if r.End != nil {
+		hi = keyPosition(r.End, r.Prefix)
+	}
+
+	var sampled KvsStats
+	var keys, span float64
+
+	c, err := k.CreateCursor(r.Prefix, 0)
+	if err != nil {
+		return KvsStats{}, err
+	}
+	defer c.Destroy()
+
+	for i := 0; i < samples; i++ {
+		pos := lo + (float64(i)+0.5)/float64(samples)*(hi-lo)
+
+		if err = c.seekRange(positionKey(pos, r.Prefix), r.End); err != nil {
+			return KvsStats{}, err
+		}
+
+		var n int
+		var last []byte
+
+		err = c.readRange(r.End, STATS_SAMPLE_KEYS, func(key []byte, value []byte) bool {
+			sampled.add(key, value)
+			last = append(last[:0], key...)
+			n++
+			return true
+		})
+		if err != nil {
+			return KvsStats{}, err
+		}
+
+		if n < STATS_SAMPLE_KEYS {
+			// The probe reached the end of the range, so it counted every key
+			// from its position on
+			keys += float64(n)
+			span += hi - pos
+		} else {
+			keys += float64(n - 1)
+			span += keyPosition(last, r.Prefix) - pos
+		}
+	}
+
+	// Keys which differ only after the sampled bytes defeat the estimate
+	if span <= 0 || sampled.Keys == 0 {
+		return k.Stats(r)
+	}
+
+	count := keys / span * (hi - lo)
+	if count < float64(limit) {
+		count = float64(limit)
+	}
+
+	scale := count / float64(sampled.Keys)
+
+	stats = KvsStats{
+		Keys:        uint64(math.Round(count)),
+		KeyBytes:    uint64(math.Round(float64(sampled.KeyBytes) * scale)),
+		ValueBytes:  uint64(math.Round(float64(sampled.ValueBytes) * scale)),
+		Approximate: true,
+	}
+	for i, n := range sampled.ValueSizes {
+		stats.ValueSizes[i] = uint64(math.Round(float64(n) * scale))
+	}
+
+	return stats, nil
+}
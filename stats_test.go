@@ -0,0 +1,97 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+const (
+	statsTestKvsName = "stats-test"
+)
+
+func TestStats(t *testing.T) {
+	kvs := makeAndOpenKvs(statsTestKvsName, params{})
+	defer kvdb.KvsDrop(statsTestKvsName)
+	defer kvs.Close()
+
+	for _, kv := range [][2]string{
+		{"a1", ""},
+		{"a2", "x"},
+		{"a3", "xyz"},
+		{"b1", "value"},
+	} {
+		if err := kvs.Put([]byte(kv[0]), []byte(kv[1]), 0); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+
+	count, err := kvs.Count(KeyRange{Prefix: []byte("a")})
+	if err != nil || count != 3 {
+		t.Fatalf("counted %d keys under a: %v", count, err)
+	}
+
+	count, err = kvs.Count(KeyRange{Start: []byte("a2"), End: []byte("b1")})
+	if err != nil || count != 2 {
+		t.Fatalf("counted %d keys in [a2, b1): %v", count, err)
+	}
+
+	stats, err := kvs.Stats(KeyRange{})
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err)
+	}
+
+	expected := KvsStats{Keys: 4, KeyBytes: 8, ValueBytes: 9}
+	expected.ValueSizes[0] = 1
+	expected.ValueSizes[1] = 1
+	expected.ValueSizes[2] = 1
+	expected.ValueSizes[3] = 1
+	if stats != expected {
+		t.Fatalf("stats are %+v, expected %+v", stats, expected)
+	}
+
+	// Small ranges are counted exactly
+	if stats, err = kvs.EstimateStats(KeyRange{}, 4); err != nil || stats != expected {
+		t.Fatalf("estimated stats are %+v, expected %+v: %v", stats, expected, err)
+	}
+}
+
+func TestEstimateStats(t *testing.T) {
+	kvs := makeAndOpenKvs(statsTestKvsName, params{})
+	defer kvdb.KvsDrop(statsTestKvsName)
+	defer kvs.Close()
+
+	const n = 5000
+
+	// Spread the keys evenly over the key space under the prefix
+	for i := uint64(0); i < n; i++ {
+		key := make([]byte, 9)
+		key[0] = 'u'
+		binary.BigEndian.PutUint64(key[1:], i*(^uint64(0)/n))
+
+		if err := kvs.Put(key, make([]byte, 100), 0); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+
+	stats, err := kvs.EstimateStats(KeyRange{Prefix: []byte("u")}, 16)
+	if err != nil {
+		t.Fatalf("failed to estimate stats: %s", err)
+	}
+	if !stats.Approximate {
+		t.Fatalf("stats of %d keys were not estimated", n)
+	}
+	if stats.Keys < n*9/10 || stats.Keys > n*11/10 {
+		t.Fatalf("estimated %d keys, expected about %d", stats.Keys, n)
+	}
+	if stats.ValueBytes < stats.Keys*100-100 || stats.ValueBytes > stats.Keys*100+100 {
+		t.Fatalf("estimated %d value bytes for %d keys of 100 bytes", stats.ValueBytes, stats.Keys)
+	}
+	if stats.ValueSizes[7] != stats.Keys {
+		t.Fatalf("value sizes %v do not put %d keys in bucket 7", stats.ValueSizes, stats.Keys)
+	}
+}
@@ -0,0 +1,263 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/hse-project/hse-go/limits"
+)
+
+// STREAM_VERSION is the version of the export stream format
+const STREAM_VERSION uint32 = 1
+
+var (
+	// ErrStreamFormat is returned when reading a stream which is not in the
+	// export stream format or is of an unsupported version
+	ErrStreamFormat = errors.New("hse: invalid stream format")
+	// ErrStreamChecksum is returned when a record or trailer of a stream does
+	// not match its checksum
+	ErrStreamChecksum = errors.New("hse: stream checksum mismatch")
+)
+
+// ProgressFunc is called periodically while a stream is written or read with
+// the number of key-value pairs and bytes processed so far
+type ProgressFunc func(keys uint64, bytes uint64)
+
+// The export stream is laid out as follows. All integers are big-endian and
+// all checksums are CRC-32C.
+//
+//	header:  magic[8] version(u32)
+//	record:  'R' keyLen(u32) valueLen(u32) key value crc(u32)
+//	trailer: 'E' count(u64) crc(u32)
+//
+// A record's crc covers the record from its tag through its value. The
+// trailer's crc covers every byte of the stream which precedes it.
+var streamMagic = [8]byte{'H', 'S', 'E', 'G', 'O', 'K', 'V', 'S'}
+
+const (
+	streamTagRecord  byte = 'R'
+	streamTagTrailer byte = 'E'
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// streamProgressInterval is the number of records between progress callbacks
+const streamProgressInterval = 1024
+
+type streamWriter struct {
+	w        *bufio.Writer
+	stream   hash.Hash32
+	record   hash.Hash32
+	count    uint64
+	bytes    uint64
+	progress ProgressFunc
+}
+
+func newStreamWriter(w io.Writer, progress ProgressFunc) *streamWriter {
+	return &streamWriter{
+		w:        bufio.NewWriter(w),
+		stream:   crc32.New(castagnoli),
+		record:   crc32.New(castagnoli),
+		progress: progress,
+	}
+}
+
+func (s *streamWriter) write(p []byte) error {
+	s.stream.Write(p)
+	s.record.Write(p)
+	_, err := s.w.Write(p)
+
+	return err
+}
+
+func (s *streamWriter) writeHeader() error {
+	var buf [12]byte
+
+	copy(buf[:], streamMagic[:])
+	binary.BigEndian.PutUint32(buf[8:], STREAM_VERSION)
+
+	return s.write(buf[:])
+}
+
+func (s *streamWriter) writeRecord(key, value []byte) error {
+	var buf [9]byte
+
+	s.record.Reset()
+
+	buf[0] = streamTagRecord
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[5:], uint32(len(value)))
+
+	if err := s.write(buf[:]); err != nil {
+		return err
+	}
+	if err := s.write(key); err != nil {
+		return err
+	}
+	if err := s.write(value); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(buf[:4], s.record.Sum32())
+	if err := s.write(buf[:4]); err != nil {
+		return err
+	}
+
+	s.count++
+	s.bytes += uint64(len(key) + len(value))
+	if s.progress != nil && s.count%streamProgressInterval == 0 {
+		s.progress(s.count, s.bytes)
+	}
+
+	return nil
+}
+
+func (s *streamWriter) writeTrailer() error {
+	var buf [13]byte
+
+	buf[0] = streamTagTrailer
+	binary.BigEndian.PutUint64(buf[1:], s.count)
+	if err := s.write(buf[:9]); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(buf[:4], s.stream.Sum32())
+	if err := s.write(buf[:4]); err != nil {
+		return err
+	}
+
+	if s.progress != nil {
+		s.progress(s.count, s.bytes)
+	}
+
+	return s.w.Flush()
+}
+
+type streamReader struct {
+	r        io.Reader
+	stream   hash.Hash32
+	record   hash.Hash32
+	count    uint64
+	bytes    uint64
+	progress ProgressFunc
+}
+
+// newStreamReader wraps r, which should already be buffered if reads from it
+// are expensive
+func newStreamReader(r io.Reader, progress ProgressFunc) *streamReader {
+	return &streamReader{
+		r:        r,
+		stream:   crc32.New(castagnoli),
+		record:   crc32.New(castagnoli),
+		progress: progress,
+	}
+}
+
+func (s *streamReader) read(p []byte) error {
+	if _, err := io.ReadFull(s.r, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	s.stream.Write(p)
+	s.record.Write(p)
+
+	return nil
+}
+
+func (s *streamReader) readHeader() error {
+	var buf [12]byte
+
+	if err := s.read(buf[:]); err != nil {
+		return err
+	}
+
+	var magic [8]byte
+	copy(magic[:], buf[:8])
+	if magic != streamMagic || binary.BigEndian.Uint32(buf[8:]) != STREAM_VERSION {
+		return ErrStreamFormat
+	}
+
+	return nil
+}
+
+// readRecord returns the next key-value pair of the stream, or io.EOF once the
+// trailer has been read and verified
+func (s *streamReader) readRecord() ([]byte, []byte, error) {
+	var buf [9]byte
+
+	s.record.Reset()
+
+	if err := s.read(buf[:1]); err != nil {
+		return nil, nil, err
+	}
+
+	switch buf[0] {
+	case streamTagRecord:
+	case streamTagTrailer:
+		if err := s.read(buf[1:9]); err != nil {
+			return nil, nil, err
+		}
+		count := binary.BigEndian.Uint64(buf[1:9])
+
+		// The trailer's crc does not cover itself
+		streamSum := s.stream.Sum32()
+
+		if err := s.read(buf[:4]); err != nil {
+			return nil, nil, err
+		}
+		if binary.BigEndian.Uint32(buf[:4]) != streamSum || count != s.count {
+			return nil, nil, ErrStreamChecksum
+		}
+
+		if s.progress != nil {
+			s.progress(s.count, s.bytes)
+		}
+
+		return nil, nil, io.EOF
+	default:
+		return nil, nil, ErrStreamFormat
+	}
+
+	if err := s.read(buf[1:9]); err != nil {
+		return nil, nil, err
+	}
+
+	keyLen := binary.BigEndian.Uint32(buf[1:5])
+	valueLen := binary.BigEndian.Uint32(buf[5:9])
+	if keyLen == 0 || uint(keyLen) > limits.KVS_KEY_LEN_MAX || uint(valueLen) > limits.KVS_VALUE_LEN_MAX {
+		return nil, nil, ErrStreamFormat
+	}
+
+	data := make([]byte, keyLen+valueLen)
+	if err := s.read(data); err != nil {
+		return nil, nil, err
+	}
+
+	sum := s.record.Sum32()
+	if err := s.read(buf[:4]); err != nil {
+		return nil, nil, err
+	}
+	if binary.BigEndian.Uint32(buf[:4]) != sum {
+		return nil, nil, ErrStreamChecksum
+	}
+
+	s.count++
+	s.bytes += uint64(len(data))
+	if s.progress != nil && s.count%streamProgressInterval == 0 {
+		s.progress(s.count, s.bytes)
+	}
+
+	return data[:keyLen:keyLen], data[keyLen:], nil
+}
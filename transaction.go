@@ -7,6 +7,12 @@ package hse
 
 // #include <hse/hse.h>
 import "C"
+import (
+	"context"
+	"math/rand"
+	"syscall"
+	"time"
+)
 
 // TransactionState represents the states a transaction can exist in
 type TransactionState int
@@ -22,6 +28,19 @@ const (
 	TransactionAborted TransactionState = C.HSE_KVDB_TXN_ABORTED
 )
 
+// TRANSACTION_RETRY_MAX is the number of times Kvdb.Transact() retries a
+// transaction which conflicted with another transaction
+const TRANSACTION_RETRY_MAX = 16
+
+const (
+	// TRANSACTION_BACKOFF_MIN is the longest Kvdb.Transact() waits before the
+	// first retry of a transaction
+	TRANSACTION_BACKOFF_MIN = 100 * time.Microsecond
+	// TRANSACTION_BACKOFF_MAX bounds how long Kvdb.Transact() waits before
+	// retrying a transaction
+	TRANSACTION_BACKOFF_MAX = 50 * time.Millisecond
+)
+
 // Transaction represents a context in which multiple operations will be run
 //
 // The HSE KVDB provides transactions with operations spanning KVSs within a
@@ -72,6 +91,10 @@ const (
 type Transaction struct {
 	impl *C.struct_hse_kvdb_txn
 	kvdb *Kvdb
+	ctx  context.Context
+
+	// pending is shared with the copies made by WithContext()
+	pending *pendingChanges
 }
 
 // Free frees transaction object
@@ -94,12 +117,18 @@ func (t *Transaction) Free() {
 // The call fails if the transaction handle refers to an ACTIVE transaction.
 // This function is thread safe with different transactions.
 func (t *Transaction) Begin() error {
-	err := C.hse_kvdb_txn_begin(t.kvdb.impl, t.impl)
-	if err != 0 {
-		return hseErrToErrno(err)
+	tr := t.kvdb.startOp(t.ctx, "txn_begin", "", true, 0, 0)
+
+	var err error
+	if rc := C.hse_kvdb_txn_begin(t.kvdb.impl, t.impl); rc != 0 {
+		err = hseErrToErrno(rc)
+	} else {
+		t.pending.take()
 	}
 
-	return nil
+	tr.done(0, 0, err)
+
+	return err
 }
 
 // Commit commits all the mutations of the referenced transaction
@@ -107,9 +136,36 @@ func (t *Transaction) Begin() error {
 // The call fails if the referenced transaction is not in the ACTIVE state. This
 // function is thread safe with different transactions.
 func (t *Transaction) Commit() error {
-	err := C.hse_kvdb_txn_commit(t.kvdb.impl, t.impl)
-	if err != 0 {
-		return hseErrToErrno(err)
+	tr := t.kvdb.startOp(t.ctx, "txn_commit", "", true, 0, 0)
+
+	// The changes stay pending if the commit fails, so that retrying it logs
+	// them, until the transaction is aborted or begun again
+	changes := t.pending.peek()
+	if len(changes) > 0 {
+		t.kvdb.feed.order.Lock()
+		defer t.kvdb.feed.order.Unlock()
+	}
+
+	var err error
+	if len(changes) > 0 && t.kvdb.cdc != nil {
+		err = t.kvdb.cdc.commit(t, changes)
+	} else {
+		err = t.commit()
+	}
+
+	if err == nil && len(changes) > 0 {
+		t.pending.take()
+		t.kvdb.feed.publish(changes)
+	}
+
+	tr.done(0, 0, err)
+
+	return err
+}
+
+func (t *Transaction) commit() error {
+	if rc := C.hse_kvdb_txn_commit(t.kvdb.impl, t.impl); rc != 0 {
+		return hseErrToErrno(rc)
 	}
 
 	return nil
@@ -120,12 +176,18 @@ func (t *Transaction) Commit() error {
 // The call fails if the referenced transaction is not in the ACTIVE state. This
 // function is thread safe with different transactions.
 func (t *Transaction) Abort() error {
-	err := C.hse_kvdb_txn_abort(t.kvdb.impl, t.impl)
-	if err != 0 {
-		return hseErrToErrno(err)
+	tr := t.kvdb.startOp(t.ctx, "txn_abort", "", true, 0, 0)
+
+	var err error
+	if rc := C.hse_kvdb_txn_abort(t.kvdb.impl, t.impl); rc != 0 {
+		err = hseErrToErrno(rc)
+	} else {
+		t.pending.take()
 	}
 
-	return nil
+	tr.done(0, 0, err)
+
+	return err
 }
 
 // State retrieves the state of the referenced transaction
@@ -134,3 +196,103 @@ func (t *Transaction) Abort() error {
 func (t *Transaction) State() TransactionState {
 	return TransactionState(C.hse_kvdb_txn_state_get(t.kvdb.impl, t.impl))
 }
+
+func (t *Transaction) cimpl() *C.struct_hse_kvdb_txn {
+	if t == nil {
+		return nil
+	}
+
+	return t.impl
+}
+
+// Put places a KV pair into a Kvs within the context of the transaction
+//
+// Mutations within transactions require the Kvs to be opened with
+// "transactions.enabled=true", and such a Kvs only accepts mutations within
+// transactions. See Kvs.Put().
+func (t *Transaction) Put(kvs *Kvs, key, value []byte, flags PutFlags) error {
+	return kvs.put(t, key, value, flags)
+}
+
+// Get retrieves the value for a given key from a Kvs within the context of the
+// transaction
+//
+// Mutations made by the transaction are visible. See Kvs.Get().
+func (t *Transaction) Get(kvs *Kvs, key []byte, flags GetFlags) ([]byte, uint, error) {
+	return kvs.get(t, key, flags)
+}
+
+// Delete deletes a key from a Kvs within the context of the transaction
+//
+// See Transaction.Put() and Kvs.Delete().
+func (t *Transaction) Delete(kvs *Kvs, key []byte, flags DeleteFlags) error {
+	return kvs.delete(t, key, flags)
+}
+
+// PrefixDelete deletes all KV pairs matching the key prefix from a Kvs within
+// the context of the transaction
+//
+// See Transaction.Put() and Kvs.PrefixDelete().
+func (t *Transaction) PrefixDelete(kvs *Kvs, filt []byte, flags PrefixDeleteFlags) error {
+	return kvs.prefixDelete(t, filt, flags)
+}
+
+// CreateCursor creates a cursor over a Kvs which takes on the transaction's
+// snapshot
+//
+// See Kvs.CreateCursor().
+func (t *Transaction) CreateCursor(kvs *Kvs, filt []byte, flags CursorCreateFlag) (*Cursor, error) {
+	return kvs.createCursor(t, filt, flags)
+}
+
+// Transact runs fn within a transaction and commits it if fn returns nil
+//
+// If fn returns an error, the transaction is aborted and the error is returned.
+// When an operation or the commit fails with syscall.ECANCELED because the
+// transaction conflicted with another transaction, the whole transaction
+// including fn is retried up to TRANSACTION_RETRY_MAX times. fn must therefore
+// be safe to call more than once. Before each retry, Transact() sleeps for a
+// random duration up to a limit which doubles from TRANSACTION_BACKOFF_MIN to
+// TRANSACTION_BACKOFF_MAX, so that conflicting transactions do not keep
+// colliding. This function is thread safe.
+func (k *Kvdb) Transact(fn func(txn *Transaction) error) error {
+	txn := k.NewTransaction()
+	if txn == nil {
+		return syscall.ENOMEM
+	}
+	defer txn.Free()
+
+	for retries := 0; ; retries++ {
+		if err := txn.Begin(); err != nil {
+			return err
+		}
+
+		err := fn(txn)
+		if err == nil {
+			err = txn.Commit()
+		}
+		if err == nil {
+			return nil
+		}
+
+		if txn.State() == TransactionActive {
+			txn.Abort()
+		}
+
+		if err != syscall.ECANCELED || retries == TRANSACTION_RETRY_MAX {
+			return err
+		}
+
+		time.Sleep(transactBackoff(retries))
+	}
+}
+
+// transactBackoff returns a random duration to wait before the given retry
+func transactBackoff(retries int) time.Duration {
+	limit := TRANSACTION_BACKOFF_MAX
+	if retries < 16 && TRANSACTION_BACKOFF_MIN<<uint(retries) < limit {
+		limit = TRANSACTION_BACKOFF_MIN << uint(retries)
+	}
+
+	return time.Duration(rand.Int63n(int64(limit)) + 1)
+}
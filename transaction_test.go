@@ -5,7 +5,12 @@
 
 package hse
 
-import "testing"
+import (
+	"syscall"
+	"testing"
+)
+
+const transactionTestKvsName = "transaction-test"
 
 func TestTransactionStates(t *testing.T) {
 	txn := kvdb.NewTransaction()
@@ -42,3 +47,92 @@ func TestTransactionStates(t *testing.T) {
 		t.Fatal("txn state is not committed")
 	}
 }
+
+func TestTransactionOperations(t *testing.T) {
+	kvs := makeAndOpenKvs(transactionTestKvsName, txnParams)
+	defer kvdb.KvsDrop(transactionTestKvsName)
+	defer kvs.Close()
+
+	txn := kvdb.NewTransaction()
+	defer txn.Free()
+
+	if err := txn.Begin(); err != nil {
+		t.Fatalf("failed to begin txn: %s", err)
+	}
+
+	if err := txn.Put(kvs, []byte("txn-key"), []byte("value"), 0); err != nil {
+		t.Fatalf("failed to put key in txn: %s", err)
+	}
+
+	value, _, err := txn.Get(kvs, []byte("txn-key"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key in txn: %s", err)
+	}
+	if string(value) != "value" {
+		t.Fatalf("transaction does not see its own put (%s)", value)
+	}
+
+	value, _, err = kvs.Get([]byte("txn-key"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key: %s", err)
+	}
+	if value != nil {
+		t.Fatal("uncommitted put is visible outside of the transaction")
+	}
+
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("failed to commit txn: %s", err)
+	}
+
+	value, _, err = kvs.Get([]byte("txn-key"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key: %s", err)
+	}
+	if string(value) != "value" {
+		t.Fatal("committed put is not visible")
+	}
+}
+
+func TestTransact(t *testing.T) {
+	kvs := makeAndOpenKvs(transactionTestKvsName, txnParams)
+	defer kvdb.KvsDrop(transactionTestKvsName)
+	defer kvs.Close()
+
+	err := kvdb.Transact(func(txn *Transaction) error {
+		return txn.Put(kvs, []byte("transact"), []byte("value"), 0)
+	})
+	if err != nil {
+		t.Fatalf("failed to run transaction: %s", err)
+	}
+
+	value, _, err := kvs.Get([]byte("transact"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key: %s", err)
+	}
+	if string(value) != "value" {
+		t.Fatal("transaction was not committed")
+	}
+}
+
+func TestTransactRetry(t *testing.T) {
+	calls := 0
+	err := kvdb.Transact(func(txn *Transaction) error {
+		calls++
+		return syscall.ECANCELED
+	})
+	if err != syscall.ECANCELED {
+		t.Fatalf("expected ECANCELED, got %v", err)
+	}
+	if calls != TRANSACTION_RETRY_MAX+1 {
+		t.Fatalf("expected %d attempts, got %d", TRANSACTION_RETRY_MAX+1, calls)
+	}
+
+	for retries := 0; retries < 64; retries++ {
+		if d := transactBackoff(retries); d <= 0 || d > TRANSACTION_BACKOFF_MAX {
+			t.Fatalf("backoff %s out of range for retry %d", d, retries)
+		}
+	}
+	if d := transactBackoff(0); d > TRANSACTION_BACKOFF_MIN {
+		t.Fatalf("first backoff %s exceeds %s", d, TRANSACTION_BACKOFF_MIN)
+	}
+}
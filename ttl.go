@@ -0,0 +1,481 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// TTL_HEADER_LEN is the number of bytes prepended to every value written
+	// through a TtlKvs
+	TTL_HEADER_LEN uint = 8
+	// TTL_INDEX_PFX_LEN is the prefix length the index Kvs of a TtlKvs must be
+	// created with ("prefix.length=8")
+	TTL_INDEX_PFX_LEN uint = 8
+)
+
+// TtlStats are counters describing the work done by a TtlKvs reaper
+type TtlStats struct {
+	// Passes is the number of reaper passes which have run
+	Passes uint64
+	// Reclaimed is the number of expired keys which have been deleted
+	Reclaimed uint64
+	// BucketsDropped is the number of index buckets which have been deleted
+	BucketsDropped uint64
+	// Errors is the number of reaper passes which failed
+	Errors uint64
+}
+
+// TtlKvs layers time-to-live semantics over a Kvs
+//
+// Every value is stored with its expiration time in front of it. Expired
+// values are hidden from Get() and from cursors created through the TtlKvs. A
+// second Kvs, the index, records which keys expire in which time bucket. The
+// index must be created with a prefix length of TTL_INDEX_PFX_LEN so that the
+// reaper can drop an entire bucket with a single prefix delete once all of its
+// keys have been reclaimed. Both Kvs are only written within transactions, so
+// they must be opened with "transactions.enabled=true".
+type TtlKvs struct {
+	stats TtlStats
+
+	kvs    *Kvs
+	index  *Kvs
+	bucket time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// TtlCursor is a Cursor which skips expired key-value pairs
+type TtlCursor struct {
+	*Cursor
+	ttl *TtlKvs
+
+	// pending is the pair a seek read ahead to find an unexpired key, which
+	// the next read returns
+	pending      bool
+	pendingKey   []byte
+	pendingValue []byte
+}
+
+// NewTtlKvs creates a TtlKvs storing values in kvs and expiration buckets of the
+// given width in index
+func NewTtlKvs(kvs *Kvs, index *Kvs, bucket time.Duration) *TtlKvs {
+	if bucket <= 0 {
+		bucket = time.Minute
+	}
+
+	return &TtlKvs{
+		kvs:    kvs,
+		index:  index,
+		bucket: bucket,
+		now:    time.Now,
+	}
+}
+
+func (t *TtlKvs) bucketPrefix(expiry int64) []byte {
+	pfx := make([]byte, TTL_INDEX_PFX_LEN)
+	binary.BigEndian.PutUint64(pfx, uint64(expiry/int64(t.bucket)))
+
+	return pfx
+}
+
+// expired reports whether a stored value is expired, along with the value
+// stripped of its header
+func (t *TtlKvs) expired(stored []byte, now int64) ([]byte, bool) {
+	if uint(len(stored)) < TTL_HEADER_LEN {
+		return nil, true
+	}
+
+	expiry := int64(binary.BigEndian.Uint64(stored))
+	if expiry != 0 && expiry <= now {
+		return nil, true
+	}
+
+	return stored[TTL_HEADER_LEN:], false
+}
+
+// Put places a KV pair into the TtlKvs which expires after ttl
+//
+// A ttl less than or equal to zero means that the value never expires. The
+// value and its index entry are written in one transaction. This function is
+// thread safe.
+func (t *TtlKvs) Put(key, value []byte, ttl time.Duration, flags PutFlags) error {
+	var expiry int64
+	var idx []byte
+
+	if ttl > 0 {
+		expiry = t.now().Add(ttl).UnixNano()
+		idx = append(t.bucketPrefix(expiry), key...)
+	}
+
+	stored := make([]byte, TTL_HEADER_LEN, TTL_HEADER_LEN+uint(len(value)))
+	binary.BigEndian.PutUint64(stored, uint64(expiry))
+	stored = append(stored, value...)
+
+	return t.kvs.kvdb.Transact(func(txn *Transaction) error {
+		if idx != nil {
+			if err := txn.Put(t.index, idx, nil, 0); err != nil {
+				return err
+			}
+		}
+
+		return txn.Put(t.kvs, key, stored, flags)
+	})
+}
+
+// Get retrieves the value for a given key from the TtlKvs
+//
+// Expired values are reported as not found. This function is thread safe.
+func (t *TtlKvs) Get(key []byte, flags GetFlags) ([]byte, uint, error) {
+	stored, _, err := t.kvs.Get(key, flags)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	value, expired := t.expired(stored, t.now().UnixNano())
+	if expired {
+		return nil, 0, nil
+	}
+
+	return value, uint(len(value)), nil
+}
+
+// Delete deletes the key and its associated value from the TtlKvs
+//
+// The index entry, if any, is left to the reaper. This function is thread safe.
+func (t *TtlKvs) Delete(key []byte, flags DeleteFlags) error {
+	return t.kvs.kvdb.Transact(func(txn *Transaction) error {
+		return txn.Delete(t.kvs, key, flags)
+	})
+}
+
+// CreateCursor creates a cursor which iterates over unexpired KV pairs
+//
+// See Kvs.CreateCursor().
+func (t *TtlKvs) CreateCursor(filt []byte, flags CursorCreateFlag) (*TtlCursor, error) {
+	c, err := t.kvs.CreateCursor(filt, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TtlCursor{Cursor: c, ttl: t}, nil
+}
+
+// Read reads the next unexpired KV pair from the cursor
+func (c *TtlCursor) Read(flags CursorReadFlags) ([]byte, []byte, error) {
+	if c.pending {
+		c.pending = false
+		return c.pendingKey, c.pendingValue, nil
+	}
+
+	now := c.ttl.now().UnixNano()
+
+	for {
+		key, stored, err := c.Cursor.Read(flags)
+		if err != nil || c.Eof() {
+			return key, nil, err
+		}
+
+		if value, expired := c.ttl.expired(stored, now); !expired {
+			return key, value, nil
+		}
+	}
+}
+
+// ReadBatch reads up to n unexpired KV pairs from the cursor
+//
+// Expired pairs are skipped after they are read, so fewer than n pairs may be
+// returned before EOF. A pair read ahead by a seek is copied into memory of its
+// own. See Cursor.ReadBatch().
+func (c *TtlCursor) ReadBatch(n int, buf []byte, flags CursorReadFlags) ([]KeyValue, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	var pairs []KeyValue
+	if c.pending {
+		c.pending = false
+		pairs = append(pairs, KeyValue{
+			Key:   append([]byte(nil), c.pendingKey...),
+			Value: append([]byte{}, c.pendingValue...),
+		})
+		if n--; n == 0 {
+			return pairs, nil
+		}
+	}
+
+	batch, err := c.Cursor.ReadBatch(n, buf, flags)
+
+	now := c.ttl.now().UnixNano()
+	for _, pair := range batch {
+		if value, expired := c.ttl.expired(pair.Value, now); !expired {
+			pairs = append(pairs, KeyValue{Key: pair.Key, Value: value})
+		}
+	}
+
+	return pairs, err
+}
+
+// ForEach calls fn with every remaining unexpired KV pair of the cursor in
+// order
+//
+// See Cursor.ForEach().
+func (c *TtlCursor) ForEach(fn func(key []byte, value []byte) error) error {
+	return forEach(c, fn)
+}
+
+// Seek moves the cursor to the first unexpired key at or after key
+//
+// The key the cursor is positioned at is returned, or nil at EOF. See
+// Cursor.Seek().
+func (c *TtlCursor) Seek(key []byte, flags CursorSeekFlags) ([]byte, error) {
+	c.pending = false
+
+	found, err := c.Cursor.Seek(key, flags)
+	if err != nil || found == nil {
+		return found, err
+	}
+
+	return c.skipExpired()
+}
+
+// SeekRange moves the cursor to the first unexpired key within the range
+//
+// The key the cursor is positioned at is returned, or nil at EOF. See
+// Cursor.SeekRange().
+func (c *TtlCursor) SeekRange(filtMin []byte, filtMax []byte, flags CursorSeekRangeFlags) ([]byte, error) {
+	c.pending = false
+
+	found, err := c.Cursor.SeekRange(filtMin, filtMax, flags)
+	if err != nil || found == nil {
+		return found, err
+	}
+
+	return c.skipExpired()
+}
+
+// skipExpired reads past expired pairs after a seek, keeping the first
+// unexpired pair for the next read
+func (c *TtlCursor) skipExpired() ([]byte, error) {
+	key, value, err := c.Read(0)
+	if err != nil || c.Eof() {
+		return nil, err
+	}
+
+	c.pending = true
+	c.pendingKey = key
+	c.pendingValue = value
+
+	return key, nil
+}
+
+// Reap deletes expired keys from every index bucket which has fully expired
+//
+// Keys are deleted in batches of at most batch keys, after which the bucket is
+// removed from the index with a single prefix delete. The number of keys which
+// were reclaimed is returned.
+func (t *TtlKvs) Reap(batch int) (uint64, error) {
+	var reclaimed uint64
+
+	atomic.AddUint64(&t.stats.Passes, 1)
+
+	if batch <= 0 {
+		batch = 1
+	}
+
+	current := binary.BigEndian.Uint64(t.bucketPrefix(t.now().UnixNano()))
+
+	for {
+		pfx, err := t.oldestBucket()
+		if err != nil {
+			atomic.AddUint64(&t.stats.Errors, 1)
+			return reclaimed, err
+		}
+		if pfx == nil || binary.BigEndian.Uint64(pfx) >= current {
+			return reclaimed, nil
+		}
+
+		n, err := t.reapBucket(pfx, batch)
+		reclaimed += n
+		if err != nil {
+			atomic.AddUint64(&t.stats.Errors, 1)
+			return reclaimed, err
+		}
+	}
+}
+
+// oldestBucket returns the prefix of the oldest bucket in the index, or nil if
+// the index is empty
+func (t *TtlKvs) oldestBucket() ([]byte, error) {
+	c, err := t.index.CreateCursor(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Destroy()
+
+	key, _, err := c.Read(0)
+	if err != nil || c.Eof() {
+		return nil, err
+	}
+
+	return append([]byte(nil), key[:TTL_INDEX_PFX_LEN]...), nil
+}
+
+func (t *TtlKvs) reapBucket(pfx []byte, batch int) (uint64, error) {
+	var reclaimed uint64
+
+	c, err := t.index.CreateCursor(pfx, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	now := t.now().UnixNano()
+	keys := make([][]byte, 0, batch)
+
+	flush := func() error {
+		var n uint64
+
+		// The check and the delete are made in one transaction so that a key
+		// rewritten with a later expiration in the meantime is kept
+		err := t.kvs.kvdb.Transact(func(txn *Transaction) error {
+			n = 0
+
+			for _, key := range keys {
+				stored, _, err := txn.Get(t.kvs, key, 0)
+				if err != nil {
+					return err
+				}
+
+				if len(stored) == 0 {
+					continue
+				}
+
+				if _, expired := t.expired(stored, now); !expired {
+					continue
+				}
+
+				if err = txn.Delete(t.kvs, key, 0); err != nil {
+					return err
+				}
+
+				n++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		reclaimed += n
+		atomic.AddUint64(&t.stats.Reclaimed, n)
+
+		keys = keys[:0]
+
+		return nil
+	}
+
+	err = c.ForEach(func(key []byte, value []byte) error {
+		keys = append(keys, append([]byte(nil), key[TTL_INDEX_PFX_LEN:]...))
+		if len(keys) == batch {
+			return flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.Destroy()
+		return reclaimed, err
+	}
+
+	// Destroy the filtered cursor before the prefix delete, which could
+	// otherwise fail the iteration
+	c.Destroy()
+
+	if err = flush(); err != nil {
+		return reclaimed, err
+	}
+
+	err = t.kvs.kvdb.Transact(func(txn *Transaction) error {
+		return txn.PrefixDelete(t.index, pfx, 0)
+	})
+	if err != nil {
+		return reclaimed, err
+	}
+
+	atomic.AddUint64(&t.stats.BucketsDropped, 1)
+
+	return reclaimed, nil
+}
+
+// StartReaper starts a background goroutine which calls Reap() every interval
+//
+// An interval less than or equal to zero reaps once per bucket width. Calling
+// StartReaper() on a TtlKvs with a running reaper is a no-op.
+func (t *TtlKvs) StartReaper(interval time.Duration, batch int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stop != nil {
+		return
+	}
+
+	if interval <= 0 {
+		interval = t.bucket
+	}
+
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				t.Reap(batch)
+			}
+		}
+	}(t.stop, t.done)
+}
+
+// StopReaper stops the background reaper and waits for it to exit
+func (t *TtlKvs) StopReaper() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stop == nil {
+		return
+	}
+
+	close(t.stop)
+	<-t.done
+
+	t.stop = nil
+	t.done = nil
+}
+
+// Stats returns a snapshot of the reaper counters
+func (t *TtlKvs) Stats() TtlStats {
+	return TtlStats{
+		Passes:         atomic.LoadUint64(&t.stats.Passes),
+		Reclaimed:      atomic.LoadUint64(&t.stats.Reclaimed),
+		BucketsDropped: atomic.LoadUint64(&t.stats.BucketsDropped),
+		Errors:         atomic.LoadUint64(&t.stats.Errors),
+	}
+}
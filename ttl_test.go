@@ -0,0 +1,244 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package hse
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	ttlTestKvsName      = "ttl-test"
+	ttlTestIndexKvsName = "ttl-test-index"
+)
+
+func TestTtl(t *testing.T) {
+	var indexParams params
+
+	indexParams.SetCparams(fmt.Sprintf("prefix.length=%d", TTL_INDEX_PFX_LEN))
+	indexParams.SetRparams(txnParams.Rparams...)
+
+	kvs := makeAndOpenKvs(ttlTestKvsName, txnParams)
+	defer kvdb.KvsDrop(ttlTestKvsName)
+	defer kvs.Close()
+
+	index := makeAndOpenKvs(ttlTestIndexKvsName, indexParams)
+	defer kvdb.KvsDrop(ttlTestIndexKvsName)
+	defer index.Close()
+
+	now := time.Unix(1000, 0)
+
+	ttl := NewTtlKvs(kvs, index, time.Second)
+	ttl.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		if err := ttl.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), time.Duration(i+1)*time.Second, 0); err != nil {
+			t.Fatalf("failed to put key%d: %s", i, err)
+		}
+	}
+	if err := ttl.Put([]byte("forever"), []byte("value"), 0, 0); err != nil {
+		t.Fatalf("failed to put forever: %s", err)
+	}
+
+	now = now.Add(5 * time.Second)
+
+	value, _, err := ttl.Get([]byte("key2"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key2: %s", err)
+	}
+	if value != nil {
+		t.Fatalf("expired key2 is visible: %s", value)
+	}
+
+	value, _, err = ttl.Get([]byte("key7"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key7: %s", err)
+	}
+	if string(value) != "value7" {
+		t.Fatalf("unexpected value for key7: %s", value)
+	}
+
+	c, err := ttl.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+
+	var visible int
+	for {
+		_, _, err := c.Read(0)
+		if err != nil {
+			t.Fatalf("failed to read cursor: %s", err)
+		}
+		if c.Eof() {
+			break
+		}
+		visible++
+	}
+	c.Destroy()
+
+	if visible != 6 {
+		t.Fatalf("expected 6 unexpired keys through cursor, got %d", visible)
+	}
+
+	c, err = ttl.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+
+	// key0 through key4 have expired
+	found, err := c.Seek([]byte("key0"), 0)
+	if err != nil {
+		t.Fatalf("failed to seek: %s", err)
+	}
+	if string(found) != "key5" {
+		t.Fatalf("seek landed on %s instead of key5", found)
+	}
+
+	key, value, err := c.Read(0)
+	if err != nil {
+		t.Fatalf("failed to read cursor: %s", err)
+	}
+	if string(key) != "key5" || string(value) != "value5" {
+		t.Fatalf("unexpected pair (%s, %s) after seek", key, value)
+	}
+	c.Destroy()
+
+	reclaimed, err := ttl.Reap(2)
+	if err != nil {
+		t.Fatalf("failed to reap: %s", err)
+	}
+	if reclaimed != 4 {
+		t.Fatalf("expected 4 reclaimed keys, got %d", reclaimed)
+	}
+
+	stats := ttl.Stats()
+	if stats.Reclaimed != 4 || stats.BucketsDropped != 4 {
+		t.Fatalf("unexpected reaper stats: %+v", stats)
+	}
+
+	stored, _, err := kvs.Get([]byte("key0"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key0: %s", err)
+	}
+	if len(stored) != 0 {
+		t.Fatal("key0 was not reclaimed")
+	}
+}
+
+func TestTtlReaperInterval(t *testing.T) {
+	var indexParams params
+
+	indexParams.SetCparams(fmt.Sprintf("prefix.length=%d", TTL_INDEX_PFX_LEN))
+	indexParams.SetRparams(txnParams.Rparams...)
+
+	kvs := makeAndOpenKvs(ttlTestKvsName, txnParams)
+	defer kvdb.KvsDrop(ttlTestKvsName)
+	defer kvs.Close()
+
+	index := makeAndOpenKvs(ttlTestIndexKvsName, indexParams)
+	defer kvdb.KvsDrop(ttlTestIndexKvsName)
+	defer index.Close()
+
+	var now int64
+
+	ttl := NewTtlKvs(kvs, index, 10*time.Millisecond)
+	ttl.now = func() time.Time { return time.Unix(0, atomic.LoadInt64(&now)) }
+
+	if err := ttl.Put([]byte("key"), []byte("value"), time.Millisecond, 0); err != nil {
+		t.Fatalf("failed to put key: %s", err)
+	}
+
+	atomic.StoreInt64(&now, int64(time.Second))
+
+	// A non-positive interval falls back to the bucket width, so the reaper
+	// runs well within the deadline
+	ttl.StartReaper(0, 16)
+	defer ttl.StopReaper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ttl.Stats().BucketsDropped == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("reaper did not run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stored, _, err := kvs.Get([]byte("key"), 0)
+	if err != nil {
+		t.Fatalf("failed to get key: %s", err)
+	}
+	if len(stored) != 0 {
+		t.Fatal("key was not reclaimed")
+	}
+
+	c, err := index.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c.Destroy()
+
+	if _, _, err = c.Read(0); err != nil {
+		t.Fatalf("failed to read cursor: %s", err)
+	}
+	if !c.Eof() {
+		t.Fatal("index entry was not reclaimed")
+	}
+}
+
+func TestTtlCursorForEach(t *testing.T) {
+	var indexParams params
+
+	indexParams.SetCparams(fmt.Sprintf("prefix.length=%d", TTL_INDEX_PFX_LEN))
+	indexParams.SetRparams(txnParams.Rparams...)
+
+	kvs := makeAndOpenKvs(ttlTestKvsName, txnParams)
+	defer kvdb.KvsDrop(ttlTestKvsName)
+	defer kvs.Close()
+
+	index := makeAndOpenKvs(ttlTestIndexKvsName, indexParams)
+	defer kvdb.KvsDrop(ttlTestIndexKvsName)
+	defer index.Close()
+
+	now := time.Unix(1000, 0)
+
+	ttl := NewTtlKvs(kvs, index, time.Second)
+	ttl.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		if err := ttl.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), time.Duration(i+1)*time.Second, 0); err != nil {
+			t.Fatalf("failed to put key%d: %s", i, err)
+		}
+	}
+
+	now = now.Add(5 * time.Second)
+
+	c, err := ttl.CreateCursor(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %s", err)
+	}
+	defer c.Destroy()
+
+	// The seek reads key5 ahead, which ForEach() must still return
+	if _, err = c.Seek([]byte("key0"), 0); err != nil {
+		t.Fatalf("failed to seek: %s", err)
+	}
+
+	var pairs []string
+	err = c.ForEach(func(key []byte, value []byte) error {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to iterate: %s", err)
+	}
+
+	expected := []string{"key5=value5", "key6=value6", "key7=value7", "key8=value8", "key9=value9"}
+	if fmt.Sprint(pairs) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, pairs)
+	}
+}
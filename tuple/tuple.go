@@ -0,0 +1,338 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+// Package tuple implements an order-preserving encoding of tuples into keys
+//
+// Packed tuples sort the same way bytewise as the tuples they encode sort
+// element by element, which makes them suitable for multi-segment keys and
+// for cursor ranges. The encoding is compatible with the FoundationDB tuple
+// layer for the supported element types: nil, []byte, string, integers and
+// bool.
+package tuple
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Tuple is an ordered list of elements
+type Tuple []interface{}
+
+const (
+	codeNil    byte = 0x00
+	codeBytes  byte = 0x01
+	codeString byte = 0x02
+	codeIntZ   byte = 0x14
+	codeFalse  byte = 0x26
+	codeTrue   byte = 0x27
+)
+
+// ErrInvalid is returned when unpacking or parsing malformed input
+var ErrInvalid = errors.New("tuple: invalid encoding")
+
+func packEscaped(buf *bytes.Buffer, code byte, b []byte) {
+	buf.WriteByte(code)
+	for _, c := range b {
+		buf.WriteByte(c)
+		if c == 0x00 {
+			buf.WriteByte(0xff)
+		}
+	}
+	buf.WriteByte(0x00)
+}
+
+func packUint(buf *bytes.Buffer, v uint64, negative bool) {
+	var be [8]byte
+
+	binary.BigEndian.PutUint64(be[:], v)
+
+	n := 8
+	for n > 0 && be[8-n] == 0 {
+		n--
+	}
+
+	if negative {
+		buf.WriteByte(codeIntZ - byte(n))
+		for _, c := range be[8-n:] {
+			buf.WriteByte(^c)
+		}
+	} else {
+		buf.WriteByte(codeIntZ + byte(n))
+		buf.Write(be[8-n:])
+	}
+}
+
+// Pack encodes the tuple
+func (t Tuple) Pack() ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, e := range t {
+		switch v := e.(type) {
+		case nil:
+			buf.WriteByte(codeNil)
+		case []byte:
+			packEscaped(&buf, codeBytes, v)
+		case string:
+			packEscaped(&buf, codeString, []byte(v))
+		case int:
+			packInt(&buf, int64(v))
+		case int32:
+			packInt(&buf, int64(v))
+		case int64:
+			packInt(&buf, v)
+		case uint:
+			packUint(&buf, uint64(v), false)
+		case uint32:
+			packUint(&buf, uint64(v), false)
+		case uint64:
+			packUint(&buf, v, false)
+		case bool:
+			if v {
+				buf.WriteByte(codeTrue)
+			} else {
+				buf.WriteByte(codeFalse)
+			}
+		default:
+			return nil, fmt.Errorf("tuple: unsupported element type %T", e)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func packInt(buf *bytes.Buffer, v int64) {
+	if v >= 0 {
+		packUint(buf, uint64(v), false)
+		return
+	}
+
+	packUint(buf, uint64(-v), true)
+}
+
+func unpackEscaped(b []byte) ([]byte, int, error) {
+	var out []byte
+
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			out = append(out, b[i])
+			continue
+		}
+
+		if i+1 < len(b) && b[i+1] == 0xff {
+			out = append(out, 0x00)
+			i++
+			continue
+		}
+
+		return out, i + 1, nil
+	}
+
+	return nil, 0, ErrInvalid
+}
+
+// Unpack decodes a packed tuple
+//
+// Integers are returned as int64 unless they only fit in a uint64.
+func Unpack(b []byte) (Tuple, error) {
+	t := Tuple{}
+
+	for len(b) > 0 {
+		code := b[0]
+		b = b[1:]
+
+		switch {
+		case code == codeNil:
+			t = append(t, nil)
+		case code == codeBytes || code == codeString:
+			v, n, err := unpackEscaped(b)
+			if err != nil {
+				return nil, err
+			}
+			b = b[n:]
+
+			if code == codeBytes {
+				if v == nil {
+					v = []byte{}
+				}
+				t = append(t, v)
+			} else {
+				t = append(t, string(v))
+			}
+		case code >= codeIntZ-8 && code <= codeIntZ+8:
+			var be [8]byte
+
+			negative := code < codeIntZ
+			n := int(code) - int(codeIntZ)
+			if negative {
+				n = -n
+			}
+			if len(b) < n {
+				return nil, ErrInvalid
+			}
+
+			copy(be[8-n:], b[:n])
+			if negative {
+				for i := 8 - n; i < 8; i++ {
+					be[i] = ^be[i]
+				}
+			}
+			b = b[n:]
+
+			v := binary.BigEndian.Uint64(be[:])
+			switch {
+			case negative && v <= 1<<63:
+				t = append(t, -int64(v))
+			case negative:
+				return nil, ErrInvalid
+			case v > math.MaxInt64:
+				t = append(t, v)
+			default:
+				t = append(t, int64(v))
+			}
+		case code == codeFalse:
+			t = append(t, false)
+		case code == codeTrue:
+			t = append(t, true)
+		default:
+			return nil, ErrInvalid
+		}
+	}
+
+	return t, nil
+}
+
+// String formats the tuple in the syntax accepted by Parse()
+func (t Tuple) String() string {
+	var sb strings.Builder
+
+	sb.WriteByte('(')
+	for i, e := range t {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		switch v := e.(type) {
+		case nil:
+			sb.WriteString("null")
+		case []byte:
+			sb.WriteByte('b')
+			sb.WriteString(strconv.Quote(string(v)))
+		case string:
+			sb.WriteString(strconv.Quote(v))
+		default:
+			fmt.Fprint(&sb, v)
+		}
+	}
+	sb.WriteByte(')')
+
+	return sb.String()
+}
+
+// Parse parses a tuple written as comma-separated elements, optionally within
+// parentheses
+//
+// Strings are Go quoted strings, byte strings are Go quoted strings prefixed
+// with b, and the literals null, true and false are recognized. Anything else
+// must be a decimal integer. For example:
+//
+//	("users", 42, b"\x00\x01", null, true)
+func Parse(s string) (Tuple, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s = s[1 : len(s)-1]
+	}
+
+	t := Tuple{}
+
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return t, nil
+		}
+
+		var e interface{}
+		var err error
+
+		if e, s, err = parseElement(s); err != nil {
+			return nil, err
+		}
+		t = append(t, e)
+
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return t, nil
+		}
+		if s[0] != ',' {
+			return nil, ErrInvalid
+		}
+		s = s[1:]
+	}
+}
+
+// quotedPrefix returns the double-quoted string at the start of s
+func quotedPrefix(s string) string {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return s[:i+1]
+		}
+	}
+
+	return s
+}
+
+func parseElement(s string) (interface{}, string, error) {
+	if s[0] == '"' || strings.HasPrefix(s, `b"`) {
+		isBytes := s[0] == 'b'
+		if isBytes {
+			s = s[1:]
+		}
+
+		quoted := quotedPrefix(s)
+
+		v, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, "", ErrInvalid
+		}
+
+		if isBytes {
+			return []byte(v), s[len(quoted):], nil
+		}
+		return v, s[len(quoted):], nil
+	}
+
+	end := strings.IndexByte(s, ',')
+	if end < 0 {
+		end = len(s)
+	}
+	word := strings.TrimSpace(s[:end])
+	rest := s[end:]
+
+	switch word {
+	case "null":
+		return nil, rest, nil
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+
+	if v, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return v, rest, nil
+	}
+	if v, err := strconv.ParseUint(word, 10, 64); err == nil {
+		return v, rest, nil
+	}
+
+	return nil, "", ErrInvalid
+}
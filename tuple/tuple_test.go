@@ -0,0 +1,79 @@
+/* SPDX-License-Identifier: Apache-2.0 OR MIT
+ *
+ * SPDX-FileCopyrightText: Copyright 2022 Micron Technology, Inc.
+ */
+
+package tuple
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPackUnpack(t *testing.T) {
+	in := Tuple{nil, []byte("a\x00b"), "users", int64(0), int64(-42), int64(math.MinInt64), uint64(math.MaxUint64), true, false}
+
+	packed, err := in.Pack()
+	if err != nil {
+		t.Fatalf("failed to pack tuple: %s", err)
+	}
+
+	out, err := Unpack(packed)
+	if err != nil {
+		t.Fatalf("failed to unpack tuple: %s", err)
+	}
+
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("unpacked tuple %s does not match %s", out, in)
+	}
+}
+
+func TestOrdering(t *testing.T) {
+	tuples := []Tuple{
+		{"a", int64(-300)},
+		{"a", int64(-1)},
+		{"a", int64(0)},
+		{"a", int64(1)},
+		{"a", int64(256)},
+		{"a\x00"},
+		{"b"},
+	}
+
+	packed := make([][]byte, len(tuples))
+	for i, tup := range tuples {
+		p, err := tup.Pack()
+		if err != nil {
+			t.Fatalf("failed to pack %s: %s", tup, err)
+		}
+		packed[i] = p
+	}
+
+	if !sort.SliceIsSorted(packed, func(i, j int) bool { return bytes.Compare(packed[i], packed[j]) < 0 }) {
+		t.Fatal("packed tuples do not sort in tuple order")
+	}
+}
+
+func TestParse(t *testing.T) {
+	in := `("users", 42, b"\x00\x01", null, true, -7)`
+
+	tup, err := Parse(in)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", in, err)
+	}
+
+	expected := Tuple{"users", int64(42), []byte{0, 1}, nil, true, int64(-7)}
+	if !reflect.DeepEqual(tup, expected) {
+		t.Fatalf("parsed tuple %s does not match %s", tup, expected)
+	}
+
+	if tup.String() != in {
+		t.Fatalf("formatted tuple %s does not match %s", tup.String(), in)
+	}
+
+	if _, err = Parse(`("unterminated)`); err == nil {
+		t.Fatal("parsed an unterminated string")
+	}
+}